@@ -1,5 +1,13 @@
 package auth
 
+// Scopes a token may be given.
+const (
+	ScopeParse   = "parse"   // Parse text.
+	ScopeStatus  = "status"  // Read the server status and statistics.
+	ScopeAdmin   = "admin"   // Manage tokens.
+	ScopeProfile = "profile" // Use the profiler.
+)
+
 const (
 	validToken   = "3A3E6C4C51F12DF2415682CCF9D18"
 	invalidToken = "8A95585DD5B64E33D5BF4C8F4E849"
@@ -12,22 +20,105 @@ var (
 	}
 )
 
-// Auth is a provider of auth token management/lookup.
-// TODO this is stubbed and needs to be DB/cache enabled.
+// Limits are the usage limits of a token. Zero values are unlimited.
+type Limits struct {
+	RequestsPerSec float64 `json:"requestsPerSec"` // Requests per second allowed.
+	Burst          int     `json:"burst"`          // Requests allowed at once over the rate.
+	DailyBytes     int64   `json:"dailyBytes"`     // Bytes of parse input allowed per UTC day.
+	MaxDocBytes    int64   `json:"maxDocBytes"`    // Size of the largest document to parse.
+}
+
+// Identity is who a token belongs to and what it may be used for.
+type Identity struct {
+	Subject   string                 `json:"subject"`          // Who the token was issued to (empty for static tokens).
+	Scopes    []string               `json:"scopes"`           // What the token may be used for.
+	AllScopes bool                   `json:"allScopes"`        // The token may be used for anything.
+	Premium   bool                   `json:"premium"`          // Requests are given priority.
+	Limits    Limits                 `json:"limits"`           // Usage limits of the token.
+	Claims    map[string]interface{} `json:"claims,omitempty"` // Claims of a JWT.
+}
+
+// HasScope returns true if the identity may be used for scope.
+func (id *Identity) HasScope(scope string) bool {
+	return id.AllScopes || hasScope(id.Scopes, scope)
+}
+
+// Provider is a source of tokens, such as a token store or a JWT issuer.
+type Provider interface {
+	// Identify returns the identity of a valid token.
+	Identify(tk string) (*Identity, bool)
+}
+
+// Auth is a provider of auth token management/lookup. The static Tokens are checked first, then
+// the optional Store, then the other Providers in order.
 type Auth struct {
-	Tokens map[string]bool
+	Tokens    map[string]bool
+	Limits    map[string]Limits   // Usage limits of tokens; tokens not listed are unlimited.
+	Scopes    map[string][]string // Scopes of tokens; tokens not listed may only parse.
+	Store     *Store              // Optional persistent store of tokens.
+	Providers []Provider          // Other sources of tokens e.g. a JWTProvider.
 }
 
 // New is a factory method that returns an instance of Auth.
 func New() *Auth {
-	return &Auth{Tokens: tokens}
+	return &Auth{
+		Tokens: tokens,
+		Limits: make(map[string]Limits),
+		Scopes: make(map[string][]string),
+	}
+}
+
+// Identify is an implementation of the Provider interface.
+func (t *Auth) Identify(tk string) (*Identity, bool) {
+	if a, ok := t.Tokens[tk]; ok {
+		if !a {
+			return nil, false
+		}
+		scopes, listed := t.Scopes[tk]
+		if !listed {
+			scopes = []string{ScopeParse}
+		}
+		return &Identity{
+			Scopes: scopes,
+			Limits: t.Limits[tk],
+		}, true
+	}
+	if t.Store != nil {
+		if id, ok := t.Store.Identify(tk); ok {
+			return id, true
+		}
+	}
+	for _, p := range t.Providers {
+		if id, ok := p.Identify(tk); ok {
+			return id, true
+		}
+	}
+	return nil, false
 }
 
 // Valid returns true if the token was found and is valid.
 func (t *Auth) Valid(tk string) bool {
-	a, ok := t.Tokens[tk]
-	if !ok {
-		return false
+	_, ok := t.Identify(tk)
+	return ok
+}
+
+// TokenLimits returns the usage limits of the token.
+func (t *Auth) TokenLimits(tk string) Limits {
+	if l, ok := t.Limits[tk]; ok {
+		return l
+	}
+	if id, ok := t.Identify(tk); ok {
+		return id.Limits
+	}
+	return Limits{}
+}
+
+// hasScope returns true if scope is in the list.
+func hasScope(scopes []string, scope string) bool {
+	for _, sc := range scopes {
+		if sc == scope {
+			return true
+		}
 	}
-	return a
+	return false
 }
@@ -1,8 +1,13 @@
 package auth
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 const (
@@ -56,3 +61,95 @@ func TestValid(t *testing.T) {
 		t.Errorf("Missing token validated true.")
 	}
 }
+
+func TestTokenLimits(t *testing.T) {
+	a := New()
+	a.Tokens[tValidToken] = true
+	a.Limits[tValidToken] = Limits{RequestsPerSec: 5, Burst: 10, DailyBytes: 1000, MaxDocBytes: 100}
+
+	if l := a.TokenLimits(tValidToken); l.RequestsPerSec != 5 || l.Burst != 10 ||
+		l.DailyBytes != 1000 || l.MaxDocBytes != 100 {
+		t.Errorf("Token limits not returned: %+v", l)
+	}
+	if l := a.TokenLimits(validToken); l != (Limits{}) {
+		t.Errorf("Token without limits should be unlimited: %+v", l)
+	}
+}
+
+// tokenHasScope returns true if the token is valid and may be used for scope.
+func tokenHasScope(a *Auth, tk string, scope string) bool {
+	id, ok := a.Identify(tk)
+	return ok && id.HasScope(scope)
+}
+
+func TestHasScope(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clidemo-store")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := New()
+	a.Tokens[tValidToken] = true
+	a.Tokens[tInvalidToken] = false
+	if !tokenHasScope(a, tValidToken, ScopeParse) || tokenHasScope(a, tValidToken, ScopeAdmin) {
+		t.Errorf("Static token without listed scopes should only parse.")
+	}
+	if tokenHasScope(a, tInvalidToken, ScopeAdmin) {
+		t.Errorf("Invalid token has a scope.")
+	}
+
+	a.Store, _ = OpenStore(filepath.Join(dir, "tokens.json"))
+	tk, _, _ := a.Store.Create("ci", []string{"parse"}, 0, func(r *Record) {
+		r.Limits = Limits{MaxDocBytes: 100}
+	})
+	if !a.Valid(tk) || !tokenHasScope(a, tk, "parse") || tokenHasScope(a, tk, ScopeAdmin) {
+		t.Errorf("Stored token scopes not checked.")
+	}
+	if a.TokenLimits(tk).MaxDocBytes != 100 {
+		t.Errorf("Stored token limits not returned.")
+	}
+}
+
+func TestStaticScopes(t *testing.T) {
+	a := New()
+	a.Tokens[tValidToken] = true
+	a.Scopes[tValidToken] = []string{ScopeParse}
+
+	if !tokenHasScope(a, tValidToken, ScopeParse) {
+		t.Errorf("Token scope not found.")
+	}
+	if tokenHasScope(a, tValidToken, ScopeStatus) {
+		t.Errorf("Token has a scope not given to it.")
+	}
+	if !tokenHasScope(a, validToken, ScopeParse) || tokenHasScope(a, validToken, ScopeStatus) ||
+		tokenHasScope(a, validToken, ScopeAdmin) || tokenHasScope(a, validToken, ScopeProfile) {
+		t.Errorf("Token without listed scopes should only parse.")
+	}
+}
+
+func TestProviders(t *testing.T) {
+	a := New()
+	p := JWTProviderNew(func(p *JWTProvider) { p.Keys = []JWTKey{{Key: testJWTSecret}} })
+	a.Providers = append(a.Providers, p)
+	tk := signTestJWT(t, AlgHS256, "", testJWTSecret, testClaims(time.Now()))
+
+	id, ok := a.Identify(tk)
+	if !ok || id.Subject != "partner-team" {
+		t.Fatalf("Provider token not identified.")
+	}
+	if !tokenHasScope(a, tk, ScopeParse) || tokenHasScope(a, tk, ScopeAdmin) {
+		t.Errorf("Provider token scopes not checked.")
+	}
+	if id, ok := a.Identify(validToken); !ok || id.Subject != "" {
+		t.Errorf("Static token should be identified before the providers.")
+	}
+
+	ctx := NewContext(context.Background(), id)
+	if got, ok := FromContext(ctx); !ok || got != id {
+		t.Errorf("Identity not carried by the context.")
+	}
+	if _, ok := FromContext(context.Background()); ok {
+		t.Errorf("Identity found in an empty context.")
+	}
+}
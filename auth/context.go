@@ -0,0 +1,19 @@
+package auth
+
+import "context"
+
+// contextKey is the type of the keys of values in a context.
+type contextKey int
+
+const identityKey contextKey = 0
+
+// NewContext returns a copy of ctx carrying the identity of a request.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey, id)
+}
+
+// FromContext returns the identity carried by ctx, if any.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey).(*Identity)
+	return id, ok && id != nil
+}
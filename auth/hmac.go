@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HMAC request signing. A client signs the method, path, query, time, a nonce and the digest of
+// the body of each request with a secret it shares with the server, so a captured request
+// cannot be replayed or altered:
+//
+//	Authorization: CLIDEMO-HMAC-SHA256 KeyId=<id>, Signature=<hex HMAC-SHA256 of StringToSign>
+//	X-Clidemo-Date: <unix seconds>
+//	X-Clidemo-Nonce: <unique per request>
+const (
+	HMACScheme  = "CLIDEMO-HMAC-SHA256"
+	HeaderDate  = "X-Clidemo-Date"
+	HeaderNonce = "X-Clidemo-Nonce"
+
+	// Default difference allowed between the time of a signed request and the server clock.
+	DefaultHMACSkew = 5 * time.Minute
+
+	// How often the nonces of requests outside the skew window are forgotten.
+	nonceSweepInterval = time.Minute
+)
+
+var (
+	ErrHMACMalformed  = errors.New("Signed request is malformed.")
+	ErrHMACUnknownKey = errors.New("Signing key not found.")
+	ErrHMACSignature  = errors.New("Request signature is invalid.")
+	ErrHMACSkew       = errors.New("Signed request time is outside the allowed skew.")
+	ErrHMACReplay     = errors.New("Signed request nonce was already used.")
+)
+
+// HMACKey is a secret shared with a client to sign requests, and what the client may do.
+type HMACKey struct {
+	ID      string   `json:"keyId"`   // Public id of the key, sent with each request.
+	Secret  string   `json:"secret"`  // The shared secret.
+	Scopes  []string `json:"scopes"`  // What the client may use the API for.
+	Premium bool     `json:"premium"` // Requests are given priority.
+	Limits  Limits   `json:"limits"`  // Usage limits of the client.
+}
+
+// LoadHMACKeys returns the keys in a json file of a list of HMACKey.
+func LoadHMACKeys(path string) ([]HMACKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []HMACKey
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// StringToSign returns the canonical form of a request that is signed.
+func StringToSign(method string, path string, query string, date string, nonce string,
+	bodyHash string) string {
+	return strings.Join([]string{HMACScheme, method, path, query, date, nonce, bodyHash}, "\n")
+}
+
+// BodyHash returns the hex SHA-256 digest of a request body.
+func BodyHash(body []byte) string {
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:])
+}
+
+// SignHMAC returns the hex signature of a string to sign with secret.
+func SignHMAC(secret []byte, stringToSign string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsHMACAuthorization returns true if an Authorization header value is for a signed request.
+func IsHMACAuthorization(h string) bool {
+	return strings.HasPrefix(h, HMACScheme+" ")
+}
+
+// parseHMACAuthorization returns the key id and signature of an Authorization header value.
+func parseHMACAuthorization(h string) (keyID string, sig string, ok bool) {
+	if !IsHMACAuthorization(h) {
+		return "", "", false
+	}
+	for _, p := range strings.Split(strings.TrimPrefix(h, HMACScheme+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 {
+			return "", "", false
+		}
+		switch kv[0] {
+		case "KeyId":
+			keyID = kv[1]
+		case "Signature":
+			sig = kv[1]
+		}
+	}
+	return keyID, sig, keyID != "" && sig != ""
+}
+
+// HMACVerifier verifies signed requests: the signature, that the time is within the skew of the
+// server clock, and that the nonce has not been used within that window.
+type HMACVerifier struct {
+	Skew time.Duration // Difference allowed between the request time and the server clock.
+
+	keys      map[string]HMACKey
+	mu        sync.Mutex           // For locking access to the nonces.
+	nonces    map[string]time.Time // Used nonces by key, until they fall out of the window.
+	lastSweep time.Time
+}
+
+// HMACVerifierNew is a factory function that returns a verifier of requests signed with keys.
+func HMACVerifierNew(keys []HMACKey, options ...func(*HMACVerifier)) *HMACVerifier {
+	v := &HMACVerifier{
+		Skew:      DefaultHMACSkew,
+		keys:      make(map[string]HMACKey, len(keys)),
+		nonces:    make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+	for _, k := range keys {
+		v.keys[k.ID] = k
+	}
+	for _, f := range options {
+		f(v)
+	}
+	return v
+}
+
+// Verify checks the signature of request r with body at time now and returns the identity of
+// its key. The subject is the key id.
+func (v *HMACVerifier) Verify(r *http.Request, body []byte, now time.Time) (*Identity, error) {
+	keyID, sig, ok := parseHMACAuthorization(r.Header.Get("Authorization"))
+	date, nonce := r.Header.Get(HeaderDate), r.Header.Get(HeaderNonce)
+	if !ok || date == "" || nonce == "" {
+		return nil, ErrHMACMalformed
+	}
+	sec, err := strconv.ParseInt(date, 10, 64)
+	if err != nil {
+		return nil, ErrHMACMalformed
+	}
+	k, ok := v.keys[keyID]
+	if !ok {
+		return nil, ErrHMACUnknownKey
+	}
+	s := StringToSign(r.Method, r.URL.EscapedPath(), r.URL.RawQuery, date, nonce, BodyHash(body))
+	if !hmac.Equal([]byte(SignHMAC([]byte(k.Secret), s)), []byte(strings.ToLower(sig))) {
+		return nil, ErrHMACSignature
+	}
+	t := time.Unix(sec, 0)
+	if t.Before(now.Add(-v.Skew)) || t.After(now.Add(v.Skew)) {
+		return nil, ErrHMACSkew
+	}
+	if !v.useNonce(keyID+":"+nonce, t.Add(v.Skew), now) {
+		return nil, ErrHMACReplay
+	}
+	return &Identity{
+		Subject: k.ID,
+		Scopes:  k.Scopes,
+		Premium: k.Premium,
+		Limits:  k.Limits,
+	}, nil
+}
+
+// useNonce records a nonce until it expires. It returns false if it was already used.
+func (v *HMACVerifier) useNonce(nonce string, expires time.Time, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if now.Sub(v.lastSweep) >= nonceSweepInterval {
+		v.lastSweep = now
+		for n, exp := range v.nonces {
+			if now.After(exp) {
+				delete(v.nonces, n)
+			}
+		}
+	}
+	if exp, used := v.nonces[nonce]; used && !now.After(exp) {
+		return false
+	}
+	v.nonces[nonce] = expires
+	return true
+}
+
+// Len returns the number of nonces remembered.
+func (v *HMACVerifier) Len() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.nonces)
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testHMACKey = HMACKey{ID: "partner", Secret: "a shared secret", Scopes: []string{ScopeParse}}
+
+// signTestRequest returns a request signed with secret at date with nonce.
+func signTestRequest(method string, target string, body string, date time.Time, nonce string,
+	secret string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	d := strconv.FormatInt(date.Unix(), 10)
+	s := StringToSign(method, r.URL.EscapedPath(), r.URL.RawQuery, d, nonce, BodyHash([]byte(body)))
+	r.Header.Set(HeaderDate, d)
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s, Signature=%s", HMACScheme, testHMACKey.ID,
+		SignHMAC([]byte(secret), s)))
+	return r
+}
+
+func TestHMACVerifier(t *testing.T) {
+	v := HMACVerifierNew([]HMACKey{testHMACKey})
+	now := time.Now()
+	const body = `{"text":"Some text."}`
+
+	r := signTestRequest("POST", "/v1.0/parse?x=1", body, now, "n1", testHMACKey.Secret)
+	id, err := v.Verify(r, []byte(body), now)
+	if err != nil {
+		t.Fatalf("Signed request not verified: %s", err)
+	}
+	if id.Subject != "partner" || !id.HasScope(ScopeParse) || id.HasScope(ScopeStatus) {
+		t.Errorf("Identity of the key not returned: %+v", id)
+	}
+	if _, err := v.Verify(r, []byte(body), now); err != ErrHMACReplay {
+		t.Errorf("Replayed request should be refused: %v", err)
+	}
+
+	invalid := func(name string, r *http.Request, body string, want error) {
+		if _, err := v.Verify(r, []byte(body), now); err != want {
+			t.Errorf("%s: expected %v, got %v", name, want, err)
+		}
+	}
+	invalid("Altered body", signTestRequest("POST", "/v1.0/parse", body, now, "n2", testHMACKey.Secret),
+		`{"text":"Other text."}`, ErrHMACSignature)
+	r = signTestRequest("POST", "/v1.0/parse", body, now, "n3", testHMACKey.Secret)
+	r.URL.Path = "/v1.0/status"
+	invalid("Altered path", r, body, ErrHMACSignature)
+	invalid("Wrong secret", signTestRequest("POST", "/v1.0/parse", body, now, "n4", "guess"), body, ErrHMACSignature)
+	invalid("Old request", signTestRequest("POST", "/v1.0/parse", body, now.Add(-10*time.Minute), "n5",
+		testHMACKey.Secret), body, ErrHMACSkew)
+	invalid("Future request", signTestRequest("POST", "/v1.0/parse", body, now.Add(10*time.Minute), "n6",
+		testHMACKey.Secret), body, ErrHMACSkew)
+	r = signTestRequest("POST", "/v1.0/parse", body, now, "n7", testHMACKey.Secret)
+	r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), "partner", "nobody", 1))
+	invalid("Unknown key", r, body, ErrHMACUnknownKey)
+	r = signTestRequest("POST", "/v1.0/parse", body, now, "n8", testHMACKey.Secret)
+	r.Header.Del(HeaderNonce)
+	invalid("No nonce", r, body, ErrHMACMalformed)
+
+	// Nonces are forgotten once their requests fall outside the skew window.
+	later := now.Add(2*v.Skew + nonceSweepInterval)
+	v.useNonce("sweep", later, later)
+	if v.Len() != 1 {
+		t.Errorf("Expired nonces not forgotten: %d", v.Len())
+	}
+}
+
+func TestLoadHMACKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clidemo-hmac")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+	ioutil.WriteFile(path, []byte(`[{"keyId":"partner","secret":"s","scopes":["parse"],"limits":{"burst":2}}]`), 0600)
+
+	keys, err := LoadHMACKeys(path)
+	if err != nil || len(keys) != 1 || keys[0].ID != "partner" || keys[0].Secret != "s" ||
+		keys[0].Limits.Burst != 2 {
+		t.Errorf("Keys not loaded: %+v %v", keys, err)
+	}
+	if !IsHMACAuthorization(HMACScheme+" KeyId=a, Signature=b") || IsHMACAuthorization("Bearer x") {
+		t.Errorf("Signed request authorization not recognised.")
+	}
+}
@@ -0,0 +1,337 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Signing algorithms accepted for a JWT.
+const (
+	AlgHS256 = "HS256" // HMAC with SHA-256 and a shared secret.
+	AlgRS256 = "RS256" // RSA PKCS #1 v1.5 with SHA-256.
+	AlgES256 = "ES256" // ECDSA on P-256 with SHA-256.
+)
+
+// Time allowed for clock differences with the issuer when checking exp and nbf.
+const DefaultJWTLeeway = 30 * time.Second
+
+var (
+	ErrJWTMalformed  = errors.New("JWT is malformed.")
+	ErrJWTAlgorithm  = errors.New("JWT signing algorithm not supported.")
+	ErrJWTSignature  = errors.New("JWT signature is invalid.")
+	ErrJWTExpired    = errors.New("JWT has expired.")
+	ErrJWTNotYet     = errors.New("JWT is not valid yet.")
+	ErrJWTAudience   = errors.New("JWT audience is invalid.")
+	ErrJWTIssuer     = errors.New("JWT issuer is invalid.")
+	ErrJWTNoKeys     = errors.New("No JWT keys found.")
+	ErrJWTKeyInvalid = errors.New("JWT key is invalid.")
+)
+
+// JWTKey is a key that verifies JWT signatures: a []byte secret for HS256, an *rsa.PublicKey
+// for RS256 or an *ecdsa.PublicKey for ES256.
+type JWTKey struct {
+	ID  string      // Key id matched against the kid header (empty matches any).
+	Key interface{} // The key.
+}
+
+// jwtClaims are the registered claims of a JWT that are checked.
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  audience    `json:"aud"`
+	ExpiresAt json.Number `json:"exp"`
+	NotBefore json.Number `json:"nbf"`
+	Scope     string      `json:"scope"`   // Space separated scopes (RFC 8693).
+	Scopes    []string    `json:"scopes"`  // Or a list of scopes.
+	Premium   bool        `json:"premium"` // Requests are given priority.
+}
+
+// audience is the aud claim, either a string or a list of strings.
+type audience []string
+
+// UnmarshalJSON is an implementation of the json.Unmarshaler interface.
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// JWTProvider is a provider of identities from JSON Web Tokens signed by an issuer. Tokens must
+// have an exp claim; nbf, aud and iss are checked when present or configured.
+type JWTProvider struct {
+	Keys     []JWTKey      // Keys the issuer signs with.
+	Issuer   string        // Required iss claim (empty = any).
+	Audience string        // Required aud claim (empty = any).
+	Leeway   time.Duration // Allowed clock difference with the issuer.
+	Limits   Limits        // Usage limits of each subject.
+}
+
+// JWTProviderNew is a factory function that returns a new JWT provider.
+func JWTProviderNew(options ...func(*JWTProvider)) *JWTProvider {
+	p := &JWTProvider{Leeway: DefaultJWTLeeway}
+	for _, f := range options {
+		f(p)
+	}
+	return p
+}
+
+// Identify is an implementation of the Provider interface.
+func (p *JWTProvider) Identify(tk string) (*Identity, bool) {
+	id, err := p.Verify(tk, time.Now())
+	return id, err == nil
+}
+
+// Verify checks the signature and claims of a token at time now and returns its identity.
+func (p *JWTProvider) Verify(tk string, now time.Time) (*Identity, error) {
+	parts := strings.Split(tk, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if err := p.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	var raw map[string]interface{}
+	if decodeSegment(parts[1], &claims) != nil || decodeSegment(parts[1], &raw) != nil {
+		return nil, ErrJWTMalformed
+	}
+	exp, err := claims.ExpiresAt.Float64()
+	if err != nil {
+		return nil, ErrJWTExpired // Tokens that never expire are not accepted.
+	}
+	if !now.Before(unixTime(exp).Add(p.Leeway)) {
+		return nil, ErrJWTExpired
+	}
+	if claims.NotBefore != "" {
+		nbf, err := claims.NotBefore.Float64()
+		if err != nil {
+			return nil, ErrJWTMalformed
+		}
+		if now.Add(p.Leeway).Before(unixTime(nbf)) {
+			return nil, ErrJWTNotYet
+		}
+	}
+	if p.Issuer != "" && claims.Issuer != p.Issuer {
+		return nil, ErrJWTIssuer
+	}
+	if p.Audience != "" && !hasScope(claims.Audience, p.Audience) {
+		return nil, ErrJWTAudience
+	}
+
+	scopes := claims.Scopes
+	if claims.Scope != "" {
+		scopes = append(scopes, strings.Fields(claims.Scope)...)
+	}
+	return &Identity{
+		Subject: claims.Subject,
+		Scopes:  scopes,
+		Premium: claims.Premium,
+		Limits:  p.Limits,
+		Claims:  raw,
+	}, nil
+}
+
+// verifySignature checks sig over the signed part of a token with the keys for the algorithm.
+func (p *JWTProvider) verifySignature(alg string, kid string, signed string, sig []byte) error {
+	if alg != AlgHS256 && alg != AlgRS256 && alg != AlgES256 {
+		return ErrJWTAlgorithm
+	}
+	h := sha256.Sum256([]byte(signed))
+	for _, k := range p.Keys {
+		if kid != "" && k.ID != "" && k.ID != kid {
+			continue
+		}
+		switch key := k.Key.(type) {
+		case []byte:
+			if alg != AlgHS256 {
+				continue
+			}
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(signed))
+			if hmac.Equal(mac.Sum(nil), sig) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if alg == AlgRS256 && rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if alg != AlgES256 || key.Curve != elliptic.P256() || len(sig) != 64 {
+				continue
+			}
+			r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			if ecdsa.Verify(key, h[:], r, s) {
+				return nil
+			}
+		}
+	}
+	return ErrJWTSignature
+}
+
+// unixTime returns the time of a NumericDate claim in seconds since the epoch.
+func unixTime(sec float64) time.Time {
+	return time.Unix(0, int64(sec*float64(time.Second)))
+}
+
+// decodeSegment decodes a base64url encoded json segment of a token into v.
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// LoadJWTKeys returns the public keys in a PEM file or a JWKS json file.
+func LoadJWTKeys(path string) ([]JWTKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] == '{' {
+		return ParseJWKS(t)
+	}
+	return ParsePEMKeys(b)
+}
+
+// ParsePEMKeys returns the public keys and certificates in PEM encoded data.
+func ParsePEMKeys(b []byte) ([]JWTKey, error) {
+	var keys []JWTKey
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, JWTKey{Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, ErrJWTNoKeys
+	}
+	return keys, nil
+}
+
+// jwk is a JSON Web Key (RFC 7517).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`   // RSA modulus.
+	E   string `json:"e"`   // RSA exponent.
+	Crv string `json:"crv"` // EC curve.
+	X   string `json:"x"`   // EC point.
+	Y   string `json:"y"`
+	K   string `json:"k"` // Symmetric key.
+}
+
+// ParseJWKS returns the signing keys in a JSON Web Key Set. Keys for encryption and of unknown
+// types are skipped.
+func ParseJWKS(b []byte) ([]JWTKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+	var keys []JWTKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key interface{}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				return nil, ErrJWTKeyInvalid
+			}
+			key = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if k.Crv != "P-256" || errX != nil || errY != nil {
+				return nil, ErrJWTKeyInvalid
+			}
+			pub := &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+			if _, err := pub.ECDH(); err != nil { // Checks the point is on the curve.
+				return nil, ErrJWTKeyInvalid
+			}
+			key = pub
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return nil, ErrJWTKeyInvalid
+			}
+			key = secret
+		default:
+			continue
+		}
+		keys = append(keys, JWTKey{ID: k.Kid, Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, ErrJWTNoKeys
+	}
+	return keys, nil
+}
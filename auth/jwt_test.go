@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testJWTSecret = []byte("a shared secret of the test issuer")
+
+// signTestJWT returns a token with the claims signed with key using alg.
+func signTestJWT(t *testing.T, alg string, kid string, key interface{}, claims map[string]interface{}) string {
+	hb, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	cb, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+	h := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:]); err != nil {
+			t.Fatalf("Cannot sign with RSA: %s", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, h[:])
+		if err != nil {
+			t.Fatalf("Cannot sign with ECDSA: %s", err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// testClaims returns valid claims at now.
+func testClaims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "partner-team",
+		"iss":   "https://issuer.example.com",
+		"aud":   []string{"clidemo", "other"},
+		"exp":   now.Add(time.Hour).Unix(),
+		"nbf":   now.Add(-time.Minute).Unix(),
+		"scope": "parse status",
+	}
+}
+
+func TestJWTProviderVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p := JWTProviderNew(func(p *JWTProvider) {
+		p.Keys = []JWTKey{
+			{ID: "hs", Key: testJWTSecret},
+			{ID: "rs", Key: &rsaKey.PublicKey},
+			{ID: "es", Key: &ecKey.PublicKey},
+		}
+		p.Issuer = "https://issuer.example.com"
+		p.Audience = "clidemo"
+		p.Limits = Limits{RequestsPerSec: 5}
+	})
+	now := time.Now()
+
+	for _, tc := range []struct {
+		alg string
+		kid string
+		key interface{}
+	}{
+		{AlgHS256, "hs", testJWTSecret},
+		{AlgRS256, "rs", rsaKey},
+		{AlgES256, "es", ecKey},
+		{AlgRS256, "", rsaKey}, // No kid tries every key.
+	} {
+		id, err := p.Verify(signTestJWT(t, tc.alg, tc.kid, tc.key, testClaims(now)), now)
+		if err != nil {
+			t.Errorf("%s token not verified: %s", tc.alg, err)
+			continue
+		}
+		if id.Subject != "partner-team" || !id.HasScope(ScopeParse) || !id.HasScope(ScopeStatus) ||
+			id.HasScope(ScopeAdmin) || id.Limits.RequestsPerSec != 5 || id.Claims["iss"] == nil {
+			t.Errorf("%s token identity not returned: %+v", tc.alg, id)
+		}
+	}
+
+	invalid := func(name string, tk string, want error) {
+		if _, err := p.Verify(tk, now); err != want {
+			t.Errorf("%s: expected %v, got %v", name, want, err)
+		}
+	}
+	claims := func(f func(c map[string]interface{})) map[string]interface{} {
+		c := testClaims(now)
+		f(c)
+		return c
+	}
+	invalid("Expired", signTestJWT(t, AlgHS256, "hs", testJWTSecret, claims(func(c map[string]interface{}) {
+		c["exp"] = now.Add(-time.Minute).Unix()
+	})), ErrJWTExpired)
+	invalid("No exp", signTestJWT(t, AlgHS256, "hs", testJWTSecret, claims(func(c map[string]interface{}) {
+		delete(c, "exp")
+	})), ErrJWTExpired)
+	invalid("Not yet valid", signTestJWT(t, AlgHS256, "hs", testJWTSecret, claims(func(c map[string]interface{}) {
+		c["nbf"] = now.Add(time.Minute).Unix()
+	})), ErrJWTNotYet)
+	invalid("Audience", signTestJWT(t, AlgHS256, "hs", testJWTSecret, claims(func(c map[string]interface{}) {
+		c["aud"] = "someone-else"
+	})), ErrJWTAudience)
+	invalid("Issuer", signTestJWT(t, AlgHS256, "hs", testJWTSecret, claims(func(c map[string]interface{}) {
+		c["iss"] = "https://evil.example.com"
+	})), ErrJWTIssuer)
+	invalid("Wrong secret", signTestJWT(t, AlgHS256, "hs", []byte("guess"), testClaims(now)), ErrJWTSignature)
+	invalid("Wrong kid", signTestJWT(t, AlgRS256, "es", rsaKey, testClaims(now)), ErrJWTSignature)
+	invalid("None", signTestJWT(t, "none", "", nil, testClaims(now)), ErrJWTAlgorithm)
+	invalid("Malformed", "not.a-token", ErrJWTMalformed)
+
+	// An RSA public key must not be usable as an HMAC secret.
+	pub, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	invalid("Algorithm confusion", signTestJWT(t, AlgHS256, "rs", pub, testClaims(now)), ErrJWTSignature)
+
+	// Clocks may differ by the leeway.
+	if _, err := p.Verify(signTestJWT(t, AlgHS256, "hs", testJWTSecret, claims(func(c map[string]interface{}) {
+		c["exp"] = now.Add(-10 * time.Second).Unix()
+	})), now); err != nil {
+		t.Errorf("Token expired within the leeway not accepted: %s", err)
+	}
+}
+
+func TestLoadJWTKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clidemo-jwt")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+
+	// PEM with a PKIX key and a PKCS #1 key.
+	pkix, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	pemData := append(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)})...)
+	pemPath := filepath.Join(dir, "keys.pem")
+	ioutil.WriteFile(pemPath, pemData, 0600)
+	keys, err := LoadJWTKeys(pemPath)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("PEM keys not loaded: %d %v", len(keys), err)
+	}
+	p := JWTProviderNew(func(p *JWTProvider) { p.Keys = keys })
+	for _, tk := range []string{
+		signTestJWT(t, AlgES256, "", ecKey, testClaims(now)),
+		signTestJWT(t, AlgRS256, "", rsaKey, testClaims(now)),
+	} {
+		if _, ok := p.Identify(tk); !ok {
+			t.Errorf("Token not verified with the PEM keys.")
+		}
+	}
+
+	// JWKS with RSA, EC and symmetric keys; keys for encryption are skipped.
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rs","use":"sig","n":%q,"e":%q},
+		{"kty":"EC","kid":"es","crv":"P-256","x":%q,"y":%q},
+		{"kty":"oct","kid":"hs","k":%q},
+		{"kty":"RSA","kid":"enc","use":"enc","n":"AQAB","e":"AQAB"}]}`,
+		b64(rsaKey.N.Bytes()), b64([]byte{1, 0, 1}),
+		b64(ecKey.X.FillBytes(make([]byte, 32))), b64(ecKey.Y.FillBytes(make([]byte, 32))),
+		b64(testJWTSecret))
+	jwksPath := filepath.Join(dir, "jwks.json")
+	ioutil.WriteFile(jwksPath, []byte(jwks), 0600)
+	keys, err = LoadJWTKeys(jwksPath)
+	if err != nil || len(keys) != 3 {
+		t.Fatalf("JWKS keys not loaded: %d %v", len(keys), err)
+	}
+	p = JWTProviderNew(func(p *JWTProvider) { p.Keys = keys })
+	for _, tk := range []string{
+		signTestJWT(t, AlgRS256, "rs", rsaKey, testClaims(now)),
+		signTestJWT(t, AlgES256, "es", ecKey, testClaims(now)),
+		signTestJWT(t, AlgHS256, "hs", testJWTSecret, testClaims(now)),
+	} {
+		if _, ok := p.Identify(tk); !ok {
+			t.Errorf("Token not verified with the JWKS keys.")
+		}
+	}
+
+	if _, err := ParseJWKS([]byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`)); err != ErrJWTKeyInvalid {
+		t.Errorf("Point not on the curve should be invalid: %v", err)
+	}
+	if _, err := ParsePEMKeys([]byte("no keys here")); err != ErrJWTNoKeys {
+		t.Errorf("File without keys should fail: %v", err)
+	}
+}
@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	tokenBytes = 24 // Random bytes in a new token.
+	idBytes    = 8  // Random bytes in a new token id.
+
+	// How often a store looks for changes to its file made by another process.
+	storeReloadInterval = time.Second
+)
+
+var (
+	ErrTokenNotFound = errors.New("Token not found.")
+)
+
+// Record is a token kept in a Store. Only a hash of the token is stored; the token itself is
+// shown once, when it is created.
+type Record struct {
+	ID      string     `json:"id"`                // Public id to list and revoke the token by.
+	Hash    string     `json:"hash"`              // SHA-256 of the token in hex.
+	Label   string     `json:"label"`             // What the token is for.
+	Scopes  []string   `json:"scopes"`            // What the token may be used for.
+	Premium bool       `json:"premium"`           // Requests are given priority.
+	Limits  Limits     `json:"limits"`            // Usage limits of the token.
+	Created time.Time  `json:"created"`           // When the token was created.
+	Expires *time.Time `json:"expires,omitempty"` // When the token stops being valid.
+	Revoked *time.Time `json:"revoked,omitempty"` // When the token was revoked.
+}
+
+// Active returns true if the token is neither expired nor revoked at time now.
+func (r *Record) Active(now time.Time) bool {
+	return r.Revoked == nil && (r.Expires == nil || now.Before(*r.Expires))
+}
+
+// Store is an embedded, file-backed store of tokens. Changes are written to the file at once,
+// and changes made by another process, such as the token command, are picked up.
+type Store struct {
+	mu        sync.Mutex
+	path      string
+	records   map[string]*Record // By hash.
+	file      os.FileInfo        // Of the file when last read or written.
+	lastCheck time.Time          // When the file was last checked for changes.
+	err       error              // Why the file could not be read when last checked.
+}
+
+// OpenStore is a factory function that returns the store in the file at path. The file is
+// created when the first token is added.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		records: make(map[string]*Record),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// HashToken returns the hash of a token as it is kept in a store.
+func HashToken(tk string) string {
+	h := sha256.Sum256([]byte(tk))
+	return hex.EncodeToString(h[:])
+}
+
+// Create adds a new token to the store, returning the token and its record. A ttl <= 0 never
+// expires.
+func (s *Store) Create(label string, scopes []string, ttl time.Duration,
+	options ...func(*Record)) (string, *Record, error) {
+	tk, err := randomHex(tokenBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := randomHex(idBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now().UTC()
+	r := &Record{
+		ID:      id,
+		Hash:    HashToken(tk),
+		Label:   label,
+		Scopes:  scopes,
+		Created: now,
+	}
+	if ttl > 0 {
+		exp := now.Add(ttl)
+		r.Expires = &exp
+	}
+	for _, f := range options {
+		f(r)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	s.records[r.Hash] = r
+	if err := s.save(); err != nil {
+		delete(s.records, r.Hash)
+		return "", nil, err
+	}
+	rc := *r
+	return tk, &rc, nil
+}
+
+// Lookup returns the record of an active token.
+func (s *Store) Lookup(tk string) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reload()
+	r, ok := s.records[HashToken(tk)]
+	if !ok || !r.Active(time.Now()) {
+		return Record{}, false
+	}
+	return *r, true
+}
+
+// Identify is an implementation of the Provider interface. The subject is the token id.
+func (s *Store) Identify(tk string) (*Identity, bool) {
+	r, ok := s.Lookup(tk)
+	if !ok {
+		return nil, false
+	}
+	return &Identity{
+		Subject: r.ID,
+		Scopes:  r.Scopes,
+		Premium: r.Premium,
+		Limits:  r.Limits,
+	}, true
+}
+
+// List returns the records of all tokens, oldest first.
+func (s *Store) List() []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reload()
+	list := make([]Record, 0, len(s.records))
+	for _, r := range s.records {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Created.Equal(list[j].Created) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+// Revoke revokes the token with the id, returning its record.
+func (s *Store) Revoke(id string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	for _, r := range s.records {
+		if r.ID != id {
+			continue
+		}
+		if r.Revoked == nil {
+			now := time.Now().UTC()
+			r.Revoked = &now
+			if err := s.save(); err != nil {
+				r.Revoked = nil
+				return nil, err
+			}
+		}
+		rc := *r
+		return &rc, nil
+	}
+	return nil, ErrTokenNotFound
+}
+
+// Err returns why the file could not be read when it was last checked for changes. The tokens
+// read before are still used.
+func (s *Store) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reload()
+	return s.err
+}
+
+// reload reads the file again if another process has changed it, checking at most once per
+// storeReloadInterval. The lock must be held.
+func (s *Store) reload() {
+	if time.Since(s.lastCheck) < storeReloadInterval {
+		return
+	}
+	s.refresh()
+}
+
+// refresh reads the file again if another process has changed it. Changes are refreshed
+// before writing so they are not lost. The lock must be held.
+func (s *Store) refresh() {
+	s.lastCheck = time.Now()
+	fi, err := os.Stat(s.path)
+	switch {
+	case os.IsNotExist(err):
+		s.err = nil
+	case err != nil:
+		s.err = err
+	case !s.unchanged(fi):
+		s.err = s.load()
+	}
+}
+
+// unchanged returns true if fi is the file last read or written. Files are replaced rather
+// than written in place, so a new file is a change even within the resolution of the clock.
+func (s *Store) unchanged(fi os.FileInfo) bool {
+	return s.file != nil && os.SameFile(fi, s.file) && fi.ModTime().Equal(s.file.ModTime())
+}
+
+// load reads the records from the file. A missing file is an empty store.
+func (s *Store) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	var list []*Record
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	records := make(map[string]*Record, len(list))
+	for _, r := range list {
+		records[r.Hash] = r
+	}
+	s.records = records
+	s.file = fi
+	s.lastCheck = time.Now()
+	return nil
+}
+
+// save writes the records to the file, replacing it atomically. The lock must be held.
+func (s *Store) save() error {
+	list := make([]*Record, 0, len(s.records))
+	for _, r := range s.records {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		s.file = fi
+	}
+	return nil
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
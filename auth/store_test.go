@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clidemo-store")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tokens.json")
+
+	st, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("Cannot open a new store: %s", err)
+	}
+	tk, r, err := st.Create("ci", []string{"parse"}, 0, func(r *Record) { r.Premium = true })
+	if err != nil {
+		t.Fatalf("Cannot create a token: %s", err)
+	}
+	if r.Hash != HashToken(tk) || r.Label != "ci" || !r.Premium || r.Expires != nil {
+		t.Errorf("Token record not created correctly: %+v", r)
+	}
+	if rec, ok := st.Lookup(tk); !ok || rec.ID != r.ID {
+		t.Errorf("Token not found: %+v", rec)
+	}
+	if _, ok := st.Lookup("NOT A STORED TOKEN"); ok {
+		t.Errorf("Missing token found.")
+	}
+
+	b, _ := ioutil.ReadFile(path)
+	if len(b) == 0 || strings.Contains(string(b), tk) {
+		t.Errorf("Token should be stored only as a hash: %s", b)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Store file should only be readable by its owner: %v", fi.Mode())
+	}
+
+	// Another store on the same file, such as the token command, sees the token.
+	st2, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("Cannot reopen the store: %s", err)
+	}
+	if _, ok := st2.Lookup(tk); !ok {
+		t.Errorf("Token not persisted.")
+	}
+	if _, err := st2.Revoke(r.ID); err != nil {
+		t.Errorf("Cannot revoke the token: %s", err)
+	}
+	if _, err := st2.Revoke("NOT AN ID"); err != ErrTokenNotFound {
+		t.Errorf("Revoking a missing token should fail: %v", err)
+	}
+	st.lastCheck = time.Time{} // Skip the reload interval.
+	if _, ok := st.Lookup(tk); ok {
+		t.Errorf("Revoked token found.")
+	}
+	if list := st.List(); len(list) != 1 || list[0].Revoked == nil {
+		t.Errorf("Revoked token not listed: %+v", list)
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clidemo-store")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	st, _ := OpenStore(filepath.Join(dir, "tokens.json"))
+	tk, r, err := st.Create("short", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("Cannot create a token: %s", err)
+	}
+	if r.Expires == nil || !r.Active(time.Now()) {
+		t.Errorf("Token should be active until it expires: %+v", r)
+	}
+	if r.Active(time.Now().Add(2 * time.Hour)) {
+		t.Errorf("Token should expire after its ttl.")
+	}
+	if _, ok := st.Lookup(tk); !ok {
+		t.Errorf("Unexpired token not found.")
+	}
+}
+
+func TestStoreErr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clidemo-store")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tokens.json")
+
+	st, _ := OpenStore(path)
+	tk, _, _ := st.Create("ci", []string{"parse"}, 0)
+	if err := st.Err(); err != nil {
+		t.Errorf("Store should have no error: %s", err)
+	}
+	ioutil.WriteFile(path+".new", []byte("not json"), 0600)
+	os.Rename(path+".new", path) // A new file, so the change is seen within the clock resolution.
+	st.mu.Lock()
+	st.refresh()
+	st.mu.Unlock()
+	if err := st.Err(); err == nil {
+		t.Errorf("Unreadable file should be reported.")
+	}
+	if _, ok := st.Lookup(tk); !ok {
+		t.Errorf("Tokens read before should still be used.")
+	}
+}
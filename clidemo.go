@@ -4,17 +4,28 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"runtime"
 	"strings"
 
+	"github.com/composer22/clidemo/client"
 	"github.com/composer22/clidemo/logger"
 	"github.com/composer22/clidemo/parser"
 	"github.com/composer22/clidemo/server"
 )
 
+const (
+	// Output formats for file or piped input.
+	formatJSON   = "json"
+	formatBinary = "binary"
+)
+
 var (
 	log *logger.Logger
 )
@@ -31,40 +42,224 @@ func configureServerEnvironment(opts *server.Options) {
 	log.Infof("NumCPU %d GOMAXPROCS: %d\n", runtime.NumCPU(), runtime.GOMAXPROCS(-1))
 }
 
+// listFlag is a command line flag holding a comma separated list. It may be repeated.
+type listFlag struct {
+	list *[]string
+}
+
+// String is an implementation of the flag.Value interface.
+func (f listFlag) String() string {
+	if f.list == nil {
+		return ""
+	}
+	return strings.Join(*f.list, ",")
+}
+
+// Set is an implementation of the flag.Value interface appending the values to the list.
+func (f listFlag) Set(s string) error {
+	*f.list = append(*f.list, strings.Split(s, ",")...)
+	return nil
+}
+
+// inputOptions are the command line options for parsing file or piped input.
+type inputOptions struct {
+	connect string // Address of a server socket port to parse on instead of locally.
+	token   string // Bearer token for the server.
+	format  string // Output format: json or binary.
+}
+
+// parseInput parses the input text and prints the result. Input already in the binary encoding
+// is accepted too: a request is decoded to its text, and a result is converted to the output
+// format without parsing. If connect is set, the text is sent to the socket protocol port of a
+// server at that address for parsing instead of parsing locally.
+func parseInput(r *bufio.Reader, o *inputOptions) {
+	if o.format != formatJSON && o.format != formatBinary {
+		log.Emergencyf("Invalid output format %q: must be %s or %s.", o.format, formatJSON, formatBinary)
+	}
+
+	p := parser.New()
+	var in io.Reader = r
+	if magic, _ := r.Peek(4); parser.IsBinary(magic) {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			log.Emergencyf("Cannot read input: %s", err)
+		}
+		switch k, _ := parser.BinaryKind(b); k {
+		case parser.KindResult:
+			if err := p.UnmarshalBinary(b); err != nil {
+				log.Emergencyf("Cannot decode binary result: %s", err)
+			}
+			printResult(p, o.format)
+			return
+		default:
+			text, err := parser.UnmarshalRequest(b)
+			if err != nil {
+				log.Emergencyf("Cannot decode binary request: %s", err)
+			}
+			in = bytes.NewReader(text)
+		}
+	}
+
+	if o.connect == "" {
+		p.Execute(in)
+		printResult(p, o.format)
+		return
+	}
+
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		log.Emergencyf("Cannot read input: %s", err)
+	}
+	c, err := client.Dial(o.connect, o.token)
+	if err != nil {
+		log.Emergencyf("Cannot connect to server %s: %s", o.connect, err)
+	}
+	defer c.Close()
+	result, err := c.Parse(string(b))
+	if err != nil {
+		log.Emergencyf("Server could not parse input: %s", err)
+	}
+	if err := json.Unmarshal([]byte(result), p); err != nil {
+		log.Emergencyf("Invalid result from server: %s", err)
+	}
+	printResult(p, o.format)
+}
+
+// printResult prints the parse results in the output format.
+func printResult(p *parser.Parser, format string) {
+	if format == formatBinary {
+		b, _ := p.MarshalBinary()
+		os.Stdout.Write(b)
+		return
+	}
+	fmt.Print(p)
+}
+
 // main is the main entry point for the application or server launch.
 func main() {
 	opts := server.Options{}
 	var showVersion bool
 	var fileIn string
+	input := inputOptions{}
 
 	flag.StringVar(&opts.Name, "N", "", "Name of the server (optional)")
-	flag.StringVar(&opts.Name, "--name", "", "Name of the server (optional)")
+	flag.StringVar(&opts.Name, "name", "", "Name of the server (optional)")
 	flag.StringVar(&opts.Hostname, "H", server.DefaultHostname, "Hostname of the server")
-	flag.StringVar(&opts.Hostname, "--hostname", server.DefaultHostname, "Name of the server")
+	flag.StringVar(&opts.Hostname, "hostname", server.DefaultHostname, "Name of the server")
 	flag.IntVar(&opts.Port, "p", server.DefaultPort, "Port to listen on (default: 49152)")
-	flag.IntVar(&opts.Port, "--port", server.DefaultPort, "Port to listen on (default: 49152)")
+	flag.IntVar(&opts.Port, "port", server.DefaultPort, "Port to listen on (default: 49152)")
 	flag.IntVar(&opts.ProfPort, "L", server.DefaultProfPort,
 		"Profiler Port to listen on (default: <= 0 is off)")
-	flag.IntVar(&opts.ProfPort, "--profiler_port", server.DefaultProfPort,
+	flag.IntVar(&opts.ProfPort, "profiler_port", server.DefaultProfPort,
 		"Profiler Port to listen on (default: <= 0 is off)")
 	flag.IntVar(&opts.MaxConn, "n", server.DefaultMaxConnections,
 		"Maximum server connections allowed (default: 0 no restriction)")
-	flag.IntVar(&opts.MaxConn, "--connections", server.DefaultMaxConnections,
+	flag.IntVar(&opts.MaxConn, "connections", server.DefaultMaxConnections,
 		"Maximum server connections allowed (default: 0 no restriction)")
+	flag.StringVar(&opts.ConnOverflow, "connection_overflow", server.DefaultConnOverflow,
+		"Connections over the maximum: block, wait or reject with a 503 (default: block)")
+	flag.DurationVar(&opts.ConnWaitTimeout, "connection_wait", server.DefaultConnWaitTimeout,
+		"Time a connection over the maximum waits in wait overflow mode (default: 5s)")
+	flag.IntVar(&opts.MaxConnPerIP, "max_conn_per_ip", server.DefaultMaxConnPerIP,
+		"Maximum concurrent connections per client address (default: <= 0 is unlimited)")
+	flag.Float64Var(&opts.ConnRatePerIP, "conn_rate_per_ip", server.DefaultConnRatePerIP,
+		"Connections per second allowed per client address (default: <= 0 is unlimited)")
+	flag.IntVar(&opts.ConnBurstPerIP, "conn_burst_per_ip", server.DefaultConnBurstPerIP,
+		"Burst of connections allowed per client address over its rate (default: 10)")
+	flag.Var(listFlag{&opts.AllowCIDRs}, "allow", "Comma separated client addresses or CIDRs that may connect")
+	flag.Var(listFlag{&opts.DenyCIDRs}, "deny", "Comma separated client addresses or CIDRs that may not connect")
+	flag.IntVar(&opts.BanAfter, "ban_after", server.DefaultBanAfter,
+		"Connection limit violations before a client address is banned (default: <= 0 is never)")
+	flag.DurationVar(&opts.BanTime, "ban_time", server.DefaultBanTime,
+		"How long a client address is banned (default: 5m)")
+	flag.StringVar(&opts.TokenStore, "token_store", "",
+		"Path of the token store managed by the admin API and token command (default: off)")
+	flag.StringVar(&opts.JWTKeys, "jwt_keys", "", "Path of the PEM or JWKS file of the JWT issuer keys (default: off)")
+	flag.StringVar(&opts.JWTSecretFile, "jwt_secret_file", "",
+		"Path of the file of the JWT HS256 shared secret (default: off)")
+	flag.StringVar(&opts.JWTIssuer, "jwt_issuer", "", "Required iss claim of JWTs (default: any)")
+	flag.StringVar(&opts.JWTAudience, "jwt_audience", "", "Required aud claim of JWTs (default: any)")
+	flag.StringVar(&opts.HMACKeys, "hmac_keys", "", "Path of the json file of keys for signed requests (default: off)")
+	flag.DurationVar(&opts.HMACSkew, "hmac_skew", server.DefaultHMACSkew,
+		"Clock difference allowed for signed requests (default: 5m)")
+	flag.Int64Var(&opts.HMACMaxBody, "hmac_max_body", server.DefaultHMACMaxBody,
+		"Largest body in bytes of a signed request (default: 10485760, <= 0 is unlimited)")
+	flag.DurationVar(&opts.StatsInterval, "stats_interval", server.DefaultStatsInterval,
+		"Time between snapshots of the statistics (default: 1m, <= 0 is off)")
+	flag.DurationVar(&opts.StatsHistory, "stats_history", server.DefaultStatsHistory,
+		"How long the snapshots of the statistics are kept (default: 24h)")
+	flag.StringVar(&opts.StatsFile, "stats_file", "",
+		"Path of a file that keeps the statistics across restarts (default: off)")
+	flag.StringVar(&opts.TraceEndpoint, "trace_endpoint", "",
+		"OTLP/HTTP collector URL the tracing spans are sent to e.g. http://localhost:4318 (default: off)")
+	flag.StringVar(&opts.TraceFile, "trace_file", "",
+		"Path of a file the tracing spans are written to instead of a collector (default: off)")
+	flag.Float64Var(&opts.TraceSample, "trace_sample", server.DefaultTraceSample,
+		"Ratio of the traces started by the server that are sampled, from 0 to 1 (default: 1)")
+	flag.StringVar(&opts.AccessLog, "access_log", "", "Path of the access log file (default: the server log)")
+	flag.StringVar(&opts.AccessLogFormat, "access_log_format", server.DefaultAccessLogFormat,
+		"Format of the access log: common, combined, json or off (default: json)")
+	flag.Var(listFlag{&opts.AccessLogHeaders}, "access_log_headers",
+		"Comma separated headers logged in the json format, * for all or - for none (default: Accept,Content-Type,User-Agent)")
+	flag.Var(listFlag{&opts.AccessLogRedact}, "access_log_redact",
+		"Comma separated headers logged as [REDACTED], besides Authorization, Cookie and the like")
+	flag.IntVar(&opts.AccessLogBody, "access_log_body", 0,
+		"Bytes of request bodies logged in the json format (default: 0, off)")
+	flag.Float64Var(&opts.AccessLogSample, "access_log_sample", server.DefaultAccessLogSample,
+		"Ratio of the successful requests logged, from 0 to 1; errors are always logged (default: 1)")
+	flag.IntVar(&opts.MinWorkers, "w", server.DefaultMinWorkers,
+		"Workers kept running when idle (default: 10)")
+	flag.IntVar(&opts.MinWorkers, "min_workers", server.DefaultMinWorkers,
+		"Workers kept running when idle (default: 10)")
+	flag.DurationVar(&opts.WorkerIdleTimeout, "worker_idle_timeout", server.DefaultWorkerIdleTimeout,
+		"Time a worker above the minimum waits for a job before retiring (default: 30s, <= 0 is never)")
+	flag.DurationVar(&opts.WorkerGrowWait, "worker_grow_wait", server.DefaultWorkerGrowWait,
+		"Time a parse job waits for a worker before another is spawned (default: 10ms)")
 	flag.IntVar(&opts.MaxWorkers, "W", server.DefaultMaxWorkers,
 		"Maximum running workers allowed (default: 1000)")
-	flag.IntVar(&opts.MaxWorkers, "--workers", server.DefaultMaxWorkers,
+	flag.IntVar(&opts.MaxWorkers, "workers", server.DefaultMaxWorkers,
 		"Maximum running workers allowed (default: 1000)")
 	flag.IntVar(&opts.MaxProcs, "X", server.DefaultMaxProcs,
 		"Maximum processor cores to use from the machine (default: <= 0 is no change")
-	flag.IntVar(&opts.MaxProcs, "--procs", server.DefaultMaxProcs,
+	flag.IntVar(&opts.MaxProcs, "procs", server.DefaultMaxProcs,
 		"Maximum processor cores to use from the machine (default: <= 0 is no change)")
+	flag.IntVar(&opts.SockPort, "s", server.DefaultSockPort,
+		"Socket protocol port to listen on (default: <= 0 is off)")
+	flag.IntVar(&opts.SockPort, "socket_port", server.DefaultSockPort,
+		"Socket protocol port to listen on (default: <= 0 is off)")
+	flag.DurationVar(&opts.MaxParseTime, "T", server.DefaultMaxParseTime,
+		"Maximum time a parse request may take e.g. 30s (default: <= 0 is unlimited)")
+	flag.DurationVar(&opts.MaxParseTime, "parse_timeout", server.DefaultMaxParseTime,
+		"Maximum time a parse request may take e.g. 30s (default: <= 0 is unlimited)")
+	flag.IntVar(&opts.QueueDepth, "Q", server.DefaultQueueDepth,
+		"Maximum parse jobs waiting for a worker (default: <= 0 is the number of workers)")
+	flag.IntVar(&opts.QueueDepth, "queue_depth", server.DefaultQueueDepth,
+		"Maximum parse jobs waiting for a worker (default: <= 0 is the number of workers)")
+	flag.DurationVar(&opts.QueueTimeout, "queue_timeout", server.DefaultQueueTimeout,
+		"Maximum time a parse job may wait for a worker before a 503 (default: 10s, <= 0 is unlimited)")
+	flag.IntVar(&opts.PriorityBytes, "priority_bytes", server.DefaultPriorityBytes,
+		"Documents up to this size jump the parse queue (default: 1024, <= 0 is off)")
+	flag.Int64Var(&opts.StreamMaxBytes, "stream_max_bytes", server.DefaultStreamMaxBytes,
+		"Most text in bytes sent over a websocket stream (default: 10485760, <= 0 is unlimited)")
+	flag.StringVar(&opts.UnixSocket, "u", "", "Unix domain socket path to listen on instead of TCP")
+	flag.StringVar(&opts.UnixSocket, "unix_socket", "", "Unix domain socket path to listen on instead of TCP")
+	flag.StringVar(&opts.UnixSocketMode, "unix_socket_mode", server.DefaultUnixSocketMode,
+		"Octal file mode of the Unix domain socket (default: 0660)")
+	flag.StringVar(&opts.UnixSocketOwner, "unix_socket_owner", "",
+		"user[:group] owner of the Unix domain socket (default: unchanged)")
+	flag.BoolVar(&opts.Systemd, "systemd", false, "Listen on the socket passed in by systemd (default: false)")
 	flag.BoolVar(&opts.Debug, "d", false, "Enable debugging output (default: false)")
-	flag.BoolVar(&opts.Debug, "--debug", false, "Enable debugging output (default: false)")
+	flag.BoolVar(&opts.Debug, "debug", false, "Enable debugging output (default: false)")
 	flag.StringVar(&fileIn, "f", "", "Process input file")
-	flag.StringVar(&fileIn, "--file", "", "Process input file")
+	flag.StringVar(&fileIn, "file", "", "Process input file")
+	flag.StringVar(&input.format, "F", formatJSON, "Output format json or binary (default: json)")
+	flag.StringVar(&input.format, "format", formatJSON, "Output format json or binary (default: json)")
+	flag.StringVar(&input.connect, "c", "", "Parse input on the server socket port at host:port")
+	flag.StringVar(&input.connect, "connect", "", "Parse input on the server socket port at host:port")
+	flag.StringVar(&input.token, "t", "", "Bearer token for the server")
+	flag.StringVar(&input.token, "token", "", "Bearer token for the server")
 	flag.BoolVar(&showVersion, "V", false, "Show version")
-	flag.BoolVar(&showVersion, "--version", false, "Show version")
+	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.Usage = server.PrintUsageAndExit
 	flag.Parse()
 
@@ -73,6 +268,12 @@ func main() {
 		server.PrintVersionAndExit()
 	}
 
+	// Token management command?
+	if flag.Arg(0) == "token" {
+		tokenCommand(flag.Args()[1:], opts.TokenStore)
+		return
+	}
+
 	// Check additional params beyond the flags, such as commands or filename w/o -f.
 	for _, arg := range flag.Args() {
 		switch strings.ToLower(arg) {
@@ -94,18 +295,14 @@ func main() {
 	// Lets do work as a service or on direct input.
 	switch {
 	case fi.Mode()&os.ModeNamedPipe != 0: // Piped input text (higher priority than file names or server mode).
-		p := parser.New()
-		p.Execute(bufio.NewReader(os.Stdin))
-		fmt.Print(p)
+		parseInput(bufio.NewReader(os.Stdin), &input)
 	case fileIn != "": // File input text higher priority than server mode.
 		fi, err := os.Open(fileIn)
 		if err != nil {
 			log.Emergencyf("Cannot open file ", fileIn, ": ", err)
 		}
 		defer fi.Close()
-		p := parser.New()
-		p.Execute(bufio.NewReader(fi))
-		fmt.Print(p)
+		parseInput(bufio.NewReader(fi), &input)
 	default: // Server mode.
 		configureServerEnvironment(&opts)
 		s := server.New(&opts)
@@ -0,0 +1,78 @@
+// Package client implements a Go client for the clidemo socket protocol. See package wire for
+// the framing.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/composer22/clidemo/wire"
+)
+
+const (
+	DefaultTimeout = 30 * time.Second // Time allowed for each request and response.
+)
+
+// Client is a connection to a clidemo server socket port.
+type Client struct {
+	conn         net.Conn
+	Timeout      time.Duration // Time allowed for each request and response.
+	MaxFrameSize int           // Largest response accepted.
+}
+
+// Dial connects to a clidemo server socket port at addr and authorizes with token.
+func Dial(addr string, token string, options ...func(*Client)) (*Client, error) {
+	c := &Client{
+		Timeout:      DefaultTimeout,
+		MaxFrameSize: wire.DefaultMaxFrameSize,
+	}
+	for _, f := range options {
+		f(c)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	if _, err := c.roundTrip(wire.TypeAuth, []byte(token), wire.TypeOK); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Parse sends text to the server to be parsed and returns the JSON result.
+func (c *Client) Parse(text string) (string, error) {
+	b, err := c.roundTrip(wire.TypeParse, []byte(text), wire.TypeResult)
+	return string(b), err
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// roundTrip sends a frame and returns the payload of the response, which must be of type want.
+func (c *Client) roundTrip(typ byte, payload []byte, want byte) ([]byte, error) {
+	if c.Timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	if err := wire.WriteFrame(c.conn, typ, payload); err != nil {
+		return nil, err
+	}
+	f, err := wire.ReadFrame(c.conn, c.MaxFrameSize)
+	if err != nil {
+		return nil, err
+	}
+	switch f.Type {
+	case want:
+		return f.Payload, nil
+	case wire.TypeError:
+		return nil, errors.New(string(f.Payload))
+	default:
+		return nil, fmt.Errorf("Unexpected response frame type 0x%02x.", f.Type)
+	}
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/composer22/clidemo/wire"
+)
+
+const (
+	testToken = "TESTTOKEN"
+)
+
+// testServer answers the socket protocol, echoing parse text back in upper case.
+func testServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				for {
+					f, err := wire.ReadFrame(conn, wire.DefaultMaxFrameSize)
+					if err != nil {
+						return
+					}
+					switch {
+					case f.Type == wire.TypeAuth && string(f.Payload) == testToken:
+						wire.WriteFrame(conn, wire.TypeOK, nil)
+					case f.Type == wire.TypeParse && string(f.Payload) == "bad":
+						wire.WriteFrame(conn, wire.TypeOK, nil)
+					case f.Type == wire.TypeParse:
+						wire.WriteFrame(conn, wire.TypeResult, []byte(strings.ToUpper(string(f.Payload))))
+					default:
+						wire.WriteFrame(conn, wire.TypeError, []byte("Invalid authorization."))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln
+}
+
+func TestClientDial(t *testing.T) {
+	t.Parallel()
+	ln := testServer(t)
+	defer ln.Close()
+
+	if _, err := Dial(ln.Addr().String(), "WRONG"); err == nil || err.Error() != "Invalid authorization." {
+		t.Errorf("Invalid token should return the server error: %v", err)
+	}
+	c, err := Dial(ln.Addr().String(), testToken, func(c *Client) { c.MaxFrameSize = 1024 })
+	if err != nil {
+		t.Fatalf("Could not dial: %s", err)
+	}
+	defer c.Close()
+	if c.MaxFrameSize != 1024 {
+		t.Errorf("Client options not applied.")
+	}
+}
+
+func TestClientParse(t *testing.T) {
+	t.Parallel()
+	ln := testServer(t)
+	defer ln.Close()
+	c, err := Dial(ln.Addr().String(), testToken)
+	if err != nil {
+		t.Fatalf("Could not dial: %s", err)
+	}
+	defer c.Close()
+
+	r, err := c.Parse("some text")
+	if err != nil || r != "SOME TEXT" {
+		t.Errorf("Parse result incorrect: %s %v", r, err)
+	}
+	if _, err = c.Parse("bad"); err == nil {
+		t.Errorf("Unexpected response type should return an error.")
+	}
+}
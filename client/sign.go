@@ -0,0 +1,43 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/composer22/clidemo/auth"
+)
+
+// SignRequest signs an HTTP request to the server API with a key shared with the server, in
+// place of a bearer token. The body is read and replaced so the request can still be sent. See
+// auth.StringToSign for what is signed.
+func SignRequest(r *http.Request, keyID string, secret []byte) error {
+	var body []byte
+	if r.Body != nil {
+		b, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+		body = b
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		r.GetBody = func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(b)), nil }
+	}
+	n := make([]byte, 16)
+	if _, err := rand.Read(n); err != nil {
+		return err
+	}
+	date, nonce := strconv.FormatInt(time.Now().Unix(), 10), hex.EncodeToString(n)
+	s := auth.StringToSign(r.Method, r.URL.EscapedPath(), r.URL.RawQuery, date, nonce, auth.BodyHash(body))
+	r.Header.Set(auth.HeaderDate, date)
+	r.Header.Set(auth.HeaderNonce, nonce)
+	r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s, Signature=%s", auth.HMACScheme, keyID,
+		auth.SignHMAC(secret, s)))
+	return nil
+}
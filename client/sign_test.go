@@ -0,0 +1,28 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/composer22/clidemo/auth"
+)
+
+func TestSignRequest(t *testing.T) {
+	const body = `{"text":"Some text."}`
+	r, _ := http.NewRequest("POST", "http://localhost:49152/v1.0/parse", strings.NewReader(body))
+	if err := SignRequest(r, "partner", []byte("a shared secret")); err != nil {
+		t.Fatalf("Cannot sign request: %s", err)
+	}
+	b, _ := ioutil.ReadAll(r.Body)
+	if string(b) != body {
+		t.Errorf("Body not replaced after signing: %s", b)
+	}
+
+	v := auth.HMACVerifierNew([]auth.HMACKey{{ID: "partner", Secret: "a shared secret"}})
+	if id, err := v.Verify(r, b, time.Now()); err != nil || id.Subject != "partner" {
+		t.Errorf("Signed request not verified by the server: %v", err)
+	}
+}
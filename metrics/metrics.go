@@ -0,0 +1,335 @@
+// Package metrics implements counters, gauges and histograms, written in the Prometheus text
+// exposition format (version 0.0.4).
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are the default histogram buckets, in seconds, for latencies.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// family is a metric with a name and help text, and all of its series.
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics and writes them out. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+// NewRegistry is a factory function that returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds a metric to the registry.
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	r.families = append(r.families, f)
+	r.mu.Unlock()
+}
+
+// WriteText writes all the metrics to w in the text exposition format, in the order they were
+// registered.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// desc is the description shared by the series of a metric.
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+// writeHeader writes the HELP and TYPE lines of the metric.
+func (d *desc) writeHeader(w *bufio.Writer) {
+	w.WriteString("# HELP " + d.name + " " + escapeHelp(d.help) + "\n")
+	w.WriteString("# TYPE " + d.name + " " + d.typ + "\n")
+}
+
+// vec holds the series of a metric by their label values.
+type vec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]interface{}
+	values map[string][]string // Label values of each series.
+	create func() interface{}
+}
+
+// with returns the series for the label values, creating it if needed.
+func (v *vec) with(values []string) interface{} {
+	if len(values) != len(v.labels) {
+		panic("metrics: " + v.name + ": wrong number of label values")
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = v.create()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// each calls f with the labels and each series, sorted by label values.
+func (v *vec) each(f func(labels string, s interface{})) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	type entry struct {
+		labels string
+		s      interface{}
+	}
+	entries := make([]entry, len(keys))
+	for i, k := range keys {
+		entries[i] = entry{formatLabels(v.labels, v.values[k]), v.series[k]}
+	}
+	v.mu.Unlock()
+	for _, e := range entries {
+		f(e.labels, e.s)
+	}
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	bits uint64
+}
+
+// Add adds n, which must not be negative, to the counter.
+func (c *Counter) Add(n float64) {
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		if atomic.CompareAndSwapUint64(&c.bits, old, math.Float64bits(math.Float64frombits(old)+n)) {
+			return
+		}
+	}
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Value returns the value of the counter.
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+// CounterVec is a counter with a series for each set of label values.
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec registers a counter with labels.
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec{
+		desc:   desc{name: name, help: help, typ: "counter", labels: labels},
+		series: make(map[string]interface{}),
+		values: make(map[string][]string),
+		create: func() interface{} { return &Counter{} },
+	}}
+	r.register(c)
+	return c
+}
+
+// With returns the counter for the label values, in the order of the labels.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values).(*Counter)
+}
+
+// write is an implementation of the family interface.
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.each(func(labels string, s interface{}) {
+		writeSample(w, c.name, labels, s.(*Counter).Value())
+	})
+}
+
+// Histogram counts observations, such as latencies, in buckets.
+type Histogram struct {
+	upper  []float64 // Upper bounds of the buckets.
+	counts []uint64  // Observations in each bucket, and over the last one.
+	sum    Counter
+	count  uint64
+}
+
+// newHistogram returns a histogram with buckets, which must be sorted.
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{upper: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	h.sum.Add(v)
+	atomic.AddUint64(&h.count, 1)
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Sum returns the sum of the observations.
+func (h *Histogram) Sum() float64 {
+	return h.sum.Value()
+}
+
+// HistogramVec is a histogram with a series for each set of label values.
+type HistogramVec struct {
+	vec
+}
+
+// NewHistogramVec registers a histogram with buckets and labels. Nil buckets are DefBuckets.
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64,
+	labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{vec{
+		desc:   desc{name: name, help: help, typ: "histogram", labels: labels},
+		series: make(map[string]interface{}),
+		values: make(map[string][]string),
+		create: func() interface{} { return newHistogram(buckets) },
+	}}
+	r.register(h)
+	return h
+}
+
+// NewHistogram registers a histogram without labels. Nil buckets are DefBuckets.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+// With returns the histogram for the label values, in the order of the labels.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values).(*Histogram)
+}
+
+// write is an implementation of the family interface.
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.each(func(labels string, s interface{}) {
+		hs := s.(*Histogram)
+		var cum uint64
+		for i, upper := range hs.upper {
+			cum += atomic.LoadUint64(&hs.counts[i])
+			writeSample(w, h.name+"_bucket", joinLabels(labels, `le="`+formatFloat(upper)+`"`), float64(cum))
+		}
+		cum += atomic.LoadUint64(&hs.counts[len(hs.upper)])
+		writeSample(w, h.name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(cum))
+		writeSample(w, h.name+"_sum", labels, hs.Sum())
+		writeSample(w, h.name+"_count", labels, float64(cum))
+	})
+}
+
+// GaugeFunc is a gauge whose value is read when the metrics are written.
+type GaugeFunc struct {
+	desc
+	f func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by f. f must be safe for concurrent use.
+func (r *Registry) NewGaugeFunc(name string, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, typ: "gauge"}, f: f}
+	r.register(g)
+	return g
+}
+
+// NewCounterFunc registers a counter whose value is returned by f, for counts kept elsewhere. f
+// must be safe for concurrent use.
+func (r *Registry) NewCounterFunc(name string, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, typ: "counter"}, f: f}
+	r.register(g)
+	return g
+}
+
+// write is an implementation of the family interface.
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	writeSample(w, g.name, "", g.f())
+}
+
+// writeSample writes one sample line.
+func writeSample(w *bufio.Writer, name string, labels string, v float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+// formatLabels returns the label pairs of a series.
+func formatLabels(names []string, values []string) string {
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		pairs[i] = n + `="` + escapeLabel(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+// joinLabels adds a label pair to the labels of a series.
+func joinLabels(labels string, pair string) string {
+	if labels == "" {
+		return pair
+	}
+	return labels + "," + pair
+}
+
+// formatFloat formats a sample value.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// escapeHelp escapes help text.
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+// escapeLabel escapes a label value.
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_requests_total", "Requests by route.", "route", "code")
+	c.With("/b", "200").Inc()
+	c.With("/a", "404").Add(2)
+	c.With("/b", "200").Inc()
+	h := r.NewHistogram("test_duration_seconds", "Durations.", []float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(3)
+	r.NewGaugeFunc("test_workers", "Workers with a \"quote\"\nand a newline.", func() float64 { return 7 })
+	r.NewCounterVec("test_labels_total", "Labels.", "v").With("a\"b\\c\nd").Inc()
+
+	var b bytes.Buffer
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("Cannot write metrics: %s", err)
+	}
+	expected := `# HELP test_requests_total Requests by route.
+# TYPE test_requests_total counter
+test_requests_total{route="/a",code="404"} 2
+test_requests_total{route="/b",code="200"} 2
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 3.55
+test_duration_seconds_count 3
+# HELP test_workers Workers with a "quote"\nand a newline.
+# TYPE test_workers gauge
+test_workers 7
+# HELP test_labels_total Labels.
+# TYPE test_labels_total counter
+test_labels_total{v="a\"b\\c\nd"} 1
+`
+	if b.String() != expected {
+		t.Errorf("Metrics not written correctly.\nExpected:\n%s\nActual:\n%s", expected, b.String())
+	}
+	if h.Count() != 3 || h.Sum() != 3.55 {
+		t.Errorf("Histogram totals incorrect: %d %f", h.Count(), h.Sum())
+	}
+}
+
+func TestCounterVecLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Test.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Wrong number of label values should panic.")
+		}
+	}()
+	c.With("only one")
+}
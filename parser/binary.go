@@ -0,0 +1,247 @@
+package parser
+
+// Binary encoding of parse results and requests.
+//
+// The encoding is a flat, little-endian, 8 byte aligned layout in the style of Cap'n Proto, so a
+// reader can walk a result in place without decoding it (see ResultView). All offsets are in
+// bytes from the start of the message.
+//
+//   Header (16 bytes)
+//     magic      [4]byte  "CLDB"
+//     version    uint16   BinaryVersion; readers reject a newer version.
+//     kind       uint16   KindResult or KindRequest.
+//     count      uint32   Result: number of words. Request: length of the text.
+//     entrySize  uint32   Result: size of a word entry. Newer versions may only grow
+//                         entries, so readers step over fields they do not know.
+//
+//   Result
+//     entries    [count]Entry, sorted by word.
+//     words      UTF-8 bytes of all words, padded to 8 bytes.
+//     sentences  []uint32 sentence ids of all words.
+//
+//   Entry (24 bytes in version 1)
+//     wordOff      uint32
+//     wordLen      uint32
+//     counter      uint32
+//     sentenceOff  uint32
+//     sentenceLen  uint32   Number of sentence ids.
+//     reserved     uint32
+//
+//   Request
+//     text       UTF-8 bytes of the text to parse.
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+const (
+	BinaryVersion = 1 // Version of the binary encoding written.
+
+	KindResult  = 1 // Message holds a parse result.
+	KindRequest = 2 // Message holds text to parse.
+
+	binaryMagic      = "CLDB"
+	binaryHeaderSize = 16
+	binaryEntrySize  = 24
+)
+
+var (
+	ErrBinaryFormat  = errors.New("Invalid binary encoding.")
+	ErrBinaryVersion = errors.New("Unsupported binary encoding version.")
+	ErrBinaryKind    = errors.New("Unexpected binary message kind.")
+)
+
+var le = binary.LittleEndian
+
+// IsBinary returns true if b starts with the header of a binary encoded message.
+func IsBinary(b []byte) bool {
+	return len(b) >= len(binaryMagic) && string(b[:len(binaryMagic)]) == binaryMagic
+}
+
+// BinaryKind returns the kind of a binary encoded message.
+func BinaryKind(b []byte) (int, error) {
+	if len(b) < binaryHeaderSize || !IsBinary(b) {
+		return 0, ErrBinaryFormat
+	}
+	if le.Uint16(b[4:]) > BinaryVersion {
+		return 0, ErrBinaryVersion
+	}
+	return int(le.Uint16(b[6:])), nil
+}
+
+// MarshalBinary is an implementation of the encoding.BinaryMarshaler interface returning the
+// parse results in the binary encoding.
+func (p *Parser) MarshalBinary() ([]byte, error) {
+	keys := make([]string, 0, len(p.Words))
+	wordsLen, sentLen := 0, 0
+	for k, w := range p.Words {
+		keys = append(keys, k)
+		wordsLen += len(k)
+		sentLen += len(w.SentenceUse)
+	}
+	sort.Strings(keys)
+
+	wordsOff := binaryHeaderSize + len(keys)*binaryEntrySize
+	sentOff := wordsOff + align8(wordsLen)
+	b := make([]byte, sentOff+sentLen*4)
+	putBinaryHeader(b, KindResult, len(keys))
+	le.PutUint32(b[12:], binaryEntrySize)
+
+	e := binaryHeaderSize
+	for _, k := range keys {
+		w := p.Words[k]
+		le.PutUint32(b[e:], uint32(wordsOff))
+		le.PutUint32(b[e+4:], uint32(len(k)))
+		le.PutUint32(b[e+8:], uint32(w.Counter))
+		le.PutUint32(b[e+12:], uint32(sentOff))
+		le.PutUint32(b[e+16:], uint32(len(w.SentenceUse)))
+		wordsOff += copy(b[wordsOff:], k)
+		for _, id := range w.SentenceUse {
+			le.PutUint32(b[sentOff:], uint32(id))
+			sentOff += 4
+		}
+		e += binaryEntrySize
+	}
+	return b, nil
+}
+
+// UnmarshalBinary is an implementation of the encoding.BinaryUnmarshaler interface replacing the
+// parse results with those of a binary encoded result.
+func (p *Parser) UnmarshalBinary(b []byte) error {
+	v, err := NewResultView(b)
+	if err != nil {
+		return err
+	}
+	p.Reset()
+	for i := 0; i < v.Len(); i++ {
+		w := &wordRef{
+			Counter:     v.Counter(i),
+			SentenceUse: make([]int, v.SentenceCount(i)),
+		}
+		for j := range w.SentenceUse {
+			w.SentenceUse[j] = v.Sentence(i, j)
+		}
+		p.Words[string(v.Word(i))] = w
+	}
+	return nil
+}
+
+// MarshalRequest returns text to parse in the binary encoding.
+func MarshalRequest(text []byte) []byte {
+	b := make([]byte, binaryHeaderSize+len(text))
+	putBinaryHeader(b, KindRequest, len(text))
+	copy(b[binaryHeaderSize:], text)
+	return b
+}
+
+// UnmarshalRequest returns the text to parse from a binary encoded request. The text is not
+// copied and shares memory with b.
+func UnmarshalRequest(b []byte) ([]byte, error) {
+	k, err := BinaryKind(b)
+	if err != nil {
+		return nil, err
+	}
+	if k != KindRequest {
+		return nil, ErrBinaryKind
+	}
+	n := int64(le.Uint32(b[8:]))
+	if int64(len(b)-binaryHeaderSize) < n {
+		return nil, ErrBinaryFormat
+	}
+	return b[binaryHeaderSize : binaryHeaderSize+n], nil
+}
+
+// ResultView reads a binary encoded parse result in place, without decoding or copying it.
+type ResultView struct {
+	b         []byte
+	count     int
+	entrySize int
+}
+
+// NewResultView is a factory function that returns a view over the binary encoded result b.
+// All offsets are validated so that the accessors cannot read outside of b.
+func NewResultView(b []byte) (*ResultView, error) {
+	k, err := BinaryKind(b)
+	if err != nil {
+		return nil, err
+	}
+	if k != KindResult {
+		return nil, ErrBinaryKind
+	}
+	v := &ResultView{
+		b:         b,
+		count:     int(le.Uint32(b[8:])),
+		entrySize: int(le.Uint32(b[12:])),
+	}
+	if v.entrySize < binaryEntrySize ||
+		int64(v.count)*int64(v.entrySize) > int64(len(b)-binaryHeaderSize) {
+		return nil, ErrBinaryFormat
+	}
+	for i := 0; i < v.count; i++ {
+		e := v.entry(i)
+		wOff, wLen := int64(le.Uint32(e)), int64(le.Uint32(e[4:]))
+		sOff, sLen := int64(le.Uint32(e[12:])), int64(le.Uint32(e[16:]))
+		if wOff+wLen > int64(len(b)) || sOff+sLen*4 > int64(len(b)) {
+			return nil, ErrBinaryFormat
+		}
+	}
+	return v, nil
+}
+
+// Len returns the number of words in the result.
+func (v *ResultView) Len() int {
+	return v.count
+}
+
+// Word returns the i-th word in sorted order. The slice shares memory with the encoded result.
+func (v *ResultView) Word(i int) []byte {
+	e := v.entry(i)
+	off := le.Uint32(e)
+	return v.b[off : off+le.Uint32(e[4:])]
+}
+
+// Counter returns the number of times the i-th word was found.
+func (v *ResultView) Counter(i int) int {
+	return int(le.Uint32(v.entry(i)[8:]))
+}
+
+// SentenceCount returns the number of sentence ids recorded for the i-th word.
+func (v *ResultView) SentenceCount(i int) int {
+	return int(le.Uint32(v.entry(i)[16:]))
+}
+
+// Sentence returns the j-th sentence id recorded for the i-th word.
+func (v *ResultView) Sentence(i int, j int) int {
+	off := int(le.Uint32(v.entry(i)[12:])) + j*4
+	return int(le.Uint32(v.b[off:]))
+}
+
+// Find returns the index of word in the result, or -1 if it was not found.
+func (v *ResultView) Find(word string) int {
+	i := sort.Search(v.count, func(i int) bool { return string(v.Word(i)) >= word })
+	if i < v.count && string(v.Word(i)) == word {
+		return i
+	}
+	return -1
+}
+
+// entry returns the bytes of the i-th word entry.
+func (v *ResultView) entry(i int) []byte {
+	off := binaryHeaderSize + i*v.entrySize
+	return v.b[off : off+v.entrySize]
+}
+
+// putBinaryHeader writes the common message header into b.
+func putBinaryHeader(b []byte, kind int, count int) {
+	copy(b, binaryMagic)
+	le.PutUint16(b[4:], BinaryVersion)
+	le.PutUint16(b[6:], uint16(kind))
+	le.PutUint32(b[8:], uint32(count))
+}
+
+// align8 rounds n up to a multiple of 8.
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// TestParserBinaryRoundTrip tests that a binary encoded result decodes to the same JSON form.
+func TestParserBinaryRoundTrip(t *testing.T) {
+	t.Parallel()
+	p := New()
+	p.Execute(bytes.NewBufferString(testParserText))
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Binary encoding failed: %s", err)
+	}
+	if !IsBinary(b) || len(b)%8 != 0 {
+		t.Errorf("Binary encoding has an invalid header or alignment.")
+	}
+
+	d := New()
+	d.Words["stale"] = &wordRef{Counter: 1}
+	if err := d.UnmarshalBinary(b); err != nil {
+		t.Fatalf("Binary decoding failed: %s", err)
+	}
+	if result := fmt.Sprint(d); result != testParserResultJSON {
+		t.Errorf("Invalid binary round trip\nExpected:\n\n%s\n\nResult:\n\n%s\n\n",
+			testParserResultJSON, result)
+	}
+
+	// Empty results round trip too.
+	b, _ = New().MarshalBinary()
+	if err := d.UnmarshalBinary(b); err != nil || len(d.Words) != 0 {
+		t.Errorf("Empty binary result not decoded.")
+	}
+}
+
+// TestParserResultView tests reading a binary result in place.
+func TestParserResultView(t *testing.T) {
+	t.Parallel()
+	p := New()
+	p.Execute(bytes.NewBufferString(testParserText))
+	b, _ := p.MarshalBinary()
+	v, err := NewResultView(b)
+	if err != nil {
+		t.Fatalf("View not created: %s", err)
+	}
+	if v.Len() != len(p.Words) {
+		t.Errorf("View has invalid length %d.", v.Len())
+	}
+	i := v.Find("the")
+	if i < 0 || string(v.Word(i)) != "the" || v.Counter(i) != 2 || v.SentenceCount(i) != 2 ||
+		v.Sentence(i, 0) != 0 || v.Sentence(i, 1) != 1 {
+		t.Errorf("View returned invalid entry for 'the'.")
+	}
+	if v.Find("missing") != -1 || v.Find("zzz") != -1 {
+		t.Errorf("View found a missing word.")
+	}
+}
+
+// TestParserBinaryRequest tests the request encoding.
+func TestParserBinaryRequest(t *testing.T) {
+	t.Parallel()
+	b := MarshalRequest([]byte(testParserText))
+	if k, err := BinaryKind(b); err != nil || k != KindRequest {
+		t.Errorf("Request has an invalid kind.")
+	}
+	text, err := UnmarshalRequest(b)
+	if err != nil || string(text) != testParserText {
+		t.Errorf("Request not decoded: %v", err)
+	}
+	if _, err := NewResultView(b); err != ErrBinaryKind {
+		t.Errorf("Request should not be read as a result.")
+	}
+	if _, err := UnmarshalRequest(b[:len(b)-1]); err != ErrBinaryFormat {
+		t.Errorf("Truncated request should be invalid.")
+	}
+}
+
+// TestParserBinaryErrors tests that invalid messages are rejected.
+func TestParserBinaryErrors(t *testing.T) {
+	t.Parallel()
+	p := New()
+	p.Execute(bytes.NewBufferString(testParserText))
+	b, _ := p.MarshalBinary()
+
+	if err := p.UnmarshalBinary([]byte(testParserResultJSON)); err != ErrBinaryFormat {
+		t.Errorf("JSON should not decode as binary.")
+	}
+	if err := p.UnmarshalBinary(b[:len(b)-4]); err != ErrBinaryFormat {
+		t.Errorf("Truncated result should be invalid.")
+	}
+	nb := append([]byte{}, b...)
+	nb[4] = BinaryVersion + 1
+	if err := p.UnmarshalBinary(nb); err != ErrBinaryVersion {
+		t.Errorf("Newer version should be rejected.")
+	}
+	nb = append([]byte{}, b...)
+	le.PutUint32(nb[binaryHeaderSize:], uint32(len(b)))
+	if err := p.UnmarshalBinary(nb); err != ErrBinaryFormat {
+		t.Errorf("Out of range offset should be invalid.")
+	}
+}
@@ -3,19 +3,40 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
 	punctMarks = ";:,!?.\\/[](){}-\"'`"
+
+	cancelCheckInterval = 1024 // Number of words between checks for cancellation.
 )
 
 // Parser represents text source plus a mapping of unique words found in the text with an arrray of sentence ids where the
 // words were located.
 type Parser struct {
 	Words map[string]*wordRef `json:"words"` // Words as key with struct of counts, location.
+
+	// Incremental parsing state, see Write.
+	sentPtr int            // Current sentence id.
+	partial []byte         // Trailing text that may be the start of a longer word.
+	changed map[string]int // Words changed since the last Delta, with their prior number of sentence ids.
+
+	progress      func(Progress) // Optional progress report function, see SetProgress.
+	progressEvery int            // Number of words between progress reports.
+}
+
+// Progress represents how far Execute has got through the source text.
+type Progress struct {
+	Bytes     int64 `json:"bytes"`     // Bytes of source text consumed.
+	Tokens    int   `json:"tokens"`    // Words counted.
+	Sentences int   `json:"sentences"` // Sentences completed.
 }
 
 // wordRef represents a word found in the source text, a count on it's use, and which sentences it was found.
@@ -24,6 +45,19 @@ type wordRef struct {
 	SentenceUse []int `json:"sentenceUse"` // The sentence id where the word was found.
 }
 
+// Delta represents the changes to the parse results since the previous delta was taken.
+type Delta struct {
+	Words     map[string]*wordDelta `json:"words"`     // Words added or changed.
+	Sentences int                   `json:"sentences"` // Number of sentences completed so far.
+}
+
+// wordDelta represents the change to a word found in the source text.
+type wordDelta struct {
+	New         bool  `json:"new"`         // The word was not found before.
+	Counter     int   `json:"counter"`     // The number of times the word was found in the text.
+	SentenceUse []int `json:"sentenceUse"` // The sentence ids added for the word.
+}
+
 // New is a factory function that returns a new parser instance.
 func New() *Parser {
 	return &Parser{
@@ -34,11 +68,85 @@ func New() *Parser {
 // Execute begins the parsing process. The source text is read, words are counted,
 // and unique sentence ids are recorded.
 func (p *Parser) Execute(s io.Reader) {
-	scnr := bufio.NewScanner(s)
+	p.scan(context.Background(), s, 0)
+}
+
+// ExecuteContext is Execute that stops early when ctx is cancelled or times out, returning
+// ctx.Err(). The results are then incomplete.
+func (p *Parser) ExecuteContext(ctx context.Context, s io.Reader) error {
+	_, err := p.scan(ctx, s, 0)
+	return err
+}
+
+// SetProgress sets a function that Execute calls with its progress every n words, and once when
+// the text is done. A nil function turns progress reporting off.
+func (p *Parser) SetProgress(fn func(Progress), n int) {
+	if n <= 0 {
+		n = 1
+	}
+	p.progress = fn
+	p.progressEvery = n
+}
+
+// Write is an implementation of the io.Writer interface for parsing text incrementally, such as
+// text being typed. Each chunk continues the text of the previous ones. A word at the end of a
+// chunk is held back until whitespace follows it or Flush is called, since the next chunk may
+// continue it.
+func (p *Parser) Write(b []byte) (int, error) {
+	if p.changed == nil {
+		p.changed = make(map[string]int)
+	}
+	p.partial = append(p.partial, b...)
+	i := bytes.LastIndexFunc(p.partial, unicode.IsSpace)
+	if i < 0 {
+		return len(b), nil
+	}
+	_, sz := utf8.DecodeRune(p.partial[i:])
+	p.sentPtr, _ = p.scan(context.Background(), bytes.NewReader(p.partial[:i+sz]), p.sentPtr)
+	p.partial = append(p.partial[:0], p.partial[i+sz:]...)
+	return len(b), nil
+}
+
+// Flush parses any word held back by Write as the end of the text.
+func (p *Parser) Flush() {
+	if len(p.partial) > 0 {
+		p.sentPtr, _ = p.scan(context.Background(), bytes.NewReader(p.partial), p.sentPtr)
+		p.partial = p.partial[:0]
+	}
+}
+
+// Delta returns the words added or changed by Write since the previous call.
+func (p *Parser) Delta() *Delta {
+	d := &Delta{
+		Words:     make(map[string]*wordDelta),
+		Sentences: p.sentPtr,
+	}
+	for k, prior := range p.changed {
+		w := p.Words[k]
+		wd := &wordDelta{
+			New:     prior < 0,
+			Counter: w.Counter,
+		}
+		if prior < 0 {
+			prior = 0
+		}
+		wd.SentenceUse = append([]int{}, w.SentenceUse[prior:]...)
+		d.Words[k] = wd
+	}
+	p.changed = make(map[string]int)
+	return d
+}
+
+// scan reads the source text counting words and recording sentence ids, starting from sentence
+// id sentPtr. The sentence id following the text is returned, or an error if ctx was cancelled.
+func (p *Parser) scan(ctx context.Context, s io.Reader, sentPtr int) (int, error) {
+	cr := &countingReader{r: s}
+	scnr := bufio.NewScanner(cr)
 	scnr.Split(bufio.ScanWords)
 
 	eos := false
-	sentPtr := 0
+	tokens := 0
+	startPtr := sentPtr
 
 	// Loop on the text and analyze word usage.
 	for scnr.Scan() {
@@ -63,6 +171,14 @@ func (p *Parser) Execute(s io.Reader) {
 				}
 				w = p.Words[key]
 			}
+			if p.changed != nil {
+				if _, ok := p.changed[key]; !ok {
+					p.changed[key] = len(w.SentenceUse)
+					if w.Counter == 0 {
+						p.changed[key] = -1
+					}
+				}
+			}
 			w.Counter++
 			w.SentenceUse = append(w.SentenceUse, sentPtr)
 		}
@@ -72,12 +188,42 @@ func (p *Parser) Execute(s io.Reader) {
 			sentPtr++
 			eos = false
 		}
+
+		tokens++
+		if tokens%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return sentPtr, err
+			}
+		}
+		if p.progress != nil && tokens%p.progressEvery == 0 {
+			p.progress(Progress{Bytes: cr.n, Tokens: tokens, Sentences: sentPtr - startPtr})
+		}
 	}
+	if p.progress != nil {
+		p.progress(Progress{Bytes: cr.n, Tokens: tokens, Sentences: sentPtr - startPtr})
+	}
+	return sentPtr, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read is an implementation of the io.Reader interface.
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
 }
 
 // Reset cleans out the parser and makes it available for another parse job.
 func (p *Parser) Reset() {
 	p.Words = make(map[string]*wordRef)
+	p.sentPtr = 0
+	p.partial = nil
+	p.changed = nil
 }
 
 // String is an implentation of the Stringer interface so the structure is returned as a string to fmt.Print() etc.
@@ -2,7 +2,9 @@ package parser
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -41,3 +43,106 @@ func TestParserReset(t *testing.T) {
 		t.Errorf("Invalid parser Reset().")
 	}
 }
+
+// TestParserWrite tests that incremental parsing matches parsing the whole text.
+func TestParserWrite(t *testing.T) {
+	t.Parallel()
+	p := New()
+	for i := 0; i < len(testParserText); i += 7 { // Chunks split words and sentences.
+		end := i + 7
+		if end > len(testParserText) {
+			end = len(testParserText)
+		}
+		p.Write([]byte(testParserText[i:end]))
+	}
+	if _, ok := p.Words["well"]; ok {
+		t.Errorf("Trailing word should be held back until flushed.")
+	}
+	p.Flush()
+	if result := fmt.Sprint(p); result != testParserResultJSON {
+		t.Errorf("Invalid incremental parser results\nExpected:\n\n%s\n\nResult:\n\n%s\n\n",
+			testParserResultJSON, result)
+	}
+}
+
+// TestParserDelta tests the changes reported after each chunk.
+func TestParserDelta(t *testing.T) {
+	t.Parallel()
+	p := New()
+	p.Write([]byte("The cat sat. The d"))
+	d := p.Delta()
+	if len(d.Words) != 3 || d.Sentences != 1 {
+		t.Fatalf("Invalid first delta: %d words, %d sentences", len(d.Words), d.Sentences)
+	}
+	if w := d.Words["the"]; !w.New || w.Counter != 2 || len(w.SentenceUse) != 2 {
+		t.Errorf("Invalid first delta for 'the'.")
+	}
+
+	p.Write([]byte("og ran. "))
+	d = p.Delta()
+	if len(d.Words) != 2 || d.Sentences != 2 {
+		t.Fatalf("Invalid second delta: %d words, %d sentences", len(d.Words), d.Sentences)
+	}
+	if w := d.Words["dog"]; !w.New || w.Counter != 1 || w.SentenceUse[0] != 1 {
+		t.Errorf("Word split across chunks not reported.")
+	}
+
+	p.Write([]byte("The end"))
+	p.Flush()
+	d = p.Delta()
+	w := d.Words["the"]
+	if w.New || w.Counter != 3 || len(w.SentenceUse) != 1 || w.SentenceUse[0] != 2 {
+		t.Errorf("Changed word should only report new sentence ids.")
+	}
+	if len(p.Delta().Words) != 0 {
+		t.Errorf("Delta should be empty without new text.")
+	}
+}
+
+// TestParserProgress tests progress reporting during Execute.
+func TestParserProgress(t *testing.T) {
+	t.Parallel()
+	p := New()
+	reports := make([]Progress, 0)
+	p.SetProgress(func(pr Progress) { reports = append(reports, pr) }, 5)
+	p.Execute(bytes.NewBufferString(testParserText))
+	if len(reports) != 3 {
+		t.Fatalf("Invalid number of progress reports %d.", len(reports))
+	}
+	if reports[0].Tokens != 5 || reports[1].Tokens != 10 {
+		t.Errorf("Progress not reported every 5 words.")
+	}
+	last := reports[2]
+	if last.Tokens != 14 || last.Sentences != 2 || last.Bytes != int64(len(testParserText)) {
+		t.Errorf("Invalid final progress report: %+v", last)
+	}
+
+	p.SetProgress(nil, 0)
+	p.Execute(bytes.NewBufferString(testParserText))
+	if len(reports) != 3 {
+		t.Errorf("Progress should not be reported once turned off.")
+	}
+}
+
+// TestParserExecuteContext tests that a cancelled parse stops early.
+func TestParserExecuteContext(t *testing.T) {
+	t.Parallel()
+	p := New()
+	if err := p.ExecuteContext(context.Background(), bytes.NewBufferString(testParserText)); err != nil {
+		t.Errorf("Parse should not have been cancelled: %s", err)
+	}
+	if result := fmt.Sprint(p); result != testParserResultJSON {
+		t.Errorf("Invalid parser results with a context.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.Reset()
+	text := strings.Repeat("word ", 3*cancelCheckInterval)
+	if err := p.ExecuteContext(ctx, bytes.NewBufferString(text)); err != context.Canceled {
+		t.Errorf("Cancelled parse should return context.Canceled: %v", err)
+	}
+	if p.Words["word"].Counter != cancelCheckInterval {
+		t.Errorf("Cancelled parse should stop at the first check: %d", p.Words["word"].Counter)
+	}
+}
@@ -0,0 +1,130 @@
+// Package ratelimit implements token bucket rate limiting, singly or for a set of keys such as
+// client addresses or auth tokens.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket that refills at Rate tokens per second up to Burst tokens. A Bucket
+// is not safe for concurrent use; see Limiter.
+type Bucket struct {
+	Rate   float64 // Tokens added per second.
+	Burst  float64 // Maximum tokens held.
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket is a factory function that returns a full bucket.
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{
+		Rate:   rate,
+		Burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// Allow takes a token from the bucket, returning false if there was none.
+func (b *Bucket) Allow(now time.Time) bool {
+	return b.AllowN(now, 1)
+}
+
+// AllowN takes n tokens from the bucket, returning false if there were not enough.
+func (b *Bucket) AllowN(now time.Time, n float64) bool {
+	b.refill(now)
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// Remaining returns the tokens left in the bucket.
+func (b *Bucket) Remaining(now time.Time) float64 {
+	b.refill(now)
+	return b.tokens
+}
+
+// Full returns true if the bucket has refilled to its burst size.
+func (b *Bucket) Full(now time.Time) bool {
+	return b.Remaining(now) >= b.Burst
+}
+
+// Wait returns how long until n tokens are available.
+func (b *Bucket) Wait(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	if b.tokens >= n || b.Rate <= 0 {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.Rate * float64(time.Second))
+}
+
+// refill adds the tokens earned since the last call.
+func (b *Bucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.Rate
+		if b.tokens > b.Burst {
+			b.tokens = b.Burst
+		}
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
+
+// Limiter rate limits a set of keys, each with its own bucket. It is safe for concurrent use.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[string]*Bucket
+}
+
+// New is a factory function that returns a Limiter allowing rate events per second for each
+// key, with bursts of up to burst events.
+func New(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*Bucket),
+	}
+}
+
+// Allow takes a token for key, returning false if the key is over its rate.
+func (l *Limiter) Allow(key string) bool {
+	return l.AllowAt(key, time.Now())
+}
+
+// AllowAt takes a token for key at time now, returning false if the key is over its rate.
+func (l *Limiter) AllowAt(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewBucket(l.rate, l.burst)
+		l.buckets[key] = b
+	}
+	return b.Allow(now)
+}
+
+// Prune forgets the keys whose buckets have refilled, so idle keys do not use memory.
+func (l *Limiter) Prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, b := range l.buckets {
+		if b.Full(now) {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// Len returns the number of keys being tracked.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
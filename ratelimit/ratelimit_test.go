@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(2, 3)
+	for i := 0; i < 3; i++ {
+		if !b.Allow(now) {
+			t.Fatalf("Bucket should allow its burst: %d", i)
+		}
+	}
+	if b.Allow(now) {
+		t.Errorf("Empty bucket should not allow.")
+	}
+	if w := b.Wait(now, 1); w != 500*time.Millisecond {
+		t.Errorf("Bucket wait incorrect: %s", w)
+	}
+
+	now = now.Add(time.Second) // Refills 2 tokens.
+	if r := b.Remaining(now); r != 2 {
+		t.Errorf("Bucket should have refilled 2 tokens: %f", r)
+	}
+	if !b.AllowN(now, 2) || b.Allow(now) {
+		t.Errorf("Bucket should allow exactly the refilled tokens.")
+	}
+
+	now = now.Add(time.Hour)
+	if !b.Full(now) || b.Remaining(now) != 3 {
+		t.Errorf("Bucket should refill only up to its burst: %f", b.Remaining(now))
+	}
+	if !b.Allow(now.Add(-time.Minute)) {
+		t.Errorf("Bucket should not go back in time.")
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := New(1, 1)
+	if !l.AllowAt("a", now) || !l.AllowAt("b", now) {
+		t.Errorf("Limiter should allow each key.")
+	}
+	if l.AllowAt("a", now) {
+		t.Errorf("Limiter should limit a key over its rate.")
+	}
+	if l.Len() != 2 {
+		t.Errorf("Limiter should track 2 keys: %d", l.Len())
+	}
+	l.Prune(now)
+	if l.Len() != 2 {
+		t.Errorf("Limiter should keep keys that have not refilled: %d", l.Len())
+	}
+	l.Prune(now.Add(time.Second))
+	if l.Len() != 0 {
+		t.Errorf("Limiter should prune refilled keys: %d", l.Len())
+	}
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/composer22/clidemo/auth"
+	"github.com/composer22/clidemo/logger"
+)
+
+// Formats of the access log.
+const (
+	AccessLogCommon   = "common"   // NCSA Common Log Format.
+	AccessLogCombined = "combined" // Common Log Format with the referer and user agent.
+	AccessLogJSON     = "json"     // A json object per request.
+	AccessLogOff      = "off"
+
+	accessLogRedacted = "[REDACTED]"
+)
+
+var (
+	ErrAccessLogFormat = errors.New("Invalid access log format.")
+
+	// Headers logged in the json format unless others are configured.
+	defaultAccessLogHeaders = []string{"Accept", "Content-Type", "User-Agent"}
+
+	// Headers that carry secrets, whose values are never logged.
+	defaultAccessLogRedact = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie",
+		"X-Api-Key"}
+)
+
+// accessLog writes a line for each http request answered by the server.
+type accessLog struct {
+	format  string
+	headers []string        // Headers logged in the json format; nil is all.
+	redact  map[string]bool // Headers logged as [REDACTED].
+	body    int             // Bytes of the request body logged in the json format.
+	sample  float64         // Ratio of the successful requests that are logged.
+
+	mu  sync.Mutex
+	out io.Writer      // Optional file of the access log.
+	log *logger.Logger // Server log, used if there is no file.
+}
+
+// accessLogEntry is an entry of the access log in the json format.
+type accessLogEntry struct {
+	Time          time.Time         `json:"time"`
+	RequestID     string            `json:"requestId,omitempty"`
+	TraceID       string            `json:"traceId,omitempty"`
+	RemoteAddr    string            `json:"remoteAddr"`
+	Subject       string            `json:"subject,omitempty"` // Of the auth token.
+	Method        string            `json:"method"`
+	URI           string            `json:"uri"`
+	Proto         string            `json:"proto"`
+	Status        int               `json:"status"`
+	RequestBytes  int64             `json:"requestBytes"`
+	ResponseBytes int64             `json:"responseBytes"`
+	DurationMs    float64           `json:"durationMs"`
+	Header        map[string]string `json:"header,omitempty"`
+	Body          string            `json:"body,omitempty"`
+	BodyTruncated bool              `json:"bodyTruncated,omitempty"`
+}
+
+// accessLogNew is a factory function that returns the access log configured by the options,
+// or nil if it is off. Lines are written to the server log at the info level unless a file is
+// configured.
+func accessLogNew(opts *Options, log *logger.Logger) (*accessLog, error) {
+	format := opts.AccessLogFormat
+	switch format {
+	case "":
+		format = DefaultAccessLogFormat
+	case AccessLogOff:
+		return nil, nil
+	case AccessLogCommon, AccessLogCombined, AccessLogJSON:
+	default:
+		return nil, ErrAccessLogFormat
+	}
+	a := &accessLog{
+		format:  format,
+		headers: defaultAccessLogHeaders,
+		redact:  make(map[string]bool),
+		body:    opts.AccessLogBody,
+		sample:  opts.AccessLogSample,
+		log:     log,
+	}
+	switch {
+	case len(opts.AccessLogHeaders) == 1 && opts.AccessLogHeaders[0] == "-":
+		a.headers = []string{}
+	case len(opts.AccessLogHeaders) == 1 && opts.AccessLogHeaders[0] == "*":
+		a.headers = nil
+	case len(opts.AccessLogHeaders) > 0:
+		a.headers = make([]string, 0, len(opts.AccessLogHeaders))
+		for _, h := range opts.AccessLogHeaders {
+			a.headers = append(a.headers, http.CanonicalHeaderKey(strings.TrimSpace(h)))
+		}
+	}
+	for _, h := range append(defaultAccessLogRedact, opts.AccessLogRedact...) {
+		a.redact[http.CanonicalHeaderKey(strings.TrimSpace(h))] = true
+	}
+	if opts.AccessLog != "" {
+		f, err := os.OpenFile(opts.AccessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		a.out = f
+	}
+	return a, nil
+}
+
+// bodyCapture keeps the first bytes of a request body as the handlers read it, for the access
+// log, so that the body is neither read twice nor held in memory whole.
+type bodyCapture struct {
+	io.ReadCloser
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+// Read is an implementation of the io.Reader interface.
+func (b *bodyCapture) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := b.max - len(b.buf); room > 0 {
+		if n > room {
+			b.buf = append(b.buf, p[:room]...)
+			b.truncated = true
+		} else {
+			b.buf = append(b.buf, p[:n]...)
+		}
+	} else if n > 0 {
+		b.truncated = true
+	}
+	return n, err
+}
+
+// capture returns the request with its body kept for the access log, if bodies are logged.
+func (a *accessLog) capture(r *http.Request) (*http.Request, *bodyCapture) {
+	if a == nil || a.format != AccessLogJSON || a.body <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return r, nil
+	}
+	bc := &bodyCapture{ReadCloser: r.Body, max: a.body}
+	r.Body = bc
+	return r, bc
+}
+
+// record writes the line of a request answered with the status code and wb bytes in elapsed
+// time. Requests answered with an error are always logged; others are sampled.
+func (a *accessLog) record(r *http.Request, status int, wb int64, elapsed time.Duration, bc *bodyCapture) {
+	if a == nil || (status < http.StatusBadRequest && a.sample < 1 && rand.Float64() >= a.sample) {
+		return
+	}
+	var line string
+	switch a.format {
+	case AccessLogCommon, AccessLogCombined:
+		line = a.commonLine(r, status, wb, time.Now().Add(-elapsed))
+	default:
+		line = a.jsonLine(r, status, wb, elapsed, bc)
+	}
+	if a.out == nil {
+		a.log.Infof("%s", line)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	io.WriteString(a.out, line+"\n")
+}
+
+// commonLine returns the line of a request received at time t in the Common or Combined Log
+// Format.
+func (a *accessLog) commonLine(r *http.Request, status int, wb int64, t time.Time) string {
+	user := "-"
+	if id, ok := auth.FromContext(r.Context()); ok && id.Subject != "" {
+		user = logField(id.Subject)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %s %d %d", remoteHost(r), user, t.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(r.Method+" "+r.URL.RequestURI()+" "+r.Proto), status, wb)
+	if a.format == AccessLogCombined {
+		line += " " + strconv.Quote(r.Referer()) + " " + strconv.Quote(r.UserAgent())
+	}
+	return line
+}
+
+// jsonLine returns the line of a request in the json format.
+func (a *accessLog) jsonLine(r *http.Request, status int, wb int64, elapsed time.Duration, bc *bodyCapture) string {
+	e := &accessLogEntry{
+		Time:          time.Now().UTC(),
+		RemoteAddr:    r.RemoteAddr,
+		Method:        r.Method,
+		URI:           r.URL.RequestURI(),
+		Proto:         r.Proto,
+		Status:        status,
+		ResponseBytes: wb,
+		DurationMs:    durationMs(elapsed),
+		Header:        a.loggedHeaders(r.Header),
+	}
+	if r.ContentLength > 0 {
+		e.RequestBytes = r.ContentLength
+	}
+	if id, ok := auth.FromContext(r.Context()); ok {
+		e.Subject = id.Subject
+	}
+	if rc, ok := requestFromContext(r.Context()); ok {
+		e.RequestID, e.TraceID = rc.id, rc.trace.traceIDHex()
+	}
+	if bc != nil {
+		e.Body, e.BodyTruncated = string(bc.buf), bc.truncated
+	}
+	b, _ := json.Marshal(e)
+	return string(b)
+}
+
+// loggedHeaders returns the headers of a request that are logged, with secrets redacted.
+func (a *accessLog) loggedHeaders(h http.Header) map[string]string {
+	names := a.headers
+	if names == nil {
+		names = make([]string, 0, len(h))
+		for k := range h {
+			names = append(names, k)
+		}
+	}
+	logged := make(map[string]string, len(names))
+	for _, k := range names {
+		v, ok := h[k]
+		switch {
+		case !ok:
+		case a.redact[k]:
+			logged[k] = accessLogRedacted
+		default:
+			logged[k] = strings.Join(v, ", ")
+		}
+	}
+	return logged
+}
+
+// remoteHost returns the client address of a request without its port.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || host == "" {
+		return "-"
+	}
+	return host
+}
+
+// logField returns a value for an unquoted field of a log line: spaces and control characters
+// are replaced so that the fields of the line stay apart.
+func logField(s string) string {
+	return strings.Map(func(c rune) rune {
+		if c <= ' ' || c == 0x7f {
+			return '_'
+		}
+		return c
+	}, s)
+}
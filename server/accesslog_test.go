@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/composer22/clidemo/auth"
+	"github.com/composer22/clidemo/logger"
+)
+
+// accessLogServer returns a server with an access log of the options written to a temp file,
+// and a function returning the lines written.
+func accessLogServer(t *testing.T, opts *Options) (*Middleware, func() []string) {
+	dir, err := ioutil.TempDir("", "clidemo-access")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	opts.AccessLog = filepath.Join(dir, "access.log")
+	s := &Server{
+		info:   &Info{},
+		opts:   opts,
+		auth:   auth.New(),
+		limits: tokenLimiterNew(),
+		log:    logger.New(logger.UseDefault, false),
+		stats:  StatusNew(),
+	}
+	s.log.SetLogLevel(logger.Emergency)
+	if s.access, err = accessLogNew(opts, s.log); err != nil {
+		t.Fatalf("Cannot open access log: %s", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpRouteParseV1, func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"result":{}}`))
+	})
+	mux.HandleFunc(httpRouteHealthz, s.healthzHandler)
+	lines := func() []string {
+		b, _ := ioutil.ReadFile(opts.AccessLog)
+		if len(b) == 0 {
+			return nil
+		}
+		return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	return &Middleware{serv: s, handler: mux}, lines
+}
+
+// accessLogRequest sends a parse request with body through the middleware.
+func accessLogRequest(m *Middleware, path string, token string, body string) {
+	r := httptest.NewRequest(httpPost, path, strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("Content-Type", mediaTypeJSON)
+	r.Header.Set("Accept", mediaTypeJSON)
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Cookie", "session=secret")
+	r.Header.Set("User-Agent", "test/1.0")
+	r.Header.Set("Referer", "http://example.com/")
+	m.ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	t.Parallel()
+	m, lines := accessLogServer(t, &Options{
+		AccessLogFormat:  AccessLogJSON,
+		AccessLogHeaders: []string{"user-agent", "Cookie", "Authorization"},
+		AccessLogBody:    10,
+		AccessLogSample:  1,
+	})
+	accessLogRequest(m, httpRouteParseV1, "3A3E6C4C51F12DF2415682CCF9D18", `{"text":"a long text"}`)
+	accessLogRequest(m, httpRouteHealthz, "", "")
+
+	l := lines()
+	if len(l) != 1 {
+		t.Fatalf("Only the parse request should be logged: %v", l)
+	}
+	if strings.Contains(l[0], "3A3E6C4C51F12DF2415682CCF9D18") || strings.Contains(l[0], "secret") {
+		t.Errorf("Secrets should be redacted: %s", l[0])
+	}
+	var e accessLogEntry
+	if err := json.Unmarshal([]byte(l[0]), &e); err != nil {
+		t.Fatalf("Invalid json line: %s", l[0])
+	}
+	if e.Status != http.StatusOK || e.ResponseBytes != 13 || e.RequestBytes != 22 || e.RequestID == "" ||
+		e.Method != httpPost || e.URI != httpRouteParseV1 {
+		t.Errorf("Invalid entry: %+v", e)
+	}
+	if e.Header["User-Agent"] != "test/1.0" || e.Header["Authorization"] != accessLogRedacted ||
+		e.Header["Cookie"] != accessLogRedacted || len(e.Header) != 3 {
+		t.Errorf("Headers should be the allowed ones, redacted: %v", e.Header)
+	}
+	if e.Body != `{"text":"a` || !e.BodyTruncated {
+		t.Errorf("Body should be truncated to 10 bytes: %q %t", e.Body, e.BodyTruncated)
+	}
+}
+
+func TestAccessLogCommon(t *testing.T) {
+	t.Parallel()
+	common := `^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] "POST /v1.0/parse HTTP/1.1" 200 13`
+	m, lines := accessLogServer(t, &Options{AccessLogFormat: AccessLogCommon, AccessLogSample: 1})
+	accessLogRequest(m, httpRouteParseV1, "3A3E6C4C51F12DF2415682CCF9D18", `{"text":"abc"}`)
+	if l := lines(); len(l) != 1 || !regexp.MustCompile(common+`$`).MatchString(l[0]) {
+		t.Errorf("Invalid common log line: %v", l)
+	}
+
+	m, lines = accessLogServer(t, &Options{AccessLogFormat: AccessLogCombined, AccessLogSample: 1})
+	accessLogRequest(m, httpRouteParseV1, "3A3E6C4C51F12DF2415682CCF9D18", `{"text":"abc"}`)
+	combined := common + ` "http://example\.com/" "test/1\.0"$`
+	if l := lines(); len(l) != 1 || !regexp.MustCompile(combined).MatchString(l[0]) {
+		t.Errorf("Invalid combined log line: %v", l)
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	t.Parallel()
+	m, lines := accessLogServer(t, &Options{AccessLogSample: 0})
+	accessLogRequest(m, httpRouteParseV1, "3A3E6C4C51F12DF2415682CCF9D18", `{"text":"abc"}`)
+	accessLogRequest(m, httpRouteParseV1, "NOT A TOKEN", `{"text":"abc"}`)
+	l := lines()
+	if len(l) != 1 || !strings.Contains(l[0], `"status":401`) || strings.Contains(l[0], `"body"`) {
+		t.Errorf("Only the refused request should be logged, without its body: %v", l)
+	}
+}
+
+func TestAccessLogNew(t *testing.T) {
+	t.Parallel()
+	if _, err := accessLogNew(&Options{AccessLogFormat: "apache"}, nil); err != ErrAccessLogFormat {
+		t.Errorf("Invalid format should be refused: %v", err)
+	}
+	if a, err := accessLogNew(&Options{AccessLogFormat: AccessLogOff}, nil); a != nil || err != nil {
+		t.Errorf("Access log should be off: %v", err)
+	}
+	a, _ := accessLogNew(&Options{AccessLogHeaders: []string{"-"}, AccessLogRedact: []string{"x-secret"}}, nil)
+	h := http.Header{"User-Agent": {"test"}, "X-Secret": {"1"}}
+	if a.format != AccessLogJSON || len(a.loggedHeaders(h)) != 0 {
+		t.Errorf("No headers should be logged: %v", a.loggedHeaders(h))
+	}
+	a, _ = accessLogNew(&Options{AccessLogHeaders: []string{"*"}, AccessLogRedact: []string{"x-secret"}}, nil)
+	if lh := a.loggedHeaders(h); lh["User-Agent"] != "test" || lh["X-Secret"] != accessLogRedacted {
+		t.Errorf("All headers should be logged, redacted: %v", lh)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/composer22/clidemo/auth"
+)
+
+// tokenRequest is the body of a request to create a token.
+type tokenRequest struct {
+	Label   string      `json:"label"`   // What the token is for.
+	Scopes  []string    `json:"scopes"`  // What the token may be used for.
+	TTL     string      `json:"ttl"`     // Duration until the token expires e.g. 720h (empty = never).
+	Premium bool        `json:"premium"` // Requests are given priority.
+	Limits  auth.Limits `json:"limits"`  // Usage limits of the token.
+}
+
+// adminTokensHandler handles the token lifecycle requests of an admin: POST creates a token, GET
+// lists the tokens, and DELETE on /v1.0/admin/tokens/{id} revokes one. The admin scope is
+// checked by the middleware.
+func (s *Server) adminTokensHandler(w http.ResponseWriter, r *http.Request) {
+	if s.auth.Store == nil {
+		writeError(w, http.StatusNotImplemented, APIErrorNew(CodeNotImplemented, TokenStoreDisabled))
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, httpRouteAdminTokensV1+"/")
+	if r.URL.Path == httpRouteAdminTokensV1 || id == "" {
+		switch r.Method {
+		case httpGet:
+			s.listTokens(w)
+		case httpPost:
+			s.createToken(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, APIErrorNew(CodeMethodNotAllowed, InvalidMethod))
+		}
+		return
+	}
+	if s.invalidMethod(w, r, httpDelete) {
+		return
+	}
+	s.revokeToken(w, r, id)
+}
+
+// createToken creates a token in the store. The token is only returned by this request.
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, APIErrorNew(CodeInvalidBody, InvalidBody))
+		return
+	}
+	var req tokenRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			writeError(w, http.StatusBadRequest, APIErrorNew(CodeInvalidField,
+				InvalidJSONType, withField(typeErr.Field)))
+			return
+		}
+		writeError(w, http.StatusBadRequest, APIErrorNew(CodeInvalidJSON, InvalidJSONText))
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl < 0 {
+			writeError(w, http.StatusBadRequest, APIErrorNew(CodeInvalidField, InvalidTokenTTL, withField("ttl")))
+			return
+		}
+	}
+	tk, rec, err := s.auth.Store.Create(req.Label, req.Scopes, ttl, func(rc *auth.Record) {
+		rc.Premium = req.Premium
+		rc.Limits = req.Limits
+	})
+	if err != nil {
+		s.log.Errorf("%sCannot create token: %s", logPrefix(r.Context()), err)
+		writeError(w, http.StatusInternalServerError, APIErrorNew(CodeInternal, TokenStoreError))
+		return
+	}
+	b, _ = json.Marshal(&struct {
+		Token  string       `json:"token"`
+		Record *auth.Record `json:"record"`
+	}{tk, rec})
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+// listTokens returns the records of the tokens in the store.
+func (s *Server) listTokens(w http.ResponseWriter) {
+	b, _ := json.Marshal(&struct {
+		Tokens []auth.Record `json:"tokens"`
+	}{s.auth.Store.List()})
+	w.Write(b)
+}
+
+// revokeToken revokes the token with the id.
+func (s *Server) revokeToken(w http.ResponseWriter, r *http.Request, id string) {
+	rec, err := s.auth.Store.Revoke(id)
+	switch {
+	case err == auth.ErrTokenNotFound:
+		writeError(w, http.StatusNotFound, APIErrorNew(CodeNotFound, TokenNotFound))
+		return
+	case err != nil:
+		s.log.Errorf("%sCannot revoke token %s: %s", logPrefix(r.Context()), id, err)
+		writeError(w, http.StatusInternalServerError, APIErrorNew(CodeInternal, TokenStoreError))
+		return
+	}
+	b, _ := json.Marshal(&struct {
+		Record *auth.Record `json:"record"`
+	}{rec})
+	w.Write(b)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/composer22/clidemo/auth"
+)
+
+const testAdminToken = "3A3E6C4C51F12DF2415682CCF9D18"
+
+func TestAdminTokensHandler(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "clidemo-admin")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Server{auth: auth.New()}
+	request := func(method string, path string, token string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.adminTokensHandler(w, r)
+		return w
+	}
+
+	if w := request(httpGet, httpRouteAdminTokensV1, testAdminToken, ""); w.Code != http.StatusNotImplemented {
+		t.Errorf("Admin API without a store should get a 501: %d", w.Code)
+	}
+	s.auth.Store, _ = auth.OpenStore(filepath.Join(dir, "tokens.json"))
+
+	w := request(httpPost, httpRouteAdminTokensV1, testAdminToken,
+		`{"label":"ci","scopes":["parse"],"ttl":"720h","limits":{"maxDocBytes":100}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Token not created: %d %s", w.Code, w.Body)
+	}
+	var created struct {
+		Token  string      `json:"token"`
+		Record auth.Record `json:"record"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if created.Token == "" || created.Record.Expires == nil || created.Record.Limits.MaxDocBytes != 100 {
+		t.Errorf("Created token not returned: %s", w.Body)
+	}
+	if !s.auth.Valid(created.Token) {
+		t.Errorf("Created token not valid.")
+	}
+
+	if w := request(httpPost, httpRouteAdminTokensV1, testAdminToken, `{"ttl":"soon"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid ttl should get a 400: %d", w.Code)
+	}
+	w = request(httpPost, httpRouteAdminTokensV1, testAdminToken, `{"scopes":"parse"}`)
+	if e := decodeError(w.Body.Bytes()); w.Code != http.StatusBadRequest || e.Code != CodeInvalidField || e.Field != "scopes" {
+		t.Errorf("Invalid scopes should get a 400 naming the field: %d %s", w.Code, w.Body)
+	}
+
+	w = request(httpGet, httpRouteAdminTokensV1, testAdminToken, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), created.Record.ID) ||
+		strings.Contains(w.Body.String(), created.Token) {
+		t.Errorf("Tokens not listed: %d %s", w.Code, w.Body)
+	}
+
+	if w := request(httpDelete, httpRouteAdminTokensV1+"/"+created.Record.ID, testAdminToken, ""); w.Code != http.StatusOK {
+		t.Errorf("Token not revoked: %d %s", w.Code, w.Body)
+	}
+	if s.auth.Valid(created.Token) {
+		t.Errorf("Revoked token still valid.")
+	}
+	if w := request(httpDelete, httpRouteAdminTokensV1+"/nope", testAdminToken, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Revoking a missing token should get a 404: %d", w.Code)
+	}
+	if w := request(httpPut, httpRouteAdminTokensV1, testAdminToken, ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Invalid method should get a 405: %d", w.Code)
+	}
+}
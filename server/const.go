@@ -7,21 +7,106 @@ const (
 	DefaultHostname       = "localhost" // The hostname of the server.
 	DefaultPort           = 49152       // Port to receive requests: see IANA Port Numbers.
 	DefaultProfPort       = 0           // Profiler port to receive requests.*
+	DefaultSockPort       = 0           // Socket protocol port to receive requests.*
 	DefaultMaxConnections = 0           // Maximum number of connections allowed.*
+	DefaultMinWorkers     = 10          // Number of workers kept running when idle.
 	DefaultMaxWorkers     = 1000        // Maximum number of running workers allowed.
 	DefaultMaxProcs       = 0           // Maximum number of computer processors to utilize.*
+	DefaultUnixSocketMode = "0660"      // File mode of a Unix domain socket listener.
+	DefaultMaxParseTime   = 0           // Maximum time a parse request may take.*
+	DefaultQueueDepth     = 0           // Maximum number of jobs waiting for a worker (0 = max workers).
+	DefaultPriorityBytes  = 1024        // Documents up to this size jump the queue (0 = disabled).
+	DefaultConnOverflow   = "block"     // What to do with connections over the maximum.
 
 	// * zeros = no change or no limitations or not enabled.
 
+	// How long a parse job may wait for a worker before the request gets a 503 (0 = unlimited).
+	DefaultQueueTimeout = 10 * time.Second
+
+	// How long a worker above the minimum waits for a job before it is retired.
+	DefaultWorkerIdleTimeout = 30 * time.Second
+
+	// How long a parse job waits for a worker before the pool spawns another.
+	DefaultWorkerGrowWait = 10 * time.Millisecond
+
+	// Most text a websocket stream may send over its connection.
+	DefaultStreamMaxBytes = 10 << 20
+
+	// How long a connection over the maximum waits for a free one in "wait" overflow mode.
+	DefaultConnWaitTimeout = 5 * time.Second
+
+	// Limits per client address.
+	DefaultMaxConnPerIP   = 0 // Maximum concurrent connections.*
+	DefaultConnRatePerIP  = 0 // Connections per second.*
+	DefaultConnBurstPerIP = 10
+	DefaultBanAfter       = 0 // Limit violations before a temporary ban.*
+	DefaultBanTime        = 5 * time.Minute
+
+	// Statistics history: how often a snapshot is taken, and how long snapshots are kept.
+	DefaultStatsInterval = time.Minute
+	DefaultStatsHistory  = 24 * time.Hour
+
+	// Tracing: the ratio of the traces started here that are sampled, and how often spans are
+	// exported.
+	DefaultTraceSample  = 1.0
+	TraceExportInterval = 5 * time.Second
+
+	// Access log: the format, and the ratio of the successful requests that are logged.
+	DefaultAccessLogFormat = AccessLogJSON
+	DefaultAccessLogSample = 1.0
+
+	// Clock difference allowed between a signed request and the server.
+	DefaultHMACSkew = 5 * time.Minute
+
+	// Largest body of a signed request, which is read before the request is authenticated.
+	DefaultHMACMaxBody = 10 << 20
+
+	// How long the added health checks of a readiness probe may take.
+	HealthCheckTimeout = 2 * time.Second
+
 	// Listener and connections.
 	TCPKeepAliveTimeout = 3 * time.Minute
 	TCPReadTimeout      = 10 * time.Second
 	TCPWriteTimeout     = 10 * time.Second
 
+	// Number of words parsed between progress events.
+	ProgressInterval = 10000
+
+	// Graceful restart: how long to wait for the new process to accept connections.
+	RestartTimeout = 30 * time.Second
+
 	// http: routes.
 	httpRouteAliveV1  = "/v1.0/alive"
 	httpRouteParseV1  = "/v1.0/parse"
 	httpRouteStatusV1 = "/v1.0/status"
+	httpRouteStreamV1 = "/v1.0/parse/stream"
+	httpRouteEventsV1 = "/v1.0/parse/events"
+
+	httpRouteHistoryV1     = "/v1.0/status/history"
+	httpRouteResetV1       = "/v1.0/status/reset"
+	httpRouteAdminTokensV1 = "/v1.0/admin/tokens"
+	httpRouteMetrics       = "/metrics" // Prometheus scrapes this path by default.
+
+	// http: health probes for container orchestrators, without authorization.
+	httpRouteHealthz  = "/healthz"  // Liveness.
+	httpRouteReadyz   = "/readyz"   // Readiness.
+	httpRouteStartupz = "/startupz" // Startup.
+
+	// socket protocol: routes for statistics.
+	sockRouteAuth  = "socket:auth"
+	sockRouteParse = "socket:parse"
+
+	// websocket: routes for statistics.
+	wsRouteChunk = "websocket:chunk"
+
+	// http: media types.
+	mediaTypeJSON   = "application/json"
+	MediaTypeBinary = "application/vnd.clidemo.binary" // See parser.MarshalBinary.
+
+	mediaTypeEventStream = "text/event-stream"
+
+	// http: status code for a client that went away before the response (from nginx).
+	StatusClientClosedRequest = 499
 
 	httpGet    = "GET"
 	httpPost   = "POST"
@@ -31,11 +116,64 @@ const (
 	httpTrace  = "TRACE"
 	httpPatch  = "PATCH"
 
+	// Health check statuses.
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthFailing  = "failing"
+
 	// Error messages.
 	InvalidMediaType     = "Invalid Content-Type or Accept header value."
 	InvalidMethod        = "Invalid Method for this route."
 	InvalidBody          = "Invalid body of text in request."
 	InvalidJSONText      = "Invalid JSON format in text of body in request."
 	InvalidJSONAttribute = "Invalid - 'text' attribute in JSON not found."
+	InvalidJSONType      = "Invalid - attribute in JSON has the wrong type."
+	InvalidBinaryBody    = "Invalid binary encoded request in body."
+	InvalidStreaming     = "Streaming is not supported by this connection."
+	ParseTimeout         = "Parse request timed out."
+	ParseCancelled       = "Parse request cancelled by the client."
+	ServerBusy           = "Server is busy. Please retry later."
+	RateLimited          = "Too many requests for this token. Please retry later."
+	QuotaExceeded        = "Daily quota for this token exceeded."
+	DocumentTooLarge     = "Document exceeds the maximum size for this token."
+	BodyTooLarge         = "Request body exceeds the maximum size."
 	InvalidAuthorization = "Invalid authorization."
+	InvalidScope         = "Token is not authorized for this route."
+	InvalidTokenTTL      = "Invalid - 'ttl' attribute must be a duration e.g. 720h."
+	TokenNotFound        = "Token not found."
+	TokenStoreDisabled   = "Token store is not configured."
+	TokenStoreError      = "Token store could not be updated."
+	InvalidHistoryTime   = "Invalid - 'from' and 'to' must be RFC 3339 times or unix seconds."
+	InvalidFrameType     = "Invalid frame type for socket request."
+	InvalidFrameSize     = "Frame exceeds the maximum size."
+	ServerStarting       = "Server is starting."
+	ServerDraining       = "Server is draining connections."
+	QueueSaturated       = "Parse queue is full."
+	InvalidUpgrade       = "Invalid - this route requires a websocket upgrade."
+	InvalidHandshake     = "Invalid websocket handshake."
+	RouteNotFound        = "Route not found."
+
+	// Error codes: the machine readable part of the errors returned by the http API.
+	CodeInvalidMediaType     = "invalid_media_type"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeInvalidBody          = "invalid_body"
+	CodeInvalidJSON          = "invalid_json"
+	CodeInvalidBinary        = "invalid_binary"
+	CodeMissingField         = "missing_field"
+	CodeInvalidField         = "invalid_field"
+	CodeUpgradeRequired      = "upgrade_required"
+	CodeInvalidHandshake     = "invalid_handshake"
+	CodeStreamingUnsupported = "streaming_unsupported"
+	CodeParseTimeout         = "parse_timeout"
+	CodeParseCancelled       = "parse_cancelled"
+	CodeServerBusy           = "server_busy"
+	CodeRateLimited          = "rate_limited"
+	CodeQuotaExceeded        = "quota_exceeded"
+	CodeDocumentTooLarge     = "document_too_large"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeUnauthorized         = "unauthorized"
+	CodeInsufficientScope    = "insufficient_scope"
+	CodeNotFound             = "not_found"
+	CodeNotImplemented       = "not_implemented"
+	CodeInternal             = "internal_error"
 )
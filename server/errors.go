@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// APIError is an error returned by the http API. It is sent as the body of the response in an
+// object {"error":{...}}, so that clients can tell errors from results. Code is stable and meant
+// for programs; Message is meant for people and may change.
+type APIError struct {
+	Code      string `json:"code"`                // One of the Code* constants.
+	Message   string `json:"message"`             // What went wrong.
+	RequestID string `json:"requestId,omitempty"` // The X-Request-ID of the response.
+	Field     string `json:"field,omitempty"`     // The attribute or parameter that failed validation.
+	Scope     string `json:"scope,omitempty"`     // The scope the token is missing.
+}
+
+// APIErrorNew is a factory function that returns a new error of the API.
+func APIErrorNew(code string, message string, options ...func(*APIError)) *APIError {
+	e := &APIError{Code: code, Message: message}
+	for _, f := range options {
+		f(e)
+	}
+	return e
+}
+
+// Error is an implementation of the error interface.
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// body returns the json encoded response body of the error.
+func (e *APIError) body() []byte {
+	b, _ := json.Marshal(&struct {
+		Error *APIError `json:"error"`
+	}{e})
+	return b
+}
+
+// withField sets the attribute or parameter that failed validation.
+func withField(field string) func(*APIError) {
+	return func(e *APIError) {
+		e.Field = field
+	}
+}
+
+// writeError returns an error of the API to the client with the status code. The request ID is
+// taken from the response header set by the middleware.
+func writeError(w http.ResponseWriter, status int, e *APIError) {
+	h := w.Header()
+	if e.RequestID == "" {
+		e.RequestID = h.Get("X-Request-ID")
+	}
+	h.Set("Content-Type", "application/json;charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(e.body())
+}
+
+// notFoundHandler handles a request for a route that does not exist.
+func (s *Server) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, APIErrorNew(CodeNotFound, RouteNotFound))
+}
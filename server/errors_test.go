@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/composer22/clidemo/auth"
+	"github.com/composer22/clidemo/logger"
+)
+
+// decodeError returns the error in the body of an error response of the API.
+func decodeError(b []byte) APIError {
+	var body struct {
+		Error APIError `json:"error"`
+	}
+	json.Unmarshal(b, &body)
+	return body.Error
+}
+
+func TestWriteError(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	w.Header().Set("X-Request-ID", "1234")
+	writeError(w, http.StatusBadRequest, APIErrorNew(CodeInvalidField, InvalidTokenTTL, withField("ttl")))
+	if w.Code != http.StatusBadRequest || !strings.HasPrefix(w.Header().Get("Content-Type"), mediaTypeJSON) {
+		t.Errorf("Error returned with invalid status or type: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	want := `{"error":{"code":"invalid_field","message":"` + InvalidTokenTTL + `","requestId":"1234","field":"ttl"}}`
+	if w.Body.String() != want {
+		t.Errorf("Invalid error body: %s", w.Body)
+	}
+	if e := APIErrorNew(CodeNotFound, TokenNotFound); e.Error() != TokenNotFound {
+		t.Errorf("Error should return its message: %s", e)
+	}
+}
+
+func TestParseRequestErrors(t *testing.T) {
+	t.Parallel()
+	s := &Server{auth: auth.New()}
+	tests := []struct {
+		body  string
+		code  string
+		field string
+	}{
+		{`"text":"abc"`, CodeInvalidJSON, ""},
+		{`{"monkey":"abc"}`, CodeMissingField, "text"},
+		{`{"text":42}`, CodeInvalidField, "text"},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(httpPost, httpRouteParseV1, strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", mediaTypeJSON)
+		w := httptest.NewRecorder()
+		if _, ok := s.readParseRequest(w, r); ok {
+			t.Fatalf("%s should be refused.", tc.body)
+		}
+		if e := decodeError(w.Body.Bytes()); w.Code != http.StatusBadRequest || e.Code != tc.code || e.Field != tc.field {
+			t.Errorf("%s should return %s %q. Returned: %d %s", tc.body, tc.code, tc.field, w.Code, w.Body)
+		}
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	t.Parallel()
+	s := &Server{
+		info:   &Info{},
+		auth:   auth.New(),
+		limits: tokenLimiterNew(),
+		log:    logger.New(logger.UseDefault, false),
+		stats:  StatusNew(),
+	}
+	s.log.SetLogLevel(logger.Emergency)
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpRouteStreamV1, s.streamHandler)
+	mux.HandleFunc("/", s.notFoundHandler)
+	m := &Middleware{serv: s, handler: mux}
+
+	tests := []struct {
+		path    string
+		token   string
+		upgrade bool
+		status  int
+		code    string
+	}{
+		{"/no/such/route", testAdminToken, false, http.StatusNotFound, CodeNotFound},
+		{httpRouteStreamV1, testAdminToken, false, http.StatusUpgradeRequired, CodeUpgradeRequired},
+		{httpRouteStreamV1, testAdminToken, true, http.StatusBadRequest, CodeInvalidHandshake},
+		{httpRouteStreamV1, "NOT A TOKEN", false, http.StatusUnauthorized, CodeUnauthorized},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(httpGet, tc.path, nil)
+		r.Header.Set("Content-Type", mediaTypeJSON)
+		r.Header.Set("Accept", mediaTypeJSON)
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+		if tc.upgrade { // Without a Sec-WebSocket-Key.
+			r.Header.Set("Upgrade", "websocket")
+			r.Header.Set("Connection", "Upgrade")
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		e := decodeError(w.Body.Bytes())
+		if w.Code != tc.status || e.Code != tc.code {
+			t.Errorf("%s should return %d %s. Returned: %d %s", tc.path, tc.status, tc.code, w.Code, w.Body)
+		}
+		if e.RequestID == "" || e.RequestID != w.Header().Get("X-Request-ID") {
+			t.Errorf("%s error should carry the request ID: %s", tc.path, w.Body)
+		}
+	}
+}
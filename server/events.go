@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/composer22/clidemo/parser"
+)
+
+// eventsHandler handles a parse request from the client and returns a Server-Sent Events stream:
+// progress events while the worker parses the text, then a result event, or an error event if
+// the parse times out or the server is busy.
+func (s *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidMethod(w, r, httpPost) {
+		return
+	}
+	d, ok := s.readParseRequest(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, APIErrorNew(CodeStreamingUnsupported, InvalidStreaming))
+		return
+	}
+
+	// Long parses may take longer than the write timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	h := w.Header()
+	h.Set("Content-Type", mediaTypeEventStream)
+	h.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx, cancel := s.parseContext(r.Context())
+	defer cancel()
+	job := parseJobNew(ctx, d, func(j *parseJob) {
+		j.ProgressCh = make(chan parser.Progress, 16)
+		j.Priority = s.priority(len(d), s.identity(r))
+		j.route = httpRouteEventsV1
+	})
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- s.runJob(job)
+	}()
+	for {
+		select {
+		case pr := <-job.ProgressCh:
+			b, _ := json.Marshal(pr)
+			writeEvent(w, "progress", string(b))
+			flusher.Flush()
+		case err := <-doneCh:
+			if err != nil {
+				_, e := jobError(err)
+				e.RequestID = h.Get("X-Request-ID")
+				writeEvent(w, "error", string(e.body()))
+				flusher.Flush()
+				return
+			}
+			for len(job.ProgressCh) > 0 { // Send reports the worker made before finishing.
+				b, _ := json.Marshal(<-job.ProgressCh)
+				writeEvent(w, "progress", string(b))
+			}
+			writeEvent(w, "result", fmt.Sprintf(`{"result":%s}`, job.Result))
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+// writeEvent writes a single Server-Sent Event. data must not contain new lines.
+func writeEvent(w http.ResponseWriter, event string, data string) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
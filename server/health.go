@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// HealthCheck checks a part of the server, such as a storage backend. It returns nil when the
+// part is healthy, an error made with Degraded when it still works with reduced service, and any
+// other error when it fails. It should return when ctx is done.
+type HealthCheck func(ctx context.Context) error
+
+// degradedError is the error of a part of the server that still works with reduced service.
+type degradedError struct {
+	err error
+}
+
+// Error is an implementation of the error interface.
+func (e degradedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error that degraded the part.
+func (e degradedError) Unwrap() error {
+	return e.err
+}
+
+// Degraded returns err marked as a degraded state of a part rather than a failure. The server
+// stays ready while parts are degraded.
+func Degraded(err error) error {
+	return degradedError{err}
+}
+
+// healthResult is the result of a health check.
+type healthResult struct {
+	Status string `json:"status"`          // ok, degraded or failing.
+	Error  string `json:"error,omitempty"` // Why the check did not pass.
+}
+
+// healthReport is the answer to a health probe.
+type healthReport struct {
+	Status string                  `json:"status"` // The worst status of the checks.
+	Checks map[string]healthResult `json:"checks,omitempty"`
+}
+
+// add adds the result of a check to the report.
+func (h *healthReport) add(name string, err error) {
+	if h.Checks == nil {
+		h.Checks = make(map[string]healthResult)
+	}
+	res := healthResult{Status: HealthOK}
+	var d degradedError
+	switch {
+	case err == nil:
+	case errors.As(err, &d):
+		res = healthResult{Status: HealthDegraded, Error: err.Error()}
+	default:
+		res = healthResult{Status: HealthFailing, Error: err.Error()}
+	}
+	h.Checks[name] = res
+	if res.Status == HealthFailing || (res.Status == HealthDegraded && h.Status != HealthFailing) {
+		h.Status = res.Status
+	}
+}
+
+// AddHealthCheck adds a check of a part of the server to the readiness probe. A failing check
+// makes the server not ready; a degraded one is reported but the server stays ready.
+func (s *Server) AddHealthCheck(name string, check HealthCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.health == nil {
+		s.health = make(map[string]HealthCheck)
+	}
+	s.health[name] = check
+}
+
+// readiness returns the report of the readiness probe: the server has started and is not
+// draining, the parse queue has room, and the added checks pass.
+func (s *Server) readiness(ctx context.Context) *healthReport {
+	s.mu.Lock()
+	running, draining, jobq := s.running, s.draining, s.jobq
+	checks := make(map[string]HealthCheck, len(s.health))
+	for name, c := range s.health {
+		checks[name] = c
+	}
+	s.mu.Unlock()
+
+	h := &healthReport{Status: HealthOK}
+	switch {
+	case !running:
+		h.add("workers", errors.New(ServerStarting))
+	case draining:
+		h.add("workers", errors.New(ServerDraining))
+	default:
+		h.add("workers", nil)
+	}
+	if jobq != nil {
+		if st := jobq.Stats(); st.Capacity > 0 && st.Depth >= st.Capacity {
+			h.add("queue", errors.New(QueueSaturated))
+		} else {
+			h.add("queue", nil)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, c := range checks {
+		wg.Add(1)
+		go func(name string, c HealthCheck) {
+			defer wg.Done()
+			err := c(ctx)
+			mu.Lock()
+			h.add(name, err)
+			mu.Unlock()
+		}(name, c)
+	}
+	wg.Wait()
+	return h
+}
+
+// writeHealth writes a health report, with a 503 if it is failing.
+func writeHealth(w http.ResponseWriter, h *healthReport) {
+	if h.Status == HealthFailing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	b, _ := json.Marshal(h)
+	w.Write(b)
+}
+
+// healthzHandler handles a liveness probe. The server is alive while it answers.
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidMethod(w, r, httpGet) {
+		return
+	}
+	writeHealth(w, &healthReport{Status: HealthOK})
+}
+
+// readyzHandler handles a readiness probe: whether the server should be sent requests.
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidMethod(w, r, httpGet) {
+		return
+	}
+	writeHealth(w, s.readiness(r.Context()))
+}
+
+// startupzHandler handles a startup probe: whether the server has finished starting. It stays
+// up once the server has started, so that draining is left to the readiness probe.
+func (s *Server) startupzHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidMethod(w, r, httpGet) {
+		return
+	}
+	h := &healthReport{Status: HealthOK}
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+	if !started {
+		h.add("startup", errors.New(ServerStarting))
+	}
+	writeHealth(w, h)
+}
+
+// healthRoute returns true if the path is a health probe, which needs no authorization.
+func healthRoute(path string) bool {
+	return path == httpRouteHealthz || path == httpRouteReadyz || path == httpRouteStartupz
+}
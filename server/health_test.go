@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/composer22/clidemo/auth"
+	"github.com/composer22/clidemo/logger"
+)
+
+func TestHealthReport(t *testing.T) {
+	t.Parallel()
+	h := &healthReport{Status: HealthOK}
+	h.add("a", nil)
+	if h.Status != HealthOK || h.Checks["a"].Status != HealthOK {
+		t.Errorf("Passing check should be ok: %+v", h)
+	}
+	h.add("b", Degraded(errors.New("slow")))
+	if h.Status != HealthDegraded || h.Checks["b"].Error != "slow" {
+		t.Errorf("Degraded check should degrade the report: %+v", h)
+	}
+	h.add("c", errors.New("down"))
+	h.add("d", Degraded(errors.New("slow")))
+	if h.Status != HealthFailing || h.Checks["c"].Status != HealthFailing {
+		t.Errorf("Failing check should fail the report: %+v", h)
+	}
+}
+
+func TestHealthProbes(t *testing.T) {
+	t.Parallel()
+	s := &Server{
+		info:   &Info{},
+		auth:   auth.New(),
+		limits: tokenLimiterNew(),
+		log:    logger.New(logger.UseDefault, false),
+		stats:  StatusNew(),
+	}
+	s.log.SetLogLevel(logger.Emergency)
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpRouteHealthz, s.healthzHandler)
+	mux.HandleFunc(httpRouteReadyz, s.readyzHandler)
+	mux.HandleFunc(httpRouteStartupz, s.startupzHandler)
+	m := &Middleware{serv: s, handler: mux}
+	probe := func(path string) (int, healthReport) {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest(httpGet, path, nil)) // No authorization or headers.
+		var h healthReport
+		json.Unmarshal(w.Body.Bytes(), &h)
+		return w.Code, h
+	}
+
+	if code, h := probe(httpRouteHealthz); code != http.StatusOK || h.Status != HealthOK {
+		t.Errorf("Liveness probe should pass: %d %+v", code, h)
+	}
+	if code, _ := probe(httpRouteStartupz); code != http.StatusServiceUnavailable {
+		t.Errorf("Startup probe should fail before the server starts: %d", code)
+	}
+	if code, h := probe(httpRouteReadyz); code != http.StatusServiceUnavailable ||
+		h.Checks["workers"].Error != ServerStarting {
+		t.Errorf("Readiness probe should fail before the server starts: %d %+v", code, h)
+	}
+
+	s.mu.Lock()
+	s.running, s.started = true, true
+	s.jobq = jobQueueNew(1)
+	s.mu.Unlock()
+	if code, _ := probe(httpRouteStartupz); code != http.StatusOK {
+		t.Errorf("Startup probe should pass once started: %d", code)
+	}
+	if code, h := probe(httpRouteReadyz); code != http.StatusOK || h.Status != HealthOK {
+		t.Errorf("Readiness probe should pass once started: %d %+v", code, h)
+	}
+
+	s.AddHealthCheck("storage", func(ctx context.Context) error {
+		return Degraded(errors.New("replica behind"))
+	})
+	if code, h := probe(httpRouteReadyz); code != http.StatusOK || h.Status != HealthDegraded {
+		t.Errorf("Degraded check should be reported but ready: %d %+v", code, h)
+	}
+	s.AddHealthCheck("storage", func(ctx context.Context) error {
+		return errors.New("unreachable")
+	})
+	if code, h := probe(httpRouteReadyz); code != http.StatusServiceUnavailable || h.Checks["storage"].Error != "unreachable" {
+		t.Errorf("Failing check should make the server not ready: %d %+v", code, h)
+	}
+	s.AddHealthCheck("storage", func(ctx context.Context) error { return nil })
+
+	s.jobq.enqueue(parseJobNew(context.Background(), "Some text."), 0)
+	if code, h := probe(httpRouteReadyz); code != http.StatusServiceUnavailable || h.Checks["queue"].Error != QueueSaturated {
+		t.Errorf("Full queue should make the server not ready: %d %+v", code, h)
+	}
+
+	s.mu.Lock()
+	s.jobq = jobQueueNew(1)
+	s.draining = true
+	s.mu.Unlock()
+	if code, h := probe(httpRouteReadyz); code != http.StatusServiceUnavailable || h.Checks["workers"].Error != ServerDraining {
+		t.Errorf("Draining server should not be ready: %d %+v", code, h)
+	}
+	if code, _ := probe(httpRouteStartupz); code != http.StatusOK {
+		t.Errorf("Startup probe should still pass while draining: %d", code)
+	}
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// StatsSnapshot is the state of the server statistics at a time.
+type StatsSnapshot struct {
+	Time         time.Time                   `json:"time"`
+	RequestCount int64                       `json:"requestCount"`
+	RequestBytes int64                       `json:"requestBytes"`
+	Cancelled    int64                       `json:"cancelledCount"`
+	TimedOut     int64                       `json:"timeoutCount"`
+	ConnRejected int64                       `json:"connRejected"`
+	ConnTimedOut int64                       `json:"connTimedOut"`
+	ConnQueued   int64                       `json:"connQueued"`
+	Queue        QueueStats                  `json:"queue"`
+	Workers      WorkerStats                 `json:"workers"`
+	RouteStats   map[string]map[string]int64 `json:"routeStats"` // Only of the routes of the server.
+}
+
+// statsRoute returns true if path is one of the fixed routes of the server whose counters are
+// kept in snapshots: the http routes named by metricsRoute, and the socket and websocket ones.
+func statsRoute(path string) bool {
+	switch path {
+	case sockRouteAuth, sockRouteParse, wsRouteChunk:
+		return true
+	}
+	return metricsRoute(path) == path
+}
+
+// statsHistory is a ring buffer of the latest snapshots of the statistics.
+type statsHistory struct {
+	snaps []StatsSnapshot
+	next  int  // Where the next snapshot goes.
+	full  bool // The buffer has wrapped around.
+}
+
+// statsHistoryNew is a factory function that returns a history of up to size snapshots.
+func statsHistoryNew(size int) *statsHistory {
+	if size < 1 {
+		size = 1
+	}
+	return &statsHistory{snaps: make([]StatsSnapshot, size)}
+}
+
+// add adds a snapshot, replacing the oldest if the history is full.
+func (h *statsHistory) add(sn StatsSnapshot) {
+	h.snaps[h.next] = sn
+	h.next = (h.next + 1) % len(h.snaps)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Len returns the number of snapshots in the history.
+func (h *statsHistory) Len() int {
+	if h.full {
+		return len(h.snaps)
+	}
+	return h.next
+}
+
+// Range returns the snapshots taken from and to the times given, oldest first. A zero time is
+// unbounded.
+func (h *statsHistory) Range(from time.Time, to time.Time) []StatsSnapshot {
+	snaps := []StatsSnapshot{}
+	start := 0
+	if h.full {
+		start = h.next
+	}
+	for i := 0; i < h.Len(); i++ {
+		sn := h.snaps[(start+i)%len(h.snaps)]
+		if (!from.IsZero() && sn.Time.Before(from)) || (!to.IsZero() && sn.Time.After(to)) {
+			continue
+		}
+		snaps = append(snaps, sn)
+	}
+	return snaps
+}
+
+// statsFile is the content of the file that keeps the statistics across restarts.
+type statsFile struct {
+	Since    time.Time       `json:"since"`    // When the counters started.
+	Counters StatsSnapshot   `json:"counters"` // The counters when the server stopped.
+	History  []StatsSnapshot `json:"history"`  // Oldest first.
+}
+
+// refreshStats reads the statistics kept by the listener, queue and workers into statistics st
+// returned by Status.Read. The server lock must be held.
+func (s *Server) refreshStats(st *Status) {
+	if s.listener != nil {
+		st.ConnNumAvail = s.listener.GetConnNumAvail() // Get latest live connection count.
+		st.ConnRejected = s.listener.GetConnRejected()
+		st.ConnTimedOut = s.listener.GetConnTimedOut()
+		st.ConnQueued = s.listener.GetConnQueued()
+		st.ConnWaiting = s.listener.GetConnWaiting()
+		if s.listener.Guard != nil {
+			gs := s.listener.Guard.Stats()
+			st.IPGuard = &gs
+		}
+	}
+	st.Tokens = s.limits.Usage()
+	if s.jobq != nil {
+		st.Queue = s.jobq.Stats()
+		st.Workers = s.pool.Stats()
+	}
+}
+
+// recordHistory adds a snapshot of the statistics to the history every stats interval until
+// doneCh is closed.
+func (s *Server) recordHistory(interval time.Duration, doneCh chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			st := s.stats.Read(now)
+			s.mu.Lock()
+			s.refreshStats(st)
+			s.history.add(st.Snapshot(now))
+			s.mu.Unlock()
+		case <-doneCh:
+			return
+		}
+	}
+}
+
+// loadStats restores the counters and history saved in the stats file, if there is one.
+func (s *Server) loadStats() error {
+	b, err := ioutil.ReadFile(s.opts.StatsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var sf statsFile
+	if err := json.Unmarshal(b, &sf); err != nil {
+		return err
+	}
+	s.stats.Restore(sf.Counters, sf.Since)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sn := range sf.History {
+		for path := range sn.RouteStats {
+			if !statsRoute(path) {
+				delete(sn.RouteStats, path)
+			}
+		}
+		s.history.add(sn)
+	}
+	return nil
+}
+
+// saveStats writes the counters and history to the stats file, if one is configured.
+func (s *Server) saveStats() error {
+	if s.opts.StatsFile == "" {
+		return nil
+	}
+	now := time.Now()
+	st := s.stats.Read(now)
+	s.mu.Lock()
+	s.refreshStats(st)
+	sf := statsFile{
+		Since:    st.Since,
+		Counters: st.Snapshot(now),
+		History:  s.history.Range(time.Time{}, time.Time{}),
+	}
+	s.mu.Unlock()
+	if sf.Since.IsZero() {
+		sf.Since = st.Start
+	}
+
+	b, err := json.Marshal(&sf)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.opts.StatsFile), ".clidemo-stats")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.opts.StatsFile)
+}
+
+// statusHistoryHandler handles a request for the snapshots of the statistics between the from
+// and to query parameters, as RFC 3339 times or unix seconds.
+func (s *Server) statusHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidMethod(w, r, httpGet) {
+		return
+	}
+	q := r.URL.Query()
+	from, err := parseHistoryTime(q.Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest,
+			APIErrorNew(CodeInvalidField, InvalidHistoryTime, withField("from")))
+		return
+	}
+	to, err := parseHistoryTime(q.Get("to"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest,
+			APIErrorNew(CodeInvalidField, InvalidHistoryTime, withField("to")))
+		return
+	}
+
+	s.mu.Lock()
+	snaps := s.history.Range(from, to)
+	s.mu.Unlock()
+	b, _ := json.Marshal(&struct {
+		Interval  string          `json:"interval"`
+		Snapshots []StatsSnapshot `json:"snapshots"`
+	}{s.opts.StatsInterval.String(), snaps})
+	w.Write(b)
+}
+
+// statusResetHandler handles a request to reset the counters of the statistics. The snapshot of
+// the counters before the reset is returned.
+func (s *Server) statusResetHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidMethod(w, r, httpPost) {
+		return
+	}
+	now := time.Now()
+	st := s.stats.Read(now)
+	s.mu.Lock()
+	s.refreshStats(st)
+	s.mu.Unlock()
+	s.stats.Reset(now)
+	sn := st.Snapshot(now)
+	s.log.Infof("%sStatistics reset.", logPrefix(r.Context()))
+	b, _ := json.Marshal(&sn)
+	w.Write(b)
+}
+
+// parseHistoryTime returns the time of a from or to query parameter. Empty is the zero time.
+func parseHistoryTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/composer22/clidemo/auth"
+	"github.com/composer22/clidemo/logger"
+)
+
+func TestStatsHistory(t *testing.T) {
+	t.Parallel()
+	h := statsHistoryNew(3)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		h.add(StatsSnapshot{Time: start.Add(time.Duration(i) * time.Minute), RequestCount: int64(i)})
+	}
+	if h.Len() != 3 {
+		t.Errorf("History should keep 3 snapshots: %d", h.Len())
+	}
+	snaps := h.Range(time.Time{}, time.Time{})
+	if len(snaps) != 3 || snaps[0].RequestCount != 2 || snaps[2].RequestCount != 4 {
+		t.Errorf("Oldest snapshots should be replaced: %+v", snaps)
+	}
+	snaps = h.Range(start.Add(3*time.Minute), start.Add(3*time.Minute))
+	if len(snaps) != 1 || snaps[0].RequestCount != 3 {
+		t.Errorf("Snapshots not limited to the range: %+v", snaps)
+	}
+	if snaps := statsHistoryNew(0).Range(time.Time{}, time.Time{}); snaps == nil || len(snaps) != 0 {
+		t.Errorf("Empty history should return an empty list: %v", snaps)
+	}
+}
+
+func TestHistorySize(t *testing.T) {
+	t.Parallel()
+	if n := historySize(&Options{StatsInterval: time.Minute}); n != 1440 {
+		t.Errorf("Default history should keep a day of snapshots: %d", n)
+	}
+	if n := historySize(&Options{StatsInterval: time.Minute, StatsHistory: time.Hour}); n != 60 {
+		t.Errorf("History should keep an hour of snapshots: %d", n)
+	}
+	if n := historySize(&Options{}); n != 0 {
+		t.Errorf("History should be off without an interval: %d", n)
+	}
+}
+
+func TestStatusHistoryHandlers(t *testing.T) {
+	t.Parallel()
+	s := &Server{
+		opts:    &Options{StatsInterval: time.Minute},
+		auth:    auth.New(),
+		limits:  tokenLimiterNew(),
+		log:     logger.New(logger.UseDefault, false),
+		stats:   StatusNew(),
+		history: statsHistoryNew(10),
+	}
+	s.log.SetLogLevel(logger.Emergency)
+	now := time.Now()
+	s.stats.IncrRequestStats(100)
+	s.stats.IncrRouteStats(httpRouteParseV1, 100)
+	s.history.add(s.stats.Read(now).Snapshot(now.Add(-2 * time.Minute)))
+	s.stats.IncrRequestStats(50)
+	s.history.add(s.stats.Read(now).Snapshot(now.Add(-time.Minute)))
+
+	w := httptest.NewRecorder()
+	s.statusHistoryHandler(w, httptest.NewRequest(httpGet, httpRouteHistoryV1+"?from="+
+		strconv.FormatInt(now.Add(-90*time.Second).Unix(), 10)+"&to="+now.Format(time.RFC3339), nil))
+	var history struct {
+		Interval  string          `json:"interval"`
+		Snapshots []StatsSnapshot `json:"snapshots"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &history)
+	if history.Interval != "1m0s" || len(history.Snapshots) != 1 || history.Snapshots[0].RequestCount != 2 {
+		t.Errorf("History not returned for the range: %s", w.Body)
+	}
+	w = httptest.NewRecorder()
+	s.statusHistoryHandler(w, httptest.NewRequest(httpGet, httpRouteHistoryV1+"?from=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid time should get a 400: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.statusResetHandler(w, httptest.NewRequest(httpPost, httpRouteResetV1, nil))
+	var before StatsSnapshot
+	json.Unmarshal(w.Body.Bytes(), &before)
+	if before.RequestCount != 2 || before.RequestBytes != 150 || before.RouteStats[httpRouteParseV1]["requestCount"] != 1 {
+		t.Errorf("Counters before the reset not returned: %s", w.Body)
+	}
+	if st := s.stats.Read(time.Now()); st.RequestCount != 0 || st.RequestBytes != 0 || len(st.RouteStats) != 0 ||
+		st.Since.IsZero() {
+		t.Errorf("Counters not reset: %s", st)
+	}
+	if s.history.Len() != 2 {
+		t.Errorf("Reset should keep the history: %d", s.history.Len())
+	}
+	w = httptest.NewRecorder()
+	s.statusResetHandler(w, httptest.NewRequest(httpGet, httpRouteResetV1, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Reset should only accept POST: %d", w.Code)
+	}
+}
+
+func TestStatsFile(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "clidemo-stats")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	opts := &Options{StatsInterval: time.Minute, StatsFile: filepath.Join(dir, "stats.json")}
+	server := func() *Server {
+		return &Server{
+			opts:    opts,
+			limits:  tokenLimiterNew(),
+			stats:   StatusNew(),
+			history: statsHistoryNew(historySize(opts)),
+		}
+	}
+
+	s := server()
+	if err := s.loadStats(); err != nil {
+		t.Errorf("Missing stats file should not be an error: %s", err)
+	}
+	s.stats.IncrRequestStats(10)
+	s.stats.IncrRouteStats(httpRouteParseV1, 10)
+	s.stats.IncrRouteStats("/no/such/route", 10)
+	s.history.add(s.stats.Read(time.Now()).Snapshot(time.Now()))
+	if err := s.saveStats(); err != nil {
+		t.Fatalf("Cannot save stats: %s", err)
+	}
+	if b, _ := ioutil.ReadFile(opts.StatsFile); strings.Contains(string(b), "/no/such/route") {
+		t.Errorf("Snapshots should only hold the routes of the server: %s", b)
+	}
+
+	r := server()
+	if err := r.loadStats(); err != nil {
+		t.Fatalf("Cannot load stats: %s", err)
+	}
+	st := r.stats.Read(time.Now())
+	if st.RequestCount != 1 || st.RequestBytes != 10 || st.RouteStats[httpRouteParseV1]["requestCount"] != 1 ||
+		len(st.RouteStats) != 1 {
+		t.Errorf("Counters not restored: %s", st)
+	}
+	if !st.Since.Equal(s.stats.Start) {
+		t.Errorf("Counters should be since the first start: %s", st.Since)
+	}
+	if r.history.Len() != 1 {
+		t.Errorf("History not restored: %d", r.history.Len())
+	}
+}
@@ -9,8 +9,11 @@ type Info struct {
 	Hostname   string `json:"hostname"`       // The hostname of the server.
 	UUID       string `json:"UUID"`           // Unique ID of the server.
 	Port       int    `json:"port"`           // Port the server is listening on.
+	UnixSocket string `json:"unixSocket"`     // Unix domain socket the server is listening on.
 	ProfPort   int    `json:"profPort"`       // Profiler port the server is listening on.
+	SockPort   int    `json:"sockPort"`       // Socket protocol port the server is listening on.
 	MaxConn    int    `json:"maxConnections"` // The maximum concurrent connections accepted.
+	MinWorkers int    `json:"minWorkers"`     // The number of workers kept running when idle.
 	MaxWorkers int    `json:"maxWorkers"`     // The maximum numer of workers allowed to run.
 	Debug      bool   `json:"debugEnabled"`   // Is debugging enabled on the server.
 }
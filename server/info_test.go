@@ -8,8 +8,8 @@ import (
 
 const (
 	expectedInfoJSONResult = `{"version":"9.8.7","name":"Test Server","hostname":"localhost",` +
-		`"UUID":"ABCDEFGHIJKLMNOPQRSTUVWXYZ","port":8080,"profPort":6060,"maxConnections":9999,` +
-		`"maxWorkers":888,"debugEnabled":true}`
+		`"UUID":"ABCDEFGHIJKLMNOPQRSTUVWXYZ","port":8080,"unixSocket":"/tmp/test.sock","profPort":6060,` +
+		`"sockPort":6061,"maxConnections":9999,"minWorkers":8,"maxWorkers":888,"debugEnabled":true}`
 )
 
 func TestInfoNew(t *testing.T) {
@@ -19,8 +19,11 @@ func TestInfoNew(t *testing.T) {
 		i.Hostname = "localhost"
 		i.UUID = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
 		i.Port = 8080
+		i.UnixSocket = "/tmp/test.sock"
 		i.ProfPort = 6060
+		i.SockPort = 6061
 		i.MaxConn = 9999
+		i.MinWorkers = 8
 		i.MaxWorkers = 888
 		i.Debug = true
 	})
@@ -50,8 +53,11 @@ func TestInfoString(t *testing.T) {
 		i.Hostname = "localhost"
 		i.UUID = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
 		i.Port = 8080
+		i.UnixSocket = "/tmp/test.sock"
 		i.ProfPort = 6060
+		i.SockPort = 6061
 		i.MaxConn = 9999
+		i.MinWorkers = 8
 		i.MaxWorkers = 888
 		i.Debug = true
 	})
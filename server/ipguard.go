@@ -0,0 +1,218 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/composer22/clidemo/ratelimit"
+)
+
+var (
+	ErrInvalidCIDR = errors.New("Invalid IP address or CIDR.")
+)
+
+// How often the guard forgets clients that are idle.
+const ipGuardSweepInterval = time.Minute
+
+// IPGuardStats contains statistics of the connections refused per client address.
+type IPGuardStats struct {
+	Denied      int64 `json:"denied"`      // Connections from addresses not allowed.
+	ConnLimited int64 `json:"connLimited"` // Connections over the limit per address.
+	RateLimited int64 `json:"rateLimited"` // Connections over the rate per address.
+	Banned      int64 `json:"banned"`      // Connections from banned addresses.
+	Bans        int64 `json:"bans"`        // Addresses banned for repeated violations.
+	ActiveBans  int   `json:"activeBans"`  // Addresses banned now.
+	Clients     int   `json:"clients"`     // Addresses being tracked.
+}
+
+// ipClient is the state of one client address.
+type ipClient struct {
+	open        int       // Open connections.
+	violations  int       // Connections refused for limits since first.
+	first       time.Time // Time of the first violation counted.
+	bannedUntil time.Time
+}
+
+// IPGuard limits connections per client address: allow and deny lists, a cap on concurrent
+// connections, a connection rate, and temporary bans for clients that keep going over them.
+type IPGuard struct {
+	MaxConn  int           // Concurrent connections per address (0 = unlimited).
+	BanAfter int           // Violations within BanTime before an address is banned (0 = never).
+	BanTime  time.Duration // How long a ban lasts.
+
+	allow []*net.IPNet       // Only these addresses may connect if set.
+	deny  []*net.IPNet       // These addresses may never connect.
+	rate  *ratelimit.Limiter // Connection rate per address.
+
+	mu        sync.Mutex
+	clients   map[string]*ipClient
+	stats     IPGuardStats
+	lastSweep time.Time
+}
+
+// IPGuardNew is a factory function that returns a new guard from the server options, or nil if
+// no per address limits are configured.
+func IPGuardNew(opts *Options) (*IPGuard, error) {
+	allow, err := parseCIDRs(opts.AllowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseCIDRs(opts.DenyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	if len(allow) == 0 && len(deny) == 0 && opts.MaxConnPerIP <= 0 && opts.ConnRatePerIP <= 0 {
+		return nil, nil
+	}
+	g := &IPGuard{
+		MaxConn:   opts.MaxConnPerIP,
+		BanAfter:  opts.BanAfter,
+		BanTime:   opts.BanTime,
+		allow:     allow,
+		deny:      deny,
+		clients:   make(map[string]*ipClient),
+		lastSweep: time.Now(),
+	}
+	if opts.ConnRatePerIP > 0 {
+		g.rate = ratelimit.New(opts.ConnRatePerIP, opts.ConnBurstPerIP)
+	}
+	return g, nil
+}
+
+// parseCIDRs parses a list of CIDRs. Plain addresses are taken as a single host.
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, ErrInvalidCIDR
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, ErrInvalidCIDR
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Admit decides whether a connection from addr may be served. If so, release must be called
+// when the connection closes.
+func (g *IPGuard) Admit(addr net.Addr) (release func(), ok bool) {
+	tcp, isTCP := addr.(*net.TCPAddr)
+	if !isTCP {
+		return func() {}, true // Unix sockets and such have no client address to limit.
+	}
+	ip, key := tcp.IP, tcp.IP.String()
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sweep(now)
+	if matchCIDR(g.deny, ip) || (len(g.allow) > 0 && !matchCIDR(g.allow, ip)) {
+		g.stats.Denied++
+		return nil, false
+	}
+	c, found := g.clients[key]
+	if !found {
+		c = &ipClient{}
+		g.clients[key] = c
+	}
+	if now.Before(c.bannedUntil) {
+		g.stats.Banned++
+		return nil, false
+	}
+	switch {
+	case g.MaxConn > 0 && c.open >= g.MaxConn:
+		g.stats.ConnLimited++
+		g.violation(c, now)
+		return nil, false
+	case g.rate != nil && !g.rate.AllowAt(key, now):
+		g.stats.RateLimited++
+		g.violation(c, now)
+		return nil, false
+	}
+
+	c.open++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			c.open--
+			g.mu.Unlock()
+		})
+	}, true
+}
+
+// violation records a client going over a limit, banning it after too many. The lock must
+// be held.
+func (g *IPGuard) violation(c *ipClient, now time.Time) {
+	if g.BanAfter <= 0 {
+		return
+	}
+	if c.violations == 0 || now.Sub(c.first) > g.BanTime {
+		c.violations, c.first = 0, now
+	}
+	c.violations++
+	if c.violations >= g.BanAfter {
+		c.violations = 0
+		c.bannedUntil = now.Add(g.BanTime)
+		g.stats.Bans++
+	}
+}
+
+// sweep forgets idle clients once in a while. The lock must be held.
+func (g *IPGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < ipGuardSweepInterval {
+		return
+	}
+	g.lastSweep = now
+	for k, c := range g.clients {
+		if c.open == 0 && now.After(c.bannedUntil) && now.Sub(c.first) > g.BanTime {
+			delete(g.clients, k)
+		}
+	}
+	if g.rate != nil {
+		g.rate.Prune(now)
+	}
+}
+
+// Stats returns a snapshot of the guard statistics.
+func (g *IPGuard) Stats() IPGuardStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st := g.stats
+	now := time.Now()
+	for _, c := range g.clients {
+		if now.Before(c.bannedUntil) {
+			st.ActiveBans++
+		}
+	}
+	st.Clients = len(g.clients)
+	return st
+}
+
+// matchCIDR returns true if ip is in one of the networks.
+func matchCIDR(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
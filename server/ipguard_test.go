@@ -0,0 +1,156 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIPGuardNew(t *testing.T) {
+	t.Parallel()
+	if g, err := IPGuardNew(&Options{}); g != nil || err != nil {
+		t.Errorf("Guard should not be created without limits.")
+	}
+	if _, err := IPGuardNew(&Options{DenyCIDRs: []string{"not an address"}}); err != ErrInvalidCIDR {
+		t.Errorf("Invalid CIDR should not be accepted.")
+	}
+	g, err := IPGuardNew(&Options{AllowCIDRs: []string{"10.0.0.0/8", " 192.168.1.1", "::1"}})
+	if err != nil || len(g.allow) != 3 {
+		t.Fatalf("Allow list not parsed: %v", err)
+	}
+	if !matchCIDR(g.allow, net.ParseIP("10.1.2.3")) || !matchCIDR(g.allow, net.ParseIP("192.168.1.1")) ||
+		!matchCIDR(g.allow, net.ParseIP("::1")) || matchCIDR(g.allow, net.ParseIP("192.168.1.2")) {
+		t.Errorf("Allow list does not match correctly.")
+	}
+}
+
+func TestIPGuardAllowDeny(t *testing.T) {
+	t.Parallel()
+	g, _ := IPGuardNew(&Options{
+		AllowCIDRs: []string{"10.0.0.0/8"},
+		DenyCIDRs:  []string{"10.0.0.13"},
+	})
+	if _, ok := g.Admit(tcpAddr("10.0.0.1")); !ok {
+		t.Errorf("Allowed address refused.")
+	}
+	if _, ok := g.Admit(tcpAddr("10.0.0.13")); ok {
+		t.Errorf("Denied address admitted.")
+	}
+	if _, ok := g.Admit(tcpAddr("172.16.0.1")); ok {
+		t.Errorf("Address not allowed admitted.")
+	}
+	if _, ok := g.Admit(&net.UnixAddr{Name: "/tmp/test.sock", Net: "unix"}); !ok {
+		t.Errorf("Unix socket connection refused.")
+	}
+	if st := g.Stats(); st.Denied != 2 {
+		t.Errorf("Denied connections not counted: %+v", st)
+	}
+}
+
+func TestIPGuardLimits(t *testing.T) {
+	t.Parallel()
+	g, _ := IPGuardNew(&Options{
+		MaxConnPerIP: 2,
+		BanAfter:     2,
+		BanTime:      time.Hour,
+	})
+	r1, ok1 := g.Admit(tcpAddr("10.0.0.1"))
+	_, ok2 := g.Admit(tcpAddr("10.0.0.1"))
+	if !ok1 || !ok2 {
+		t.Fatalf("Connections under the limit refused.")
+	}
+	if _, ok := g.Admit(tcpAddr("10.0.0.1")); ok {
+		t.Errorf("Connection over the limit admitted.")
+	}
+	if _, ok := g.Admit(tcpAddr("10.0.0.2")); !ok {
+		t.Errorf("Limit should be per address.")
+	}
+	r1()
+	r1() // Released only once.
+	if _, ok := g.Admit(tcpAddr("10.0.0.1")); !ok {
+		t.Errorf("Released connection should free a place.")
+	}
+
+	// A second violation bans the address, even once it has free places.
+	if _, ok := g.Admit(tcpAddr("10.0.0.1")); ok {
+		t.Errorf("Connection over the limit admitted.")
+	}
+	st := g.Stats()
+	if st.ConnLimited != 2 || st.Bans != 1 || st.ActiveBans != 1 || st.Clients != 2 {
+		t.Errorf("Limited connections not counted: %+v", st)
+	}
+	g.mu.Lock()
+	g.clients["10.0.0.1"].open = 0
+	g.mu.Unlock()
+	if _, ok := g.Admit(tcpAddr("10.0.0.1")); ok {
+		t.Errorf("Banned address admitted.")
+	}
+	if st := g.Stats(); st.Banned != 1 {
+		t.Errorf("Banned connection not counted: %+v", st)
+	}
+}
+
+func TestIPGuardRate(t *testing.T) {
+	t.Parallel()
+	g, _ := IPGuardNew(&Options{ConnRatePerIP: 0.001, ConnBurstPerIP: 2})
+	for i := 0; i < 2; i++ {
+		if _, ok := g.Admit(tcpAddr("10.0.0.1")); !ok {
+			t.Errorf("Connection within the burst refused.")
+		}
+	}
+	if _, ok := g.Admit(tcpAddr("10.0.0.1")); ok {
+		t.Errorf("Connection over the rate admitted.")
+	}
+	if st := g.Stats(); st.RateLimited != 1 || st.Bans != 0 {
+		t.Errorf("Rate limited connection not counted: %+v", st)
+	}
+}
+
+func TestThrottleListenerGuard(t *testing.T) {
+	t.Parallel()
+	g, _ := IPGuardNew(&Options{MaxConnPerIP: 1})
+	tl, err := ThrottledListenerNew("127.0.0.1:0", 0)
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	tl.Guard = g
+	defer tl.Stop()
+
+	first, _ := net.Dial("tcp", tl.Addr().String())
+	defer first.Close()
+	conn, err := tl.Accept()
+	if err != nil {
+		t.Fatalf("Could not accept first connection: %s", err)
+	}
+
+	// Accept refuses the second connection, then returns the third once the first has closed.
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if c, err := tl.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+	second, _ := net.Dial("tcp", tl.Addr().String())
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Connection over the limit should have been closed.")
+	}
+	conn.Close()
+	third, _ := net.Dial("tcp", tl.Addr().String())
+	defer third.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(2 * time.Second):
+		t.Errorf("Connection under the limit not accepted.")
+	}
+	if st := g.Stats(); st.ConnLimited != 1 {
+		t.Errorf("Limited connection not counted: %+v", st)
+	}
+}
+
+// tcpAddr returns the TCP address of a client.
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}
+}
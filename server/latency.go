@@ -0,0 +1,252 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Latency statistics. Each route keeps histograms of the time taken to answer, the time parse
+// jobs waited for a worker and the size of the responses, since the server started and in slots
+// over the last 15 minutes, from which percentiles of the last 1, 5 and 15 minutes are estimated.
+const (
+	latencySlot      = 15 * time.Second                    // Width of a window slot.
+	latencySlots     = int(15 * time.Minute / latencySlot) // Slots kept for the longest window.
+	bucketsPerOctave = 4                                   // Estimates are within 19% of a value.
+	zeroBucket       = math.MinInt32                       // Bucket of values of zero or less.
+)
+
+// Percentiles are estimated from the observations in a histogram.
+type Percentiles struct {
+	Count int64   `json:"count"` // Number of observations.
+	P50   float64 `json:"p50"`   // Median.
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"` // The largest observation, exactly.
+}
+
+// PercentileWindows are the percentiles since the server started and over the last minutes.
+type PercentileWindows struct {
+	Total   Percentiles `json:"total"`
+	Last1m  Percentiles `json:"last1m"`
+	Last5m  Percentiles `json:"last5m"`
+	Last15m Percentiles `json:"last15m"`
+}
+
+// RouteLatency contains the response statistics of a route.
+type RouteLatency struct {
+	StatusCodes   map[string]int64  `json:"statusCodes,omitempty"` // Responses by status code.
+	ResponseBytes int64             `json:"responseBytes"`         // Size of the responses in bytes.
+	DurationMs    PercentileWindows `json:"durationMs"`            // Time to answer the requests.
+	QueueWaitMs   PercentileWindows `json:"queueWaitMs"`           // Time parse jobs waited for a worker.
+	ResponseSize  PercentileWindows `json:"responseSize"`          // Size of each response in bytes.
+}
+
+// histogram counts observations in buckets that grow exponentially, so that percentiles can be
+// estimated over any range of values. Only the buckets holding observations are kept.
+type histogram struct {
+	buckets map[int]int64
+	count   int64
+	max     float64
+}
+
+// bucketOf returns the bucket of a value.
+func bucketOf(v float64) int {
+	if v <= 0 {
+		return zeroBucket
+	}
+	return int(math.Ceil(math.Log2(v) * bucketsPerOctave))
+}
+
+// bucketUpper returns the upper bound of a bucket.
+func bucketUpper(b int) float64 {
+	if b == zeroBucket {
+		return 0
+	}
+	return math.Exp2(float64(b) / bucketsPerOctave)
+}
+
+// observe adds an observation to the histogram.
+func (h *histogram) observe(v float64) {
+	if h.buckets == nil {
+		h.buckets = make(map[int]int64)
+	}
+	h.buckets[bucketOf(v)]++
+	h.count++
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// merge adds the observations of another histogram to the histogram.
+func (h *histogram) merge(o *histogram) {
+	if o.count == 0 {
+		return
+	}
+	if h.buckets == nil {
+		h.buckets = make(map[int]int64, len(o.buckets))
+	}
+	for b, n := range o.buckets {
+		h.buckets[b] += n
+	}
+	h.count += o.count
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+// percentiles returns the estimated percentiles of the observations. Each is the upper bound of
+// the bucket it falls in, but never more than the largest observation.
+func (h *histogram) percentiles() Percentiles {
+	if h.count == 0 {
+		return Percentiles{}
+	}
+	keys := make([]int, 0, len(h.buckets))
+	for b := range h.buckets {
+		keys = append(keys, b)
+	}
+	sort.Ints(keys)
+	quantile := func(q float64) float64 {
+		rank := int64(math.Ceil(q * float64(h.count)))
+		var cum int64
+		for _, b := range keys {
+			cum += h.buckets[b]
+			if cum >= rank {
+				return roundStat(math.Min(bucketUpper(b), h.max))
+			}
+		}
+		return roundStat(h.max)
+	}
+	return Percentiles{
+		Count: h.count,
+		P50:   quantile(.5),
+		P90:   quantile(.9),
+		P99:   quantile(.99),
+		Max:   roundStat(h.max),
+	}
+}
+
+// roundStat rounds a statistic to three decimal places.
+func roundStat(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// windowedHistogram is a histogram since the server started, and of each slot of the windows.
+type windowedHistogram struct {
+	total histogram
+	slots [latencySlots]struct {
+		epoch int64 // Slots since the unix epoch when the slot was last used.
+		h     histogram
+	}
+}
+
+// slotOf returns the slot number of a time.
+func slotOf(t time.Time) int64 {
+	return t.UnixNano() / int64(latencySlot)
+}
+
+// observe adds an observation at time now.
+func (w *windowedHistogram) observe(v float64, now time.Time) {
+	w.total.observe(v)
+	e := slotOf(now)
+	sl := &w.slots[e%int64(latencySlots)]
+	if sl.epoch != e {
+		sl.epoch = e
+		sl.h = histogram{}
+	}
+	sl.h.observe(v)
+}
+
+// window returns the observations of the slots in the last d before now, including the current
+// slot.
+func (w *windowedHistogram) window(d time.Duration, now time.Time) *histogram {
+	e, n := slotOf(now), int64(d/latencySlot)
+	h := &histogram{}
+	for i := range w.slots {
+		if sl := &w.slots[i]; sl.epoch > e-n && sl.epoch <= e {
+			h.merge(&sl.h)
+		}
+	}
+	return h
+}
+
+// merge adds the observations of another windowed histogram, such as that of another shard.
+func (w *windowedHistogram) merge(o *windowedHistogram) {
+	w.total.merge(&o.total)
+	for i := range o.slots {
+		sl, osl := &w.slots[i], &o.slots[i]
+		switch {
+		case osl.epoch > sl.epoch:
+			sl.epoch = osl.epoch
+			sl.h = histogram{}
+			sl.h.merge(&osl.h)
+		case osl.epoch == sl.epoch:
+			sl.h.merge(&osl.h)
+		}
+	}
+}
+
+// summary returns the percentiles since the server started and over the windows at time now.
+func (w *windowedHistogram) summary(now time.Time) PercentileWindows {
+	return PercentileWindows{
+		Total:   w.total.percentiles(),
+		Last1m:  w.window(time.Minute, now).percentiles(),
+		Last5m:  w.window(5*time.Minute, now).percentiles(),
+		Last15m: w.window(15*time.Minute, now).percentiles(),
+	}
+}
+
+// routeLatency holds the response statistics of a route as they are recorded.
+type routeLatency struct {
+	codes         map[string]int64
+	responseBytes int64
+	duration      windowedHistogram // In milliseconds.
+	queueWait     windowedHistogram // In milliseconds.
+	responseSize  windowedHistogram
+}
+
+// merge adds the statistics of another shard of the route.
+func (rl *routeLatency) merge(o *routeLatency) {
+	for c, n := range o.codes {
+		if rl.codes == nil {
+			rl.codes = make(map[string]int64, len(o.codes))
+		}
+		rl.codes[c] += n
+	}
+	rl.responseBytes += o.responseBytes
+	rl.duration.merge(&o.duration)
+	rl.queueWait.merge(&o.queueWait)
+	rl.responseSize.merge(&o.responseSize)
+}
+
+// summary returns the statistics of the route at time now.
+func (rl *routeLatency) summary(now time.Time) RouteLatency {
+	codes := make(map[string]int64, len(rl.codes))
+	for c, n := range rl.codes {
+		codes[c] = n
+	}
+	return RouteLatency{
+		StatusCodes:   codes,
+		ResponseBytes: rl.responseBytes,
+		DurationMs:    rl.duration.summary(now),
+		QueueWaitMs:   rl.queueWait.summary(now),
+		ResponseSize:  rl.responseSize.summary(now),
+	}
+}
+
+// observeResponse records a response with status code of wb bytes that took d to answer. A
+// code of zero, as for socket frames, is not counted.
+func (rl *routeLatency) observeResponse(code int, wb int64, d time.Duration, now time.Time) {
+	if code != 0 {
+		if rl.codes == nil {
+			rl.codes = make(map[string]int64)
+		}
+		rl.codes[strconv.Itoa(code)]++
+	}
+	if wb > 0 {
+		rl.responseBytes += wb
+	}
+	rl.duration.observe(durationMs(d), now)
+	rl.responseSize.observe(float64(wb), now)
+}
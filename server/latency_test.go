@@ -0,0 +1,72 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentiles(t *testing.T) {
+	t.Parallel()
+	h := &histogram{}
+	if p := h.percentiles(); p != (Percentiles{}) {
+		t.Errorf("Empty histogram should have no percentiles: %+v", p)
+	}
+	for i := 1; i <= 100; i++ {
+		h.observe(float64(i))
+	}
+	p := h.percentiles()
+	if p.Count != 100 || p.Max != 100 {
+		t.Errorf("Count or max incorrect: %+v", p)
+	}
+	// Estimates are the upper bound of a bucket, within a quarter octave of the value.
+	for _, c := range []struct {
+		name string
+		got  float64
+		want float64
+	}{{"p50", p.P50, 50}, {"p90", p.P90, 90}, {"p99", p.P99, 99}} {
+		if c.got < c.want || c.got > c.want*1.19 {
+			t.Errorf("%s estimate %v too far from %v", c.name, c.got, c.want)
+		}
+	}
+	if p.P99 > p.Max {
+		t.Errorf("Estimates should not exceed the largest observation: %+v", p)
+	}
+
+	z := &histogram{}
+	z.observe(0)
+	if p := z.percentiles(); p.Count != 1 || p.P50 != 0 || p.Max != 0 {
+		t.Errorf("Zero observations incorrect: %+v", p)
+	}
+}
+
+func TestWindowedHistogram(t *testing.T) {
+	t.Parallel()
+	w := &windowedHistogram{}
+	now := time.Now()
+	w.observe(1000, now.Add(-10*time.Minute))
+	w.observe(100, now.Add(-3*time.Minute))
+	w.observe(10, now.Add(-time.Second))
+	w.observe(20, now)
+
+	s := w.summary(now)
+	if s.Total.Count != 4 || s.Total.Max != 1000 {
+		t.Errorf("Total incorrect: %+v", s.Total)
+	}
+	if s.Last1m.Count != 2 || s.Last1m.Max != 20 {
+		t.Errorf("Last minute incorrect: %+v", s.Last1m)
+	}
+	if s.Last5m.Count != 3 || s.Last5m.Max != 100 {
+		t.Errorf("Last 5 minutes incorrect: %+v", s.Last5m)
+	}
+	if s.Last15m.Count != 4 {
+		t.Errorf("Last 15 minutes incorrect: %+v", s.Last15m)
+	}
+
+	// Old slots are reused once they fall out of the longest window.
+	later := now.Add(20 * time.Minute)
+	w.observe(5, later)
+	s = w.summary(later)
+	if s.Total.Count != 5 || s.Last15m.Count != 1 || s.Last15m.Max != 5 {
+		t.Errorf("Expired slots should not be counted: %+v", s)
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/composer22/clidemo/metrics"
+)
+
+// serverMetrics are the metrics of a server exposed on the metrics route.
+type serverMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.CounterVec   // By route, method and status code.
+	requestDuration *metrics.HistogramVec // By route.
+	requestBytes    *metrics.CounterVec   // By route.
+	responseBytes   *metrics.CounterVec   // By route.
+	parseDuration   *metrics.Histogram    // Time workers spend parsing.
+
+	mu    sync.Mutex  // Scrapes are written one at a time, as they share the state below.
+	state serverState // State of the server read by the gauges.
+}
+
+// serverState is the state of the server read by the gauges. It is taken when a scrape begins,
+// so the gauges only read it and the server lock is not held while the metrics are written.
+type serverState struct {
+	workers       WorkerStats
+	queueDepth    int
+	queueCapacity int
+	connAvail     int // -1 is unlimited.
+	connRejected  int64
+	mem           runtime.MemStats
+}
+
+// serverMetricsNew is a factory function that returns the metrics of a server.
+func serverMetricsNew() *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		requests: r.NewCounterVec("clidemo_http_requests_total",
+			"HTTP requests by route, method and status code.", "route", "method", "code"),
+		requestDuration: r.NewHistogramVec("clidemo_http_request_duration_seconds",
+			"Time to answer HTTP requests by route.", nil, "route"),
+		requestBytes: r.NewCounterVec("clidemo_http_request_bytes_total",
+			"Bytes of HTTP request bodies by route.", "route"),
+		responseBytes: r.NewCounterVec("clidemo_http_response_bytes_total",
+			"Bytes of HTTP response bodies by route.", "route"),
+		parseDuration: r.NewHistogram("clidemo_parse_duration_seconds",
+			"Time workers spend parsing a job.", nil),
+	}
+
+	r.NewGaugeFunc("clidemo_workers_active", "Parse workers running.", func() float64 {
+		return float64(m.state.workers.Active)
+	})
+	r.NewGaugeFunc("clidemo_workers_busy", "Parse workers parsing a job.", func() float64 {
+		return float64(m.state.workers.Busy)
+	})
+	r.NewGaugeFunc("clidemo_workers_idle", "Parse workers waiting for a job.", func() float64 {
+		return float64(m.state.workers.Idle)
+	})
+	r.NewGaugeFunc("clidemo_queue_depth", "Parse jobs waiting for a worker.", func() float64 {
+		return float64(m.state.queueDepth)
+	})
+	r.NewGaugeFunc("clidemo_queue_capacity", "Parse jobs allowed to wait for a worker.", func() float64 {
+		return float64(m.state.queueCapacity)
+	})
+	r.NewGaugeFunc("clidemo_listener_tokens_available",
+		"Connections the listener may still accept (-1 is unlimited).", func() float64 {
+			return float64(m.state.connAvail)
+		})
+	r.NewCounterFunc("clidemo_listener_rejected_total", "Connections rejected at the limit.", func() float64 {
+		return float64(m.state.connRejected)
+	})
+
+	r.NewGaugeFunc("go_goroutines", "Goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	r.NewGaugeFunc("go_memstats_alloc_bytes", "Bytes allocated and still in use.", func() float64 {
+		return float64(m.state.mem.Alloc)
+	})
+	r.NewGaugeFunc("go_memstats_sys_bytes", "Bytes obtained from the system.", func() float64 {
+		return float64(m.state.mem.Sys)
+	})
+	r.NewGaugeFunc("go_memstats_heap_inuse_bytes", "Bytes in in-use heap spans.", func() float64 {
+		return float64(m.state.mem.HeapInuse)
+	})
+	r.NewGaugeFunc("go_memstats_heap_objects", "Allocated heap objects.", func() float64 {
+		return float64(m.state.mem.HeapObjects)
+	})
+	r.NewCounterFunc("go_memstats_mallocs_total", "Heap objects allocated.", func() float64 {
+		return float64(m.state.mem.Mallocs)
+	})
+	r.NewCounterFunc("go_gc_cycles_total", "Completed garbage collection cycles.", func() float64 {
+		return float64(m.state.mem.NumGC)
+	})
+	r.NewCounterFunc("go_gc_pause_seconds_total", "Time the world was stopped for garbage collection.",
+		func() float64 {
+			return float64(m.state.mem.PauseTotalNs) / float64(time.Second)
+		})
+	return m
+}
+
+// write writes the metrics in the Prometheus text format for a server in state st.
+func (m *serverMetrics) write(w http.ResponseWriter, st serverState) {
+	var b bytes.Buffer
+	m.mu.Lock()
+	m.state = st
+	m.registry.WriteText(&b)
+	m.mu.Unlock()
+	w.Write(b.Bytes())
+}
+
+// observeRequest records an answered HTTP request.
+func (m *serverMetrics) observeRequest(r *http.Request, w *metricsWriter, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	route := metricsRoute(r.URL.Path)
+	m.requests.With(route, r.Method, strconv.Itoa(w.Status())).Inc()
+	m.requestDuration.With(route).Observe(elapsed.Seconds())
+	if r.ContentLength > 0 {
+		m.requestBytes.With(route).Add(float64(r.ContentLength))
+	}
+	m.responseBytes.With(route).Add(float64(w.bytes))
+}
+
+// observeParse records the time a worker spent parsing a job.
+func (m *serverMetrics) observeParse(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.parseDuration.Observe(d.Seconds())
+}
+
+// metricsRoute returns the route label of a request path. Unknown paths share one label so
+// that clients cannot create series at will.
+func metricsRoute(path string) string {
+	switch path {
+	case httpRouteAliveV1, httpRouteParseV1, httpRouteStatusV1, httpRouteHistoryV1, httpRouteResetV1,
+		httpRouteStreamV1, httpRouteEventsV1, httpRouteAdminTokensV1, httpRouteMetrics, httpRouteHealthz,
+		httpRouteReadyz, httpRouteStartupz:
+		return path
+	}
+	if strings.HasPrefix(path, httpRouteAdminTokensV1+"/") {
+		return httpRouteAdminTokensV1 + "/{id}"
+	}
+	return "other"
+}
+
+// workerStats returns the statistics of the worker pool, if the server is running.
+func (s *Server) workerStats() WorkerStats {
+	if s.pool == nil {
+		return WorkerStats{}
+	}
+	return s.pool.Stats()
+}
+
+// metricsHandler handles a request for the server metrics in the Prometheus text format.
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidMethod(w, r, httpGet) {
+		return
+	}
+	w.Header().Set("Content-Type", metrics.ContentType)
+	s.mu.Lock()
+	st := s.metricsState()
+	s.mu.Unlock()
+	runtime.ReadMemStats(&st.mem) // Stops the world, so read unlocked.
+	s.metrics.write(w, st)
+}
+
+// metricsState returns the state of the server read by the gauges. The lock must be held.
+func (s *Server) metricsState() serverState {
+	st := serverState{workers: s.workerStats(), connAvail: -1}
+	if s.jobq != nil {
+		st.queueDepth = s.jobq.Len()
+		st.queueCapacity = s.jobq.Stats().Capacity
+	}
+	if s.listener != nil {
+		st.connAvail = s.listener.GetConnNumAvail()
+		st.connRejected = s.listener.GetConnRejected()
+	}
+	return st
+}
+
+// metricsWriter records the status code and size of a response for the metrics.
+type metricsWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader is an implementation of the http.ResponseWriter interface.
+func (w *metricsWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write is an implementation of the http.ResponseWriter interface.
+func (w *metricsWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the status code of the response.
+func (w *metricsWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Flush is an implementation of the http.Flusher interface, for event streams.
+func (w *metricsWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is an implementation of the http.Hijacker interface, for websockets.
+func (w *metricsWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *metricsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
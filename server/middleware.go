@@ -1,6 +1,11 @@
 package server
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+
+	"github.com/composer22/clidemo/tracing"
+)
 
 // Middleware is used to perform filtering work on the request before the main controllers are
 // called.
@@ -11,14 +16,41 @@ type Middleware struct {
 
 // ServeHTTP implements the interface to accept requests so they can be filtered before handling
 // by the server.
-func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	m.serv.LogRequest(r)
-	m.serv.incrementStats(r)
-	m.serv.initResponseHeader(w)
-	if m.serv.invalidHeader(w, r) {
+func (m *Middleware) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	w := &metricsWriter{ResponseWriter: rw}
+	logged := false // Health probes are not logged.
+	var body *bodyCapture
+	defer func() {
+		elapsed := time.Since(start)
+		if logged {
+			m.serv.access.record(r, w.Status(), w.bytes, elapsed, body)
+		}
+		m.serv.metrics.observeRequest(r, w, elapsed)
+		m.serv.incrementResponseStats(metricsRoute(r.URL.Path), w.Status(), w.bytes, elapsed)
+		endRequestSpan(r, w.Status())
+	}()
+	r = m.serv.withRequestID(r)
+	if healthRoute(r.URL.Path) { // Probes need no authorization, and are frequent.
+		m.serv.incrementStats(r)
+		m.serv.initResponseHeader(w, r)
+		m.handler.ServeHTTP(w, r)
 		return
 	}
-	if m.serv.invalidAuth(w, r) {
+	_, span := tracing.Start(r.Context(), "auth")
+	r, err := m.serv.withIdentity(w, r)
+	span.SetError(err)
+	span.End()
+	logged = true
+	r, body = m.serv.access.capture(r)
+	m.serv.incrementStats(r)
+	m.serv.initResponseHeader(w, r)
+	_, span = tracing.Start(r.Context(), "check")
+	refused := m.serv.invalidBody(w, err) || m.serv.invalidHeader(w, r) || m.serv.invalidAuth(w, r) ||
+		m.serv.invalidScope(w, r, routeScope(r.URL.Path)) || m.serv.overLimit(w, r)
+	span.SetAttribute("refused", refused)
+	span.End()
+	if refused {
 		return
 	}
 	m.handler.ServeHTTP(w, r)
@@ -1,8 +1,46 @@
 package server
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/composer22/clidemo/auth"
+	"github.com/composer22/clidemo/logger"
+)
 
 func TestMiddlewareServeHTTP(t *testing.T) {
 	t.Parallel()
 	t.Skip("Covered by server test.")
 }
+
+func TestMiddlewareRouteStats(t *testing.T) {
+	t.Parallel()
+	s := &Server{
+		info:   &Info{},
+		opts:   &Options{},
+		auth:   auth.New(),
+		limits: tokenLimiterNew(),
+		log:    logger.New(logger.UseDefault, false),
+		stats:  StatusNew(),
+	}
+	s.log.SetLogLevel(logger.Emergency)
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpRouteAliveV1, s.aliveHandler)
+	mux.HandleFunc("/", s.notFoundHandler)
+	m := &Middleware{serv: s, handler: mux}
+	for _, path := range []string{"/junk/1", "/junk/2", "/v1.0/alive/x", httpRouteAliveV1} {
+		r := httptest.NewRequest(httpGet, path, nil)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	st := s.stats.Read(time.Now())
+	if len(st.RouteStats) != 2 || st.RouteStats["other"]["requestCount"] != 3 ||
+		st.RouteStats[httpRouteAliveV1]["requestCount"] != 1 {
+		t.Errorf("Unknown paths should be counted as other: %v", st.RouteStats)
+	}
+	if len(st.Latency) != 2 {
+		t.Errorf("Unknown paths should share the latency of other: %v", st.Latency)
+	}
+}
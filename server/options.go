@@ -1,6 +1,9 @@
 package server
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Options represents parameters that are passed to the application to be used in constructing
 // the run and the server (if server mode is indicated).
@@ -9,10 +12,63 @@ type Options struct {
 	Hostname   string `json:"hostname"`       // The hostname of the server.
 	Port       int    `json:"port"`           // The default port of the server.
 	ProfPort   int    `json:"profPort"`       // The profiler port of the server.
+	SockPort   int    `json:"sockPort"`       // The socket protocol port of the server.
 	MaxConn    int    `json:"maxConnections"` // The maximum concurrent connections accepted.
+	MinWorkers int    `json:"minWorkers"`     // The number of workers kept running when idle.
 	MaxWorkers int    `json:"maxWorkers"`     // The maximum numer of workers allowed to run.
 	MaxProcs   int    `json:"maxProcs"`       // The maximum number of processor cores available.
 	Debug      bool   `json:"debugEnabled"`   // Is debugging enabled in the application or server.
+
+	UnixSocket      string `json:"unixSocket"`      // Path of a Unix domain socket to listen on instead of TCP.
+	UnixSocketMode  string `json:"unixSocketMode"`  // Octal file mode of the Unix domain socket.
+	UnixSocketOwner string `json:"unixSocketOwner"` // user[:group] owner of the Unix domain socket.
+	Systemd         bool   `json:"systemd"`         // Listen on the socket passed in by systemd.
+
+	MaxParseTime   time.Duration `json:"maxParseTime"`   // The maximum time a parse request may take.
+	QueueDepth     int           `json:"queueDepth"`     // The maximum number of jobs waiting for a worker.
+	QueueTimeout   time.Duration `json:"queueTimeout"`   // The maximum time a job may wait for a worker.
+	PriorityBytes  int           `json:"priorityBytes"`  // Documents up to this size jump the queue.
+	StreamMaxBytes int64         `json:"streamMaxBytes"` // The maximum text sent over a websocket stream.
+
+	WorkerIdleTimeout time.Duration `json:"workerIdleTimeout"` // How long a spare worker waits for a job.
+	WorkerGrowWait    time.Duration `json:"workerGrowWait"`    // How long a job waits before a worker is added.
+
+	ConnOverflow    string        `json:"connOverflow"`    // block, wait or reject connections over the maximum.
+	ConnWaitTimeout time.Duration `json:"connWaitTimeout"` // How long a connection over the maximum may wait.
+
+	MaxConnPerIP   int           `json:"maxConnPerIP"`   // The maximum concurrent connections per client address.
+	ConnRatePerIP  float64       `json:"connRatePerIP"`  // The connections per second allowed per client address.
+	ConnBurstPerIP int           `json:"connBurstPerIP"` // The burst of connections allowed per client address.
+	AllowCIDRs     []string      `json:"allowCIDRs"`     // Only these client addresses may connect if set.
+	DenyCIDRs      []string      `json:"denyCIDRs"`      // These client addresses may never connect.
+	BanAfter       int           `json:"banAfter"`       // Limit violations before a client address is banned.
+	BanTime        time.Duration `json:"banTime"`        // How long a client address is banned.
+
+	TokenStore string `json:"tokenStore"` // Path of the file of auth tokens managed by the admin API.
+
+	JWTKeys       string `json:"jwtKeys"`       // Path of the PEM or JWKS file of the JWT issuer keys.
+	JWTSecretFile string `json:"jwtSecretFile"` // Path of the file of the JWT HS256 shared secret.
+	JWTIssuer     string `json:"jwtIssuer"`     // Required iss claim of JWTs.
+	JWTAudience   string `json:"jwtAudience"`   // Required aud claim of JWTs.
+
+	HMACKeys    string        `json:"hmacKeys"`    // Path of the json file of keys for signed requests.
+	HMACSkew    time.Duration `json:"hmacSkew"`    // Clock difference allowed for signed requests.
+	HMACMaxBody int64         `json:"hmacMaxBody"` // Largest body of a signed request.
+
+	StatsInterval time.Duration `json:"statsInterval"` // How often a snapshot of the statistics is kept.
+	StatsHistory  time.Duration `json:"statsHistory"`  // How long the snapshots are kept.
+	StatsFile     string        `json:"statsFile"`     // Path of the file that keeps the statistics across restarts.
+
+	TraceEndpoint string  `json:"traceEndpoint"` // OTLP/HTTP collector the spans are sent to.
+	TraceFile     string  `json:"traceFile"`     // Path of a file the spans are written to instead.
+	TraceSample   float64 `json:"traceSample"`   // Ratio of the traces started here that are sampled.
+
+	AccessLog        string   `json:"accessLog"`        // Path of the access log file (empty = the server log).
+	AccessLogFormat  string   `json:"accessLogFormat"`  // common, combined, json or off.
+	AccessLogHeaders []string `json:"accessLogHeaders"` // Headers logged in the json format.
+	AccessLogRedact  []string `json:"accessLogRedact"`  // Headers logged as [REDACTED], besides the defaults.
+	AccessLogBody    int      `json:"accessLogBody"`    // Bytes of request bodies logged in the json format.
+	AccessLogSample  float64  `json:"accessLogSample"`  // Ratio of the successful requests logged.
 }
 
 // String is an implentation of the Stringer interface so the structure is returned as a string
@@ -3,12 +3,17 @@ package server
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 const (
 	expectedOptionsJSONResult = `{"name":"Test Options","hostname":"localhost","port":8080,` +
-		`"profPort":6060,"maxConnections":1001,"maxWorkers":999,"maxProcs":888,` +
-		`"debugEnabled":true}`
+		`"profPort":6060,"sockPort":6061,"maxConnections":1001,"minWorkers":9,"maxWorkers":999,"maxProcs":888,` +
+		`"debugEnabled":true,"unixSocket":"/tmp/test.sock","unixSocketMode":"0600",` +
+		`"unixSocketOwner":"nobody","systemd":false,"maxParseTime":30000000000,` +
+		`"queueDepth":50,"queueTimeout":5000000000,"priorityBytes":1024,"streamMaxBytes":0,"workerIdleTimeout":60000000000,"workerGrowWait":0,` +
+		`"connOverflow":"wait","connWaitTimeout":2000000000,"maxConnPerIP":4,"connRatePerIP":2.5,` +
+		`"connBurstPerIP":5,"allowCIDRs":["10.0.0.0/8"],"denyCIDRs":null,"banAfter":3,"banTime":60000000000,"tokenStore":"","jwtKeys":"","jwtSecretFile":"","jwtIssuer":"","jwtAudience":"","hmacKeys":"","hmacSkew":0,"hmacMaxBody":0,"statsInterval":0,"statsHistory":0,"statsFile":"","traceEndpoint":"","traceFile":"","traceSample":0,"accessLog":"","accessLogFormat":"","accessLogHeaders":null,"accessLogRedact":null,"accessLogBody":0,"accessLogSample":0}`
 )
 
 func TestOptionsString(t *testing.T) {
@@ -18,10 +23,33 @@ func TestOptionsString(t *testing.T) {
 		Hostname:   "localhost",
 		Port:       8080,
 		ProfPort:   6060,
+		SockPort:   6061,
 		MaxConn:    1001,
+		MinWorkers: 9,
 		MaxWorkers: 999,
 		MaxProcs:   888,
 		Debug:      true,
+
+		UnixSocket:      "/tmp/test.sock",
+		UnixSocketMode:  "0600",
+		UnixSocketOwner: "nobody",
+
+		MaxParseTime:  30 * time.Second,
+		QueueDepth:    50,
+		QueueTimeout:  5 * time.Second,
+		PriorityBytes: 1024,
+
+		WorkerIdleTimeout: time.Minute,
+
+		ConnOverflow:    "wait",
+		ConnWaitTimeout: 2 * time.Second,
+
+		MaxConnPerIP:   4,
+		ConnRatePerIP:  2.5,
+		ConnBurstPerIP: 5,
+		AllowCIDRs:     []string{"10.0.0.0/8"},
+		BanAfter:       3,
+		BanTime:        time.Minute,
 	}
 	actual := fmt.Sprint(opts)
 	if actual != expectedOptionsJSONResult {
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// WorkerStats contains statistics of the parse worker pool.
+type WorkerStats struct {
+	Min         int     `json:"min"`         // Workers kept running when idle.
+	Max         int     `json:"max"`         // Workers allowed to run.
+	Active      int     `json:"active"`      // Workers running.
+	Idle        int     `json:"idle"`        // Workers waiting for a job.
+	Busy        int     `json:"busy"`        // Workers parsing a job.
+	Utilisation float64 `json:"utilisation"` // Fraction of running workers that are busy.
+	Spawned     int64   `json:"spawned"`     // Workers started.
+	Retired     int64   `json:"retired"`     // Workers stopped after being idle.
+}
+
+// workerPool runs between a minimum and maximum number of parse workers on a job queue. Workers
+// are spawned when jobs wait too long for one, and retired after being idle.
+type workerPool struct {
+	jobq        *jobQueue      // Queue the workers take jobs from.
+	min         int            // Workers kept running when idle.
+	max         int            // Workers allowed to run.
+	idleTimeout time.Duration  // How long a worker above the minimum waits for a job.
+	growWait    time.Duration  // How long a job waits for a worker before another is spawned.
+	wg          sync.WaitGroup // Synchronize the workers stopping.
+
+	mu      sync.Mutex // For locking access to the counts.
+	active  int
+	busy    int
+	spawned int64
+	retired int64
+}
+
+// workerPoolNew is a factory function that returns a new pool of workers on jobq. An
+// idleTimeout <= 0 never retires workers, and a growWait <= 0 is DefaultWorkerGrowWait.
+func workerPoolNew(jobq *jobQueue, min int, max int, idleTimeout time.Duration,
+	growWait time.Duration) *workerPool {
+	if min > max {
+		min = max
+	}
+	if min < 0 {
+		min = 0
+	}
+	if growWait <= 0 {
+		growWait = DefaultWorkerGrowWait
+	}
+	return &workerPool{
+		jobq:        jobq,
+		min:         min,
+		max:         max,
+		idleTimeout: idleTimeout,
+		growWait:    growWait,
+	}
+}
+
+// Start spins up the minimum number of workers.
+func (wp *workerPool) Start() {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	for wp.active < wp.min {
+		wp.spawn()
+	}
+}
+
+// Wait waits for the workers to stop after the job queue is closed.
+func (wp *workerPool) Wait() {
+	wp.wg.Wait()
+}
+
+// grow spawns a worker for a job that has waited at least the grow wait for one. Idle workers
+// take queued jobs within microseconds, so a job still waiting after growWait means the running
+// workers are all busy.
+func (wp *workerPool) grow(waited time.Duration) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	if waited >= wp.growWait && wp.active < wp.max {
+		wp.spawn()
+	}
+}
+
+// spawn starts a worker. The lock must be held.
+func (wp *workerPool) spawn() {
+	wp.active++
+	wp.spawned++
+	wp.wg.Add(1)
+	go parseWorker(wp)
+}
+
+// next waits for the next job for a worker. It returns false if the worker should stop, either
+// because the queue was closed or the worker was idle and is not needed.
+func (wp *workerPool) next() (*parseJob, bool) {
+	for {
+		job, err := wp.jobq.dequeue(wp.idleTimeout)
+		wp.mu.Lock()
+		switch {
+		case err == nil:
+			wp.busy++
+			wp.mu.Unlock()
+			return job, true
+		case err == errQueueClosed:
+			wp.active--
+			wp.mu.Unlock()
+			return nil, false
+		case wp.active > wp.min && wp.jobq.Len() == 0:
+			wp.active--
+			wp.retired++
+			wp.mu.Unlock()
+			return nil, false
+		}
+		wp.mu.Unlock()
+	}
+}
+
+// done records that a worker has finished its job.
+func (wp *workerPool) done() {
+	wp.mu.Lock()
+	wp.busy--
+	wp.mu.Unlock()
+}
+
+// Stats returns a snapshot of the worker statistics.
+func (wp *workerPool) Stats() WorkerStats {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	st := WorkerStats{
+		Min:     wp.min,
+		Max:     wp.max,
+		Active:  wp.active,
+		Idle:    wp.active - wp.busy,
+		Busy:    wp.busy,
+		Spawned: wp.spawned,
+		Retired: wp.retired,
+	}
+	if wp.active > 0 {
+		st.Utilisation = float64(wp.busy) / float64(wp.active)
+	}
+	return st
+}
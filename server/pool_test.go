@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolNew(t *testing.T) {
+	t.Parallel()
+	wp := workerPoolNew(jobQueueNew(1), 5, 2, 0, 0)
+	if wp.min != 2 || wp.max != 2 {
+		t.Errorf("Worker pool minimum should be limited to the maximum: %d %d", wp.min, wp.max)
+	}
+	wp = workerPoolNew(jobQueueNew(1), -1, 2, 0, 0)
+	if wp.min != 0 {
+		t.Errorf("Worker pool minimum should not be negative: %d", wp.min)
+	}
+	if wp.growWait != DefaultWorkerGrowWait {
+		t.Errorf("Worker pool grow wait should default: %s", wp.growWait)
+	}
+}
+
+func TestWorkerPoolGrowWait(t *testing.T) {
+	t.Parallel()
+	q := jobQueueNew(1)
+	wp := workerPoolNew(q, 0, 1, 0, time.Hour)
+	job := parseJobNew(context.Background(), workerParseTestText)
+	q.enqueue(job, 0)
+
+	// A queued job grows the pool only once it has waited the grow wait, whatever the queue length.
+	waited, ok := q.waiting(job)
+	if !ok {
+		t.Fatalf("Queued job should be waiting.")
+	}
+	wp.grow(waited)
+	if st := wp.Stats(); st.Spawned != 0 {
+		t.Errorf("Worker pool should not grow before the grow wait: %+v", st)
+	}
+	wp.grow(time.Hour)
+	<-job.DoneCh
+	if st := wp.Stats(); st.Spawned != 1 {
+		t.Errorf("Worker pool should grow after the grow wait: %+v", st)
+	}
+	if _, ok := q.waiting(job); ok {
+		t.Errorf("Job taken by a worker should not be waiting.")
+	}
+	wp.grow(time.Hour)
+	if st := wp.Stats(); st.Spawned != 1 {
+		t.Errorf("Worker pool should not grow over its maximum: %+v", st)
+	}
+
+	q.close()
+	wp.Wait()
+}
+
+func TestWorkerPoolScaling(t *testing.T) {
+	t.Parallel()
+	q := jobQueueNew(10)
+	wp := workerPoolNew(q, 0, 3, 20*time.Millisecond, 0)
+	wp.Start()
+	if st := wp.Stats(); st.Active != 0 {
+		t.Errorf("Worker pool should start the minimum workers: %+v", st)
+	}
+
+	// Queue jobs that wait past the grow wait, so the pool grows within its maximum.
+	var jobs []*parseJob
+	for i := 0; i < 5; i++ {
+		job := parseJobNew(context.Background(), workerParseTestText)
+		q.enqueue(job, 0)
+		jobs = append(jobs, job)
+	}
+	for range jobs {
+		wp.grow(wp.growWait)
+	}
+	for _, job := range jobs {
+		<-job.DoneCh
+	}
+	st := wp.Stats()
+	if st.Spawned < 1 || st.Spawned > 3 || st.Active > st.Max {
+		t.Errorf("Worker pool should have grown within its maximum: %+v", st)
+	}
+
+	// Idle workers above the minimum retire.
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		if st = wp.Stats(); st.Active == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st.Active != 0 || st.Retired != st.Spawned || st.Busy != 0 || st.Utilisation != 0 {
+		t.Errorf("Worker pool should have retired idle workers: %+v", st)
+	}
+
+	q.close()
+	wp.Wait()
+}
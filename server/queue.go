@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQueueFull    = errors.New("Parse queue is full.")
+	ErrQueueTimeout = errors.New("Parse job waited too long for a worker.")
+
+	errQueueClosed = errors.New("Parse queue is closed.")
+	errQueueIdle   = errors.New("No parse job arrived in time.")
+)
+
+// States of a parse job in the queue.
+const (
+	jobQueued    int32 = iota // Waiting for a worker.
+	jobRunning                // Taken by a worker.
+	jobAbandoned              // Given up on by the client; workers skip it.
+)
+
+// QueueStats contains statistics of the parse job queue.
+type QueueStats struct {
+	Capacity  int     `json:"capacity"`  // Maximum number of jobs waiting for a worker.
+	Depth     int     `json:"depth"`     // Number of jobs waiting for a worker.
+	HighDepth int     `json:"highDepth"` // Number of high priority jobs waiting for a worker.
+	Enqueued  int64   `json:"enqueued"`  // Jobs that were queued.
+	Rejected  int64   `json:"rejected"`  // Jobs turned away because the queue was full.
+	Expired   int64   `json:"expired"`   // Jobs that waited too long in the queue.
+	AvgWaitMs float64 `json:"avgWaitMs"` // Average time a job waited for a worker.
+	MaxWaitMs float64 `json:"maxWaitMs"` // Longest time a job waited for a worker.
+}
+
+// jobQueue is a bounded queue of parse jobs waiting for a worker. High priority jobs are always
+// handed to a worker before low priority ones.
+type jobQueue struct {
+	slots  chan bool      // Tokens for free places in the queue.
+	high   chan *parseJob // High priority jobs.
+	low    chan *parseJob // Low priority jobs.
+	stopCh chan bool      // Closed when the workers should stop.
+
+	mu        sync.Mutex // For locking access to the statistics.
+	stats     QueueStats
+	waitTotal time.Duration
+	waitCount int64
+}
+
+// jobQueueNew is a factory function that returns a new queue holding up to depth jobs.
+func jobQueueNew(depth int) *jobQueue {
+	q := &jobQueue{
+		slots:  make(chan bool, depth),
+		high:   make(chan *parseJob, depth),
+		low:    make(chan *parseJob, depth),
+		stopCh: make(chan bool),
+		stats:  QueueStats{Capacity: depth},
+	}
+	for i := 0; i < depth; i++ {
+		q.slots <- true
+	}
+	return q
+}
+
+// enqueue adds a job to the queue, waiting up to timeout for a free place. A timeout <= 0 waits
+// until there is room or the job's context is done.
+func (q *jobQueue) enqueue(job *parseJob, timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-q.slots:
+	case <-job.Ctx.Done():
+		return job.Ctx.Err()
+	case <-expired:
+		q.mu.Lock()
+		q.stats.Rejected++
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+
+	job.queued = time.Now()
+	q.mu.Lock()
+	q.stats.Enqueued++
+	q.mu.Unlock()
+	if job.Priority {
+		q.high <- job
+	} else {
+		q.low <- job
+	}
+	return nil
+}
+
+// dequeue waits up to timeout for the next job for a worker. A timeout <= 0 waits until a job
+// arrives. Jobs abandoned while queued are skipped. An error is returned if no job arrived in
+// time or the queue has been closed.
+func (q *jobQueue) dequeue(timeout time.Duration) (*parseJob, error) {
+	var idle <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+	for {
+		var job *parseJob
+		select {
+		case job = <-q.high:
+		default:
+			select {
+			case job = <-q.high:
+			case job = <-q.low:
+			case <-q.stopCh:
+				return nil, errQueueClosed
+			case <-idle:
+				return nil, errQueueIdle
+			}
+		}
+		q.slots <- true // Put back token.
+
+		if !atomic.CompareAndSwapInt32(&job.state, jobQueued, jobRunning) {
+			continue
+		}
+		wait := time.Since(job.queued)
+		job.waited = wait
+		q.mu.Lock()
+		q.waitTotal += wait
+		q.waitCount++
+		if ms := durationMs(wait); ms > q.stats.MaxWaitMs {
+			q.stats.MaxWaitMs = ms
+		}
+		q.mu.Unlock()
+		return job, nil
+	}
+}
+
+// expire marks a job that waited too long as abandoned. It returns false if a worker has
+// already taken the job.
+func (q *jobQueue) expire(job *parseJob) bool {
+	if !job.abandon() {
+		return false
+	}
+	q.mu.Lock()
+	q.stats.Expired++
+	q.mu.Unlock()
+	return true
+}
+
+// waiting returns how long a queued job has been waiting for a worker. It returns false once a
+// worker has taken the job or it was abandoned.
+func (q *jobQueue) waiting(job *parseJob) (time.Duration, bool) {
+	if atomic.LoadInt32(&job.state) != jobQueued {
+		return 0, false
+	}
+	return time.Since(job.queued), true
+}
+
+// Len returns the number of jobs waiting for a worker.
+func (q *jobQueue) Len() int {
+	return len(q.high) + len(q.low)
+}
+
+// close stops the workers waiting on the queue.
+func (q *jobQueue) close() {
+	close(q.stopCh)
+}
+
+// Stats returns a snapshot of the queue statistics.
+func (q *jobQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st := q.stats
+	st.HighDepth = len(q.high)
+	st.Depth = q.Len()
+	if q.waitCount > 0 {
+		st.AvgWaitMs = durationMs(q.waitTotal / time.Duration(q.waitCount))
+	}
+	return st
+}
+
+// durationMs returns a duration in milliseconds.
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
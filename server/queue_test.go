@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJobQueuePriority(t *testing.T) {
+	t.Parallel()
+	q := jobQueueNew(3)
+	low := parseJobNew(context.Background(), "low")
+	high := parseJobNew(context.Background(), "high", func(j *parseJob) {
+		j.Priority = true
+	})
+	abandoned := parseJobNew(context.Background(), "abandoned", func(j *parseJob) {
+		j.Priority = true
+	})
+	for _, j := range []*parseJob{low, abandoned, high} {
+		if err := q.enqueue(j, 0); err != nil {
+			t.Fatalf("Enqueue failed: %s", err)
+		}
+	}
+	if !q.expire(abandoned) {
+		t.Errorf("Queued job should have been expired.")
+	}
+
+	if st := q.Stats(); st.Depth != 3 || st.HighDepth != 2 || st.Enqueued != 3 || st.Expired != 1 {
+		t.Errorf("Queue stats invalid: %+v", st)
+	}
+	if j, err := q.dequeue(0); err != nil || j != high {
+		t.Errorf("High priority job should be dequeued first, skipping the expired job.")
+	}
+	if j, err := q.dequeue(0); err != nil || j != low {
+		t.Errorf("Low priority job should be dequeued last.")
+	}
+	if q.expire(low) {
+		t.Errorf("Running job should not be expired.")
+	}
+	if st := q.Stats(); st.Depth != 0 {
+		t.Errorf("Queue should be empty: %+v", st)
+	}
+	if _, err := q.dequeue(time.Millisecond); err != errQueueIdle {
+		t.Errorf("Empty queue should time out the worker. Err: %v", err)
+	}
+
+	q.close()
+	if _, err := q.dequeue(0); err != errQueueClosed {
+		t.Errorf("Closed queue should stop the workers. Err: %v", err)
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	t.Parallel()
+	q := jobQueueNew(1)
+	if err := q.enqueue(parseJobNew(context.Background(), "first"), 0); err != nil {
+		t.Fatalf("Enqueue failed: %s", err)
+	}
+	if err := q.enqueue(parseJobNew(context.Background(), "second"), 10*time.Millisecond); err != ErrQueueFull {
+		t.Errorf("Enqueue should have found the queue full. Err: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := q.enqueue(parseJobNew(ctx, "third"), 0); err != context.Canceled {
+		t.Errorf("Enqueue should have been cancelled. Err: %v", err)
+	}
+	if st := q.Stats(); st.Rejected != 1 || st.Capacity != 1 {
+		t.Errorf("Queue stats invalid: %+v", st)
+	}
+}
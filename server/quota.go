@@ -0,0 +1,272 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/composer22/clidemo/auth"
+	"github.com/composer22/clidemo/ratelimit"
+)
+
+var (
+	ErrRateLimited   = errors.New("Token request rate exceeded.")
+	ErrQuotaExceeded = errors.New("Token daily quota exceeded.")
+	ErrDocTooLarge   = errors.New("Document exceeds the maximum size for the token.")
+)
+
+// Prefix of the usage keys of subjects, to tell them from tokens.
+const subjectPrefix = "sub:"
+
+// TokenUsage contains the usage of an auth token against its limits.
+type TokenUsage struct {
+	Requests   int64 `json:"requests"`   // Requests made.
+	Limited    int64 `json:"limited"`    // Requests refused for limits.
+	BytesToday int64 `json:"bytesToday"` // Bytes of parse input sent today (UTC).
+	DailyBytes int64 `json:"dailyBytes"` // Bytes of parse input allowed per day (0 = unlimited).
+}
+
+// tokenUsage is the running usage of a token.
+type tokenUsage struct {
+	TokenUsage
+	bucket *ratelimit.Bucket
+	day    string // UTC day of BytesToday.
+}
+
+// rateInfo is the state of a token's limits after a request, for the response headers.
+type rateInfo struct {
+	limits         auth.Limits
+	remaining      float64       // Requests left at once.
+	reset          time.Duration // Until the next request is allowed.
+	quotaRemaining int64         // Bytes left today.
+	quotaReset     time.Duration // Until the quota is renewed.
+}
+
+// tokenLimiter enforces the request rate and byte quota of auth tokens.
+type tokenLimiter struct {
+	mu    sync.Mutex
+	usage map[string]*tokenUsage
+}
+
+// tokenLimiterNew is a factory function that returns a new tokenLimiter.
+func tokenLimiterNew() *tokenLimiter {
+	return &tokenLimiter{usage: make(map[string]*tokenUsage)}
+}
+
+// allow checks a request against the limits of token and records it. Only parse requests, of
+// size bytes of input, are checked against the document size and quota. Unknown sizes are < 0.
+// An error is returned if the request is over a limit.
+func (tl *tokenLimiter) allow(token string, l auth.Limits, parse bool, size int64,
+	now time.Time) (rateInfo, error) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	u := tl.usageOf(token, l, now)
+	u.Requests++
+	info := rateInfo{limits: l}
+	if l.DailyBytes > 0 {
+		info.quotaRemaining = l.DailyBytes - u.BytesToday
+		if info.quotaRemaining < 0 {
+			info.quotaRemaining = 0
+		}
+		info.quotaReset = nextDay(now).Sub(now)
+	}
+
+	var err error
+	switch {
+	case parse && l.MaxDocBytes > 0 && size > l.MaxDocBytes:
+		err = ErrDocTooLarge
+	case parse && l.DailyBytes > 0 && (info.quotaRemaining == 0 || size > info.quotaRemaining):
+		err = ErrQuotaExceeded
+		info.reset = info.quotaReset
+	case u.bucket != nil && !u.bucket.Allow(now):
+		err = ErrRateLimited
+		info.reset = u.bucket.Wait(now, 1)
+	}
+	if u.bucket != nil {
+		info.remaining = u.bucket.Remaining(now)
+	}
+	if err != nil {
+		u.Limited++
+	}
+	return info, err
+}
+
+// charge charges n bytes of parse input to the quota of token if they fit in the quota
+// remaining. ErrQuotaExceeded is returned if they do not.
+func (tl *tokenLimiter) charge(token string, l auth.Limits, n int64, now time.Time) error {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	u := tl.usageOf(token, l, now)
+	if l.DailyBytes > 0 && u.BytesToday+n > l.DailyBytes {
+		u.Limited++
+		return ErrQuotaExceeded
+	}
+	u.BytesToday += n
+	return nil
+}
+
+// addBytes charges n bytes of parse input to the quota of token.
+func (tl *tokenLimiter) addBytes(token string, l auth.Limits, n int64, now time.Time) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.usageOf(token, l, now).BytesToday += n
+}
+
+// usageOf returns the usage of token, renewing its quota on a new day. The lock must be held.
+func (tl *tokenLimiter) usageOf(token string, l auth.Limits, now time.Time) *tokenUsage {
+	u, ok := tl.usage[token]
+	if !ok {
+		u = &tokenUsage{}
+		tl.usage[token] = u
+	}
+	if day := now.UTC().Format("2006-01-02"); day != u.day {
+		u.day, u.BytesToday = day, 0
+	}
+	if l.RequestsPerSec <= 0 {
+		u.bucket = nil
+	} else if u.bucket == nil || u.bucket.Rate != l.RequestsPerSec {
+		u.bucket = ratelimit.NewBucket(l.RequestsPerSec, l.Burst)
+	}
+	u.DailyBytes = l.DailyBytes
+	return u
+}
+
+// Usage returns the usage of each token seen, keyed by subject or a masked token.
+func (tl *tokenLimiter) Usage() map[string]TokenUsage {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	m := make(map[string]TokenUsage, len(tl.usage))
+	for key, u := range tl.usage {
+		if !strings.HasPrefix(key, subjectPrefix) {
+			key = maskToken(key)
+		}
+		m[key] = u.TokenUsage
+	}
+	return m
+}
+
+// limitKey returns the key the usage of a token is kept by: the subject of the identity if it
+// has one, so that the tokens issued to a subject share its limits, otherwise the token.
+func limitKey(id *auth.Identity, token string) string {
+	if id != nil && id.Subject != "" {
+		return subjectPrefix + id.Subject
+	}
+	return token
+}
+
+// maskToken returns enough of a token to recognise it without revealing it.
+func maskToken(tk string) string {
+	if len(tk) <= 8 {
+		return "****"
+	}
+	return tk[:6] + "****"
+}
+
+// nextDay returns the start of the next UTC day.
+func nextDay(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// quotaBody charges the bytes read from a request body to a token's quota. The body is capped
+// at the document size or quota remaining, whichever is less, so that bodies of unknown length
+// cannot go past either.
+type quotaBody struct {
+	io.ReadCloser
+	charge    func(n int64)
+	overQuota bool // The cap is the quota remaining: reading past it is ErrQuotaExceeded.
+}
+
+// Read is an implementation of the io.Reader interface.
+func (b *quotaBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.charge(int64(n))
+	}
+	var tooLarge *http.MaxBytesError
+	if b.overQuota && errors.As(err, &tooLarge) {
+		err = ErrQuotaExceeded
+	}
+	return n, err
+}
+
+// overLimit checks a request against the limits of its token. Requests over a limit are
+// answered with a 429, or a 413 for documents that are too large. The X-RateLimit-* headers
+// are set for tokens with limits. Only the bodies of parse routes count as documents.
+func (s *Server) overLimit(w http.ResponseWriter, r *http.Request) bool {
+	key, l := s.tokenLimits(r)
+	parse := routeScope(r.URL.Path) == auth.ScopeParse
+	info, err := s.limits.allow(key, l, parse, r.ContentLength, time.Now())
+	setRateHeaders(w, info)
+	if err != nil {
+		status, e := limitError(err)
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(info.reset.Seconds()))))
+		}
+		writeError(w, status, e)
+		return true
+	}
+
+	if parse && r.Body != nil && (l.DailyBytes > 0 || l.MaxDocBytes > 0) {
+		max, overQuota := l.MaxDocBytes, false
+		if l.DailyBytes > 0 && (max <= 0 || info.quotaRemaining < max) {
+			max, overQuota = info.quotaRemaining, true
+		}
+		r.Body = &quotaBody{
+			ReadCloser: http.MaxBytesReader(w, r.Body, max),
+			overQuota:  overQuota,
+			charge: func(n int64) {
+				s.limits.addBytes(key, l, n, time.Now())
+			},
+		}
+	}
+	return false
+}
+
+// tokenLimits returns the key the usage of the token of a request is kept by, and its limits.
+func (s *Server) tokenLimits(r *http.Request) (string, auth.Limits) {
+	token := bearerToken(r)
+	if id, ok := auth.FromContext(r.Context()); ok {
+		return limitKey(id, token), id.Limits
+	}
+	return token, s.auth.TokenLimits(token)
+}
+
+// quotaExceeded answers a request whose body went past the quota of its token as it was read.
+func quotaExceeded(w http.ResponseWriter) {
+	now := time.Now()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(nextDay(now).Sub(now).Seconds()))))
+	status, e := limitError(ErrQuotaExceeded)
+	writeError(w, status, e)
+}
+
+// limitError returns the status code and error of the API for a request over a token limit.
+func limitError(err error) (int, *APIError) {
+	switch err {
+	case ErrDocTooLarge:
+		return http.StatusRequestEntityTooLarge, APIErrorNew(CodeDocumentTooLarge, DocumentTooLarge)
+	case ErrQuotaExceeded:
+		return http.StatusTooManyRequests, APIErrorNew(CodeQuotaExceeded, QuotaExceeded)
+	}
+	return http.StatusTooManyRequests, APIErrorNew(CodeRateLimited, RateLimited)
+}
+
+// setRateHeaders sets the X-RateLimit-* response headers for the limits of a token.
+func setRateHeaders(w http.ResponseWriter, info rateInfo) {
+	h := w.Header()
+	if info.limits.RequestsPerSec > 0 {
+		h.Set("X-RateLimit-Limit", strconv.FormatFloat(info.limits.RequestsPerSec, 'f', -1, 64))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(int(info.remaining)))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(info.reset.Seconds()))))
+	}
+	if info.limits.DailyBytes > 0 {
+		h.Set("X-RateLimit-Quota-Limit", strconv.FormatInt(info.limits.DailyBytes, 10))
+		h.Set("X-RateLimit-Quota-Remaining", strconv.FormatInt(info.quotaRemaining, 10))
+		h.Set("X-RateLimit-Quota-Reset", strconv.Itoa(int(math.Ceil(info.quotaReset.Seconds()))))
+	}
+}
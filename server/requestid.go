@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// maxRequestIDLen is the longest X-Request-ID accepted from a client.
+const maxRequestIDLen = 128
+
+// traceSampled is the trace flag set when the trace is recorded.
+const traceSampled = 0x01
+
+// traceContext is the W3C Trace Context of a request: the trace it is part of, the span of the
+// caller and the span of this server. See https://www.w3.org/TR/trace-context/
+type traceContext struct {
+	traceID  [16]byte
+	parentID [8]byte // Span of the caller; zero if the request started the trace.
+	spanID   [8]byte // Span of this server.
+	flags    byte    // Trace flags e.g. sampled.
+	state    string  // Vendor data of the tracestate header, passed on unchanged.
+}
+
+// requestContext is what the middleware keeps in the context of a request.
+type requestContext struct {
+	id    string // X-Request-ID.
+	trace traceContext
+}
+
+// requestContextKey is the key of the request context in a context.Context.
+type requestContextKey struct{}
+
+// withRequestID returns the request with its request ID and trace context in the context. A
+// valid X-Request-ID from the client is kept, otherwise one is made. The trace is continued
+// from a valid traceparent header, otherwise a trace is started. If tracing is enabled, the span
+// of the request is started and carried in the context too.
+func (s *Server) withRequestID(r *http.Request) *http.Request {
+	rc := requestContext{id: r.Header.Get("X-Request-ID")}
+	if !validRequestID(rc.id) {
+		rc.id = createV4UUID()
+	}
+	tc, ok := parseTraceparent(r.Header.Get("traceparent"))
+	if ok {
+		tc.parentID = tc.spanID
+		tc.state = r.Header.Get("tracestate")
+	} else {
+		rand.Read(tc.traceID[:])
+	}
+	rand.Read(tc.spanID[:])
+	ctx, span := s.startRequestSpan(r.Context(), r.Method+" "+metricsRoute(r.URL.Path), &tc, ok)
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("http.route", metricsRoute(r.URL.Path))
+	span.SetAttribute("request.id", rc.id)
+	rc.trace = tc
+	return r.WithContext(context.WithValue(ctx, requestContextKey{}, rc))
+}
+
+// withNewRequestID returns a context with a new request ID and trace, for requests that do not
+// come in over http such as those of the socket protocol. If tracing is enabled, the span of the
+// request on route is started and carried in the context too.
+func (s *Server) withNewRequestID(ctx context.Context, route string) context.Context {
+	rc := requestContext{id: createV4UUID()}
+	rand.Read(rc.trace.traceID[:])
+	rand.Read(rc.trace.spanID[:])
+	ctx, span := s.startRequestSpan(ctx, route, &rc.trace, false)
+	span.SetAttribute("request.id", rc.id)
+	return context.WithValue(ctx, requestContextKey{}, rc)
+}
+
+// requestFromContext returns the request ID and trace context kept in a context.
+func requestFromContext(ctx context.Context) (requestContext, bool) {
+	rc, ok := ctx.Value(requestContextKey{}).(requestContext)
+	return rc, ok
+}
+
+// RequestIDFromContext returns the request ID of the request a context belongs to, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	rc, _ := requestFromContext(ctx)
+	return rc.id
+}
+
+// validRequestID returns true if a request ID from a client may be used: up to 128 letters,
+// digits and the characters - _ . : so that it is safe in headers and logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseTraceparent returns the trace context of a traceparent header, with the span of the
+// caller in spanID. ok is false if the header is missing or invalid.
+func parseTraceparent(h string) (tc traceContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 ||
+		len(parts[3]) != 2 {
+		return tc, false
+	}
+	// Later versions may add fields, but version 00 has exactly four; ff is invalid.
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return tc, false
+	}
+	var version, flags [1]byte
+	for _, p := range []struct {
+		dst []byte
+		src string
+	}{{version[:], parts[0]}, {tc.traceID[:], parts[1]}, {tc.spanID[:], parts[2]}, {flags[:], parts[3]}} {
+		if strings.ToLower(p.src) != p.src { // Upper case hex is invalid.
+			return traceContext{}, false
+		}
+		if _, err := hex.Decode(p.dst, []byte(p.src)); err != nil {
+			return traceContext{}, false
+		}
+	}
+	if tc.traceID == [16]byte{} || tc.spanID == [8]byte{} {
+		return traceContext{}, false
+	}
+	tc.flags = flags[0]
+	return tc, true
+}
+
+// traceparent returns the traceparent header of the span of this server.
+func (tc traceContext) traceparent() string {
+	return "00-" + hex.EncodeToString(tc.traceID[:]) + "-" + hex.EncodeToString(tc.spanID[:]) + "-" +
+		hex.EncodeToString([]byte{tc.flags})
+}
+
+// traceIDHex returns the trace ID as hex, as it appears in logs and traceparent headers.
+func (tc traceContext) traceIDHex() string {
+	return hex.EncodeToString(tc.traceID[:])
+}
+
+// logPrefix returns the prefix of the log lines about the request of a context: its request ID,
+// so that the lines of a request can be found together.
+func logPrefix(ctx context.Context) string {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return "[" + id + "] "
+	}
+	return ""
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Environment handed to a new server process during a graceful restart.
+	envListenFD = "CLIDEMO_LISTEN_FD" // Descriptor of the inherited http listening socket.
+	envReadyFD  = "CLIDEMO_READY_FD"  // Descriptor to signal on once the new process is serving.
+	envSockFD   = "CLIDEMO_SOCK_FD"   // Descriptor of the inherited socket protocol listener.
+	envProfFD   = "CLIDEMO_PROF_FD"   // Descriptor of the inherited profiler listener.
+
+	restartListenFD = 3 // First of exec.Cmd.ExtraFiles.
+	restartReadyFD  = 4 // The other listeners follow.
+)
+
+// filer is implemented by listeners that can hand over their socket (TCP and Unix sockets).
+type filer interface {
+	File() (*os.File, error)
+}
+
+// handover is a listening socket handed to a new process, whose descriptor is given in the
+// environment variable env.
+type handover struct {
+	env string
+	ln  net.Listener
+}
+
+// Restart performs a zero-downtime upgrade. A new copy of the server binary is started and
+// handed every listening socket: http, and the socket protocol and profiler ones if open, so
+// that it binds no ports itself. Once the new process reports it is serving, the caller should
+// Shutdown this one to drain in-flight requests, which then leaves the stats file to the new
+// process. If the new process fails to start, this server keeps running and an error is
+// returned.
+func (s *Server) Restart() error {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return errors.New("Server is not running.")
+	}
+	ln := s.listener.Listener
+	hs := []handover{{envListenFD, ln}}
+	if s.sockListener != nil {
+		hs = append(hs, handover{envSockFD, s.sockListener.Listener})
+	}
+	if s.profListener != nil {
+		hs = append(hs, handover{envProfFD, s.profListener})
+	}
+	s.mu.Unlock()
+
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	files, env, err := restartFiles(hs, w)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(restartEnviron(), env...)
+	err = cmd.Start()
+	w.Close() // Only the child holds the write end now so its exit unblocks the read below.
+	if err != nil {
+		return err
+	}
+	s.log.Infof("Started new server process %d; waiting for it to accept connections.\n",
+		cmd.Process.Pid)
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(RestartTimeout):
+		err = errors.New("timed out")
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("New server process failed to become ready: %s", err)
+	}
+	cmd.Process.Release()
+	s.mu.Lock()
+	s.handover = true // The new process may have restored the stats file: Shutdown must not save it.
+	s.mu.Unlock()
+
+	// A Unix socket file must stay in place for the new process when this one closes.
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	return nil
+}
+
+// restartFiles returns the files to pass to a new process for the listeners hs, the first of
+// which is the http one, and the ready pipe, with the environment variables that tell the new
+// process their descriptors. The files are returned to be closed even if there is an error.
+func restartFiles(hs []handover, ready *os.File) ([]*os.File, []string, error) {
+	files := make([]*os.File, 0, len(hs)+1)
+	env := make([]string, 0, len(hs)+1)
+	for i, h := range hs {
+		lf, ok := h.ln.(filer)
+		if !ok {
+			return files, env, fmt.Errorf("Listener %s cannot be handed over to a new process.", h.ln.Addr())
+		}
+		f, err := lf.File()
+		if err != nil {
+			return files, env, err
+		}
+		env = append(env, fmt.Sprintf("%s=%d", h.env, restartListenFD+len(files)))
+		files = append(files, f)
+		if i == 0 {
+			env = append(env, fmt.Sprintf("%s=%d", envReadyFD, restartReadyFD))
+			files = append(files, ready)
+		}
+	}
+	return files, env, nil
+}
+
+// restartEnviron returns the environment of this process without any restart or socket
+// activation variables, which only apply to this process.
+func restartEnviron() []string {
+	env := make([]string, 0)
+	for _, e := range os.Environ() {
+		switch strings.SplitN(e, "=", 2)[0] {
+		case envListenFD, envReadyFD, envSockFD, envProfFD, sdEnvListenPID, sdEnvListenFDs, sdEnvListenNames:
+		default:
+			env = append(env, e)
+		}
+	}
+	return env
+}
+
+// inheritedListener returns the listener whose descriptor is in the environment variable env,
+// handed over by a parent process during a graceful restart, or nil if there is none.
+func inheritedListener(env string) (net.Listener, error) {
+	v := os.Getenv(env)
+	if v == "" {
+		return nil, nil
+	}
+	os.Unsetenv(env)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s: %s", env, v)
+	}
+	lns, err := listenersFromFDs(fd, 1)
+	if err != nil {
+		return nil, err
+	}
+	return lns[0], nil
+}
+
+// notifyReady tells the parent process of a graceful restart that this server is accepting
+// connections so it can begin draining.
+func notifyReady() {
+	v := os.Getenv(envReadyFD)
+	if v == "" {
+		return
+	}
+	os.Unsetenv(envReadyFD)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRestartNotRunning(t *testing.T) {
+	t.Parallel()
+	s := New(&Options{Hostname: "localhost"})
+	if err := s.Restart(); err == nil {
+		t.Errorf("Restart should fail when the server is not running.")
+	}
+}
+
+func TestRestartEnviron(t *testing.T) {
+	os.Setenv(envListenFD, "3")
+	os.Setenv(sdEnvListenFDs, "1")
+	defer os.Unsetenv(envListenFD)
+	defer os.Unsetenv(sdEnvListenFDs)
+	for _, e := range restartEnviron() {
+		if e == envListenFD+"=3" || e == sdEnvListenFDs+"=1" {
+			t.Errorf("Environment variable should not be passed on to the new process: %s", e)
+		}
+	}
+}
+
+func TestRestartInheritedListener(t *testing.T) {
+	ln, err := inheritedListener(envListenFD)
+	if ln != nil || err != nil {
+		t.Errorf("No listener should be inherited without the environment set.")
+	}
+
+	tl, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	defer tl.Close()
+	f, _ := tl.(*net.TCPListener).File()
+	defer f.Close()
+	fd, _ := syscall.Dup(int(f.Fd()))
+	os.Setenv(envListenFD, strconv.Itoa(fd))
+	ln, err = inheritedListener(envListenFD)
+	if err != nil {
+		t.Fatalf("Inherited listener not created: %s", err)
+	}
+	defer ln.Close()
+	if ln.Addr().String() != tl.Addr().String() {
+		t.Errorf("Inherited listener address mismatch.")
+	}
+	if os.Getenv(envListenFD) != "" {
+		t.Errorf("Restart environment should have been cleared.")
+	}
+}
+
+func TestRestartNotifyReady(t *testing.T) {
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	fd, _ := syscall.Dup(int(w.Fd()))
+	w.Close()
+	os.Setenv(envReadyFD, strconv.Itoa(fd))
+	notifyReady()
+	b := make([]byte, 1)
+	if n, err := r.Read(b); n != 1 || err != nil {
+		t.Errorf("Ready notification not received: %v", err)
+	}
+	if os.Getenv(envReadyFD) != "" {
+		t.Errorf("Ready environment should have been cleared.")
+	}
+}
+
+func TestRestartFiles(t *testing.T) {
+	var hs []handover
+	for _, env := range []string{envListenFD, envSockFD, envProfFD} {
+		ln, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatalf("Could not listen: %s", err)
+		}
+		defer ln.Close()
+		hs = append(hs, handover{env, ln})
+	}
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	files, env, err := restartFiles(hs, w)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		t.Fatalf("Files not created: %s", err)
+	}
+	expected := []string{envListenFD + "=3", envReadyFD + "=4", envSockFD + "=5", envProfFD + "=6"}
+	if len(files) != 4 || files[1] != w || strings.Join(env, " ") != strings.Join(expected, " ") {
+		t.Fatalf("Every listener should be handed over after the ready pipe: %d %v", len(files), env)
+	}
+
+	// The new process finds the socket protocol and profiler listeners at those descriptors.
+	for i, h := range hs[1:] {
+		fd, _ := syscall.Dup(int(files[i+2].Fd()))
+		os.Setenv(h.env, strconv.Itoa(fd))
+		ln, err := inheritedListener(h.env)
+		if err != nil {
+			t.Fatalf("Inherited listener not created: %s", err)
+		}
+		if ln.Addr().String() != h.ln.Addr().String() {
+			t.Errorf("Inherited %s listener address mismatch.", h.env)
+		}
+		ln.Close()
+	}
+
+	if _, _, err := restartFiles([]handover{{envListenFD, &acceptGate{Listener: hs[0].ln}}}, w); err == nil {
+		t.Errorf("Listeners without a socket cannot be handed over.")
+	}
+}
+
+func TestRestartStatsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clidemo-stats")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "stats.json")
+	s := New(&Options{Hostname: "localhost", Port: 8093, MaxWorkers: 1, StatsFile: file})
+	start := func() {
+		go s.Start()
+		for i := 0; i < 100 && !s.isRunning(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// After a handover the stats file belongs to the new process.
+	start()
+	s.mu.Lock()
+	s.handover = true
+	s.mu.Unlock()
+	ioutil.WriteFile(file, []byte(`{"note":"new process"}`), 0644)
+	s.Shutdown()
+	if b, _ := ioutil.ReadFile(file); string(b) != `{"note":"new process"}` {
+		t.Errorf("Shutdown after a handover should not save the stats: %s", b)
+	}
+
+	start()
+	s.Shutdown()
+	if b, _ := ioutil.ReadFile(file); !strings.Contains(string(b), `"counters"`) {
+		t.Errorf("Shutdown should save the stats: %s", b)
+	}
+}
@@ -4,16 +4,21 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	// Allow dynamic profiling.
@@ -21,35 +26,47 @@ import (
 
 	"github.com/composer22/clidemo/auth"
 	"github.com/composer22/clidemo/logger"
+	"github.com/composer22/clidemo/parser"
+	"github.com/composer22/clidemo/tracing"
+	"github.com/composer22/clidemo/websocket"
 )
 
-// requestLogEntry is a datastructure of a log entry for recording server access requests.
-type requestLogEntry struct {
-	Method        string      `json:"method"`
-	URL           *url.URL    `json:"url"`
-	Proto         string      `json:"proto"`
-	Header        http.Header `json:"header"`
-	Body          string      `json:"body"`
-	ContentLength int64       `json:"contentLength"`
-	Host          string      `json:"host"`
-	RemoteAddr    string      `json:"remoteAddr"`
-	RequestURI    string      `json:"requestURI"`
-	Trailer       http.Header `json:"trailer"`
-}
-
 // Server is the main structure that represents a server instance.
 type Server struct {
 	mu       sync.Mutex         // For locking access to server params.
 	info     *Info              // Basic server information.
 	opts     *Options           // Original options and info for creating the server.
 	running  bool               // Is the server running?
+	started  bool               // Has the server finished starting?
+	draining bool               // Is the server shutting down?
+	handover bool               // Has a new process taken over the listeners?
 	auth     *auth.Auth         // Authorization lookup
+	hmac     *auth.HMACVerifier // Optional verifier of signed requests.
+	limits   *tokenLimiter      // Usage limits of auth tokens.
 	log      *logger.Logger     // Log instance for recording error and other messages.
-	jobq     chan *parseJob     // Channel to send jobs.
+	jobq     *jobQueue          // Queue of jobs for the workers.
 	srvr     *http.Server       // HTTP server.
 	listener *ThrottledListener // Optional listener for connections.
-	wg       sync.WaitGroup     // Synchronize close() of job channel.
+	pool     *workerPool        // Workers parsing the jobs.
 	stats    *Status            // Server statistics since it started.
+	history  *statsHistory      // Periodic snapshots of the statistics.
+	metrics  *serverMetrics     // Metrics for monitoring systems.
+	tracer   *tracing.Tracer    // Optional tracer of the stages of requests.
+	access   *accessLog         // Optional log of the http requests.
+	doneCh   chan bool          // Closed when a Shutdown has completed.
+
+	profListener net.Listener // Optional listener for the profiler.
+
+	sockListener *ThrottledListener // Optional listener for the socket protocol.
+	sockMu       sync.Mutex         // For locking access to socket connections.
+	sockConns    map[net.Conn]bool  // Open socket protocol connections.
+	sockStopping bool               // Socket protocol connections should close.
+	sockWg       sync.WaitGroup     // Synchronize close() of socket connections.
+
+	streamMu sync.Mutex               // For locking access to websockets.
+	streams  map[*websocket.Conn]bool // Open websockets.
+
+	health map[string]HealthCheck // Added checks of the readiness probe by name.
 }
 
 // New is a factory function that returns a new server instance.
@@ -59,33 +76,102 @@ func New(opts *Options, addedOptions ...func(*Server)) *Server {
 			i.Name = opts.Name
 			i.Hostname = opts.Hostname
 			i.Port = opts.Port
+			i.SockPort = opts.SockPort
+			i.UnixSocket = opts.UnixSocket
 			i.ProfPort = opts.ProfPort
 			i.MaxConn = opts.MaxConn
+			i.MinWorkers = opts.MinWorkers
 			i.MaxWorkers = opts.MaxWorkers
 			i.Debug = opts.Debug
 		}),
 		opts:    opts,
 		auth:    auth.New(),
+		limits:  tokenLimiterNew(),
 		log:     logger.New(logger.UseDefault, false),
 		stats:   StatusNew(),
+		history: statsHistoryNew(historySize(opts)),
 		running: false,
 	}
 
+	s.metrics = serverMetricsNew()
+
+	access, err := accessLogNew(opts, s.log)
+	if err != nil {
+		s.log.Emergencyf("Cannot open access log: %s\n", err)
+	}
+	s.access = access
+
+	if opts.TraceEndpoint != "" || opts.TraceFile != "" {
+		exp, err := traceExporter(opts)
+		if err != nil {
+			s.log.Emergencyf("Cannot open trace file %s: %s\n", opts.TraceFile, err)
+		}
+		s.tracer = tracing.NewTracer(exp, func(t *tracing.Tracer) {
+			t.Service = "clidemo"
+			t.Version = version
+			t.SampleRatio = opts.TraceSample
+		})
+	}
+
 	if s.info.Debug {
 		s.log.SetLogLevel(logger.Debug)
 	}
+	if opts.TokenStore != "" {
+		st, err := auth.OpenStore(opts.TokenStore)
+		if err != nil {
+			s.log.Emergencyf("Cannot open token store %s: %s\n", opts.TokenStore, err)
+		}
+		s.auth.Store = st
+		s.AddHealthCheck("tokenStore", func(ctx context.Context) error {
+			if err := st.Err(); err != nil {
+				return Degraded(err) // The tokens read before are still accepted.
+			}
+			return nil
+		})
+	}
+	if opts.JWTKeys != "" || opts.JWTSecretFile != "" {
+		p, err := jwtProviderNew(opts)
+		if err != nil {
+			s.log.Emergencyf("Cannot load JWT keys: %s\n", err)
+		}
+		s.auth.Providers = append(s.auth.Providers, p)
+	}
+	if opts.HMACKeys != "" {
+		keys, err := auth.LoadHMACKeys(opts.HMACKeys)
+		if err != nil {
+			s.log.Emergencyf("Cannot load HMAC keys: %s\n", err)
+		}
+		s.hmac = auth.HMACVerifierNew(keys, func(v *auth.HMACVerifier) {
+			if opts.HMACSkew > 0 {
+				v.Skew = opts.HMACSkew
+			}
+		})
+	}
+
+	if opts.StatsFile != "" {
+		if err := s.loadStats(); err != nil {
+			s.log.Errorf("Cannot restore statistics from %s: %s\n", opts.StatsFile, err)
+		}
+	}
 
 	// Setup the routes, middleware, and server.
 	mux := http.NewServeMux()
 	mux.HandleFunc(httpRouteAliveV1, s.aliveHandler)
 	mux.HandleFunc(httpRouteParseV1, s.parseHandler)
 	mux.HandleFunc(httpRouteStatusV1, s.statusHandler)
-	s.srvr = &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", s.info.Hostname, s.info.Port),
-		Handler:      &Middleware{serv: s, handler: mux},
-		ReadTimeout:  TCPReadTimeout,
-		WriteTimeout: TCPWriteTimeout,
-	}
+	mux.HandleFunc(httpRouteHistoryV1, s.statusHistoryHandler)
+	mux.HandleFunc(httpRouteResetV1, s.statusResetHandler)
+	mux.HandleFunc(httpRouteStreamV1, s.streamHandler)
+	mux.HandleFunc(httpRouteEventsV1, s.eventsHandler)
+	mux.HandleFunc(httpRouteAdminTokensV1, s.adminTokensHandler)
+	mux.HandleFunc(httpRouteAdminTokensV1+"/", s.adminTokensHandler)
+	mux.HandleFunc(httpRouteMetrics, s.metricsHandler)
+	mux.HandleFunc(httpRouteHealthz, s.healthzHandler)
+	mux.HandleFunc(httpRouteReadyz, s.readyzHandler)
+	mux.HandleFunc(httpRouteStartupz, s.startupzHandler)
+	mux.HandleFunc("/", s.notFoundHandler)
+	s.srvr = newHTTPServer(fmt.Sprintf("%s:%d", s.info.Hostname, s.info.Port),
+		&Middleware{serv: s, handler: mux})
 
 	s.handleSignals() // Evoke trap signals handler
 
@@ -96,6 +182,52 @@ func New(opts *Options, addedOptions ...func(*Server)) *Server {
 	return s
 }
 
+// historySize returns the number of snapshots of the statistics kept for the options.
+func historySize(opts *Options) int {
+	if opts.StatsInterval <= 0 {
+		return 0
+	}
+	h := opts.StatsHistory
+	if h <= 0 {
+		h = DefaultStatsHistory
+	}
+	return int(h / opts.StatsInterval)
+}
+
+// jwtProviderNew returns a provider of JWT identities with the keys and claims in the options.
+func jwtProviderNew(opts *Options) (*auth.JWTProvider, error) {
+	var keys []auth.JWTKey
+	if opts.JWTKeys != "" {
+		k, err := auth.LoadJWTKeys(opts.JWTKeys)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k...)
+	}
+	if opts.JWTSecretFile != "" {
+		b, err := ioutil.ReadFile(opts.JWTSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, auth.JWTKey{Key: bytes.TrimSpace(b)})
+	}
+	return auth.JWTProviderNew(func(p *auth.JWTProvider) {
+		p.Keys = keys
+		p.Issuer = opts.JWTIssuer
+		p.Audience = opts.JWTAudience
+	}), nil
+}
+
+// newHTTPServer returns the HTTP server configuration for serving the API.
+func newHTTPServer(addr string, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      h,
+		ReadTimeout:  TCPReadTimeout,
+		WriteTimeout: TCPWriteTimeout,
+	}
+}
+
 // PrintVersionAndExit prints the version of the server then exits.
 func PrintVersionAndExit() {
 	fmt.Printf("clidemo version %s\n", version)
@@ -104,22 +236,46 @@ func PrintVersionAndExit() {
 
 // Start spins up the server to accept incoming connections.
 func (s *Server) Start() {
-	var err error
-
 	s.log.Infof("Starting clidemo version %s\n", version)
 	s.mu.Lock()
 
-	s.listener, err = ThrottledListenerNew(s.srvr.Addr, s.info.MaxConn)
+	mode, err := ParseOverflowMode(s.opts.ConnOverflow)
+	if err != nil {
+		s.mu.Unlock()
+		s.log.Emergencyf("%s: %s\n", err, s.opts.ConnOverflow)
+	}
+	guard, err := IPGuardNew(s.opts)
 	if err != nil {
 		s.mu.Unlock()
 		s.log.Emergencyf("%s\n", err)
 	}
+	ln, err := s.openListener()
+	if err != nil {
+		s.mu.Unlock()
+		s.log.Emergencyf("%s\n", err)
+	}
+	s.listener = ThrottledListenerWrap(ln, s.info.MaxConn, func(t *ThrottledListener) {
+		t.Mode = mode
+		t.WaitTimeout = s.opts.ConnWaitTimeout
+		t.Guard = guard
+	})
 
 	// Spin off the worker processes.
-	s.jobq = make(chan *parseJob)
-	for i := 0; i < s.info.MaxWorkers; i++ {
-		s.wg.Add(1)
-		go parseWorker(s.jobq, &s.wg)
+	depth := s.opts.QueueDepth
+	if depth <= 0 {
+		depth = s.info.MaxWorkers
+	}
+	s.jobq = jobQueueNew(depth)
+	s.pool = workerPoolNew(s.jobq, s.info.MinWorkers, s.info.MaxWorkers, s.opts.WorkerIdleTimeout,
+		s.opts.WorkerGrowWait)
+	s.pool.Start()
+
+	// Raw socket protocol endpoint.
+	if s.info.SockPort > 0 {
+		if err := s.StartSocket(); err != nil {
+			s.mu.Unlock()
+			s.log.Emergencyf("Error starting socket protocol service: %s\n", err)
+		}
 	}
 
 	// Pprof http endpoint for the profiler.
@@ -127,25 +283,100 @@ func (s *Server) Start() {
 		s.StartProfiler()
 	}
 
-	s.stats.Start = time.Now()
+	s.streamMu.Lock()
+	s.streams = make(map[*websocket.Conn]bool)
+	s.streamMu.Unlock()
+
+	s.stats.Started(time.Now())
 	s.running = true
+	s.started = true
+	s.doneCh = make(chan bool)
+	doneCh := s.doneCh
+	srvr := s.srvr
+	if s.opts.StatsInterval > 0 {
+		go s.recordHistory(s.opts.StatsInterval, doneCh)
+	}
+	if s.tracer != nil {
+		go s.exportTraces(TraceExportInterval, doneCh)
+	}
 	s.mu.Unlock()
-	s.srvr.Serve(s.listener)
+	notifyReady()
+
+	err = srvr.Serve(s.listener)
+	if err != http.ErrServerClosed && err != StoppedError {
+		s.log.Errorf("Server stopped serving: %s\n", err)
+		return
+	}
+	<-doneCh // Wait for Shutdown to drain before returning.
+}
+
+// openListener returns the listener the server accepts connections on. A socket handed over by
+// a graceful restart takes priority, then one passed in by systemd, then a Unix domain socket,
+// otherwise TCP on the hostname and port.
+func (s *Server) openListener() (net.Listener, error) {
+	ln, err := inheritedListener(envListenFD)
+	if err != nil || ln != nil {
+		if ln != nil {
+			s.log.Infof("Listening on socket %s handed over by the previous process\n", ln.Addr())
+		}
+		return ln, err
+	}
+
+	switch {
+	case s.opts.Systemd:
+		lns, err := SystemdListeners()
+		if err != nil {
+			return nil, err
+		}
+		if len(lns) == 0 {
+			return nil, errors.New("No sockets were passed in by systemd (LISTEN_FDS).")
+		}
+		for _, l := range lns[1:] { // Only the first socket is served.
+			l.Close()
+		}
+		s.log.Infof("Listening on systemd socket %s\n", lns[0].Addr())
+		return lns[0], nil
+	case s.opts.UnixSocket != "":
+		mode, err := parseFileMode(s.opts.UnixSocketMode)
+		if err != nil {
+			return nil, err
+		}
+		s.log.Infof("Listening on unix socket %s\n", s.opts.UnixSocket)
+		return UnixListenerNew(s.opts.UnixSocket, mode, s.opts.UnixSocketOwner)
+	default:
+		return net.Listen("tcp", s.srvr.Addr)
+	}
 }
 
-// StartProfiler is called to enable dynamic profiling.
+// StartProfiler is called to enable dynamic profiling. The port is bound before it returns, or
+// the listener handed over by a graceful restart is used.
 func (s *Server) StartProfiler() {
 	s.log.Infof("Starting profiling on http port %d", s.opts.ProfPort)
-	hp := fmt.Sprintf("%s:%d", s.info.Hostname, s.info.ProfPort)
+	ln, err := inheritedListener(envProfFD)
+	if err == nil && ln == nil {
+		ln, err = net.Listen("tcp", fmt.Sprintf("%s:%d", s.info.Hostname, s.info.ProfPort))
+	}
+	if err != nil {
+		s.log.Emergencyf("Error starting profile monitoring service: %s", err)
+	}
+	s.profListener = ln
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, err := s.withIdentity(w, r)
+		if s.invalidBody(w, err) || s.invalidAuth(w, r) || s.invalidScope(w, r, auth.ScopeProfile) {
+			return
+		}
+		http.DefaultServeMux.ServeHTTP(w, r)
+	})
 	go func() {
-		err := http.ListenAndServe(hp, nil)
+		err := http.Serve(ln, h)
 		if err != nil {
-			s.log.Emergencyf("Error starting profile monitoring service: %s", err)
+			s.log.Errorf("Profile monitoring service stopped: %s", err)
 		}
 	}()
 }
 
-// Shutdown takes down the server gracefully back to an initialize state.
+// Shutdown takes down the server gracefully back to an initialize state. New connections are
+// refused and in-flight requests are allowed to complete before the workers are stopped.
 func (s *Server) Shutdown() bool {
 	if !s.isRunning() {
 		return true
@@ -154,21 +385,17 @@ func (s *Server) Shutdown() bool {
 	s.log.Infof("BEGIN server service stop.")
 
 	s.mu.Lock()
+	s.draining = true
+	listener := s.listener
+	srvr := s.srvr
+	s.mu.Unlock()
 
 	s.log.Infof("\tStopping server listener...")
-	s.listener.Stop()
-	s.srvr.SetKeepAlivesEnabled(false)
-
-	s.log.Infof("\tStopping workers...")
-	close(s.jobq)
-	s.wg.Wait()
-
-	s.running = false
-	s.jobq = nil
-	s.listener = nil
-	s.mu.Unlock()
+	listener.Stop()
+	s.stopSocket()
+	s.closeStreams()
 
-	// Sleep a bit to allow all connections to be released.
+	// Allow in-flight requests to finish.
 	var maxTimeout time.Duration
 	if TCPReadTimeout > TCPWriteTimeout {
 		maxTimeout = TCPReadTimeout
@@ -176,20 +403,60 @@ func (s *Server) Shutdown() bool {
 		maxTimeout = TCPWriteTimeout
 	}
 	maxTimeout = maxTimeout + (1 * time.Second)
-	s.log.Infof("\tAllowing all connections to close for %s...", maxTimeout.String())
-	time.Sleep(maxTimeout)
+	s.log.Infof("\tDraining connections for up to %s...", maxTimeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), maxTimeout)
+	if err := srvr.Shutdown(ctx); err != nil {
+		s.log.Warningf("\tConnections did not drain: %s", err)
+	}
+	cancel()
+	s.mu.Lock()
+	handover := s.handover
+	s.mu.Unlock()
+	if handover {
+		s.log.Infof("\tStatistics were saved for the new process.")
+	} else if err := s.saveStats(); err != nil {
+		s.log.Errorf("\tCannot save statistics to %s: %s", s.opts.StatsFile, err)
+	}
+
+	s.mu.Lock()
+	s.log.Infof("\tStopping workers...")
+	s.jobq.close()
+	s.pool.Wait()
+
+	s.running = false
+	s.draining = false
+	s.handover = false
+	s.jobq = nil
+	s.pool = nil
+	s.listener = nil
+	s.srvr = newHTTPServer(srvr.Addr, srvr.Handler) // A shut down http.Server cannot serve again.
+	close(s.doneCh)
+	s.mu.Unlock()
 
+	if err := s.tracer.Flush(); err != nil {
+		s.log.Errorf("\tCannot export spans: %s", err)
+	}
 	s.log.Infof("END server service stop.")
 	return true
 }
 
-// handleSignals responds to operating system interrupts such as application kills.
+// handleSignals responds to operating system interrupts such as application kills. SIGUSR2
+// requests a zero-downtime restart onto a new copy of the server binary.
 func (s *Server) handleSignals() {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR2)
 	go func() {
 		for sig := range c {
 			s.log.Infof("Server received signal: %v\n", sig)
+			if sig == syscall.SIGUSR2 {
+				if err := s.saveStats(); err != nil { // For the new process to restore.
+					s.log.Errorf("Cannot save statistics to %s: %s\n", s.opts.StatsFile, err)
+				}
+				if err := s.Restart(); err != nil {
+					s.log.Errorf("Restart failed, continuing to serve: %s\n", err)
+					continue
+				}
+			}
 			s.Shutdown()
 			s.log.Infof("Server exiting.")
 			os.Exit(0)
@@ -210,31 +477,78 @@ func (s *Server) parseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Read the json in for the request.
-	var data map[string]interface{}
-	b, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, InvalidBody, http.StatusBadRequest)
+	d, ok := s.readParseRequest(w, r)
+	if !ok {
 		return
 	}
-	if err := json.Unmarshal(b, &data); err != nil {
-		http.Error(w, InvalidJSONText, http.StatusBadRequest)
+
+	// Send a parse request to a parse worker and wait for it to complete.
+	ctx, cancel := s.parseContext(r.Context())
+	defer cancel()
+	job := parseJobNew(ctx, d, func(j *parseJob) {
+		j.Binary = r.Header.Get("Accept") == MediaTypeBinary
+		j.Priority = s.priority(len(d), s.identity(r))
+		j.route = httpRouteParseV1
+	})
+	if err := s.runJob(job); err != nil {
+		s.parseError(w, err)
 		return
 	}
-	d, ok := data["text"].(string)
-	if !ok {
-		http.Error(w, InvalidJSONAttribute, http.StatusBadRequest)
+	if job.Binary {
+		w.Header().Set("Content-Type", MediaTypeBinary)
+		w.Write([]byte(job.Result))
 		return
 	}
+	w.Write([]byte(fmt.Sprintf(`{"result":%s}`, job.Result)))
+}
 
-	// Send a parse request to a parse worker and wait for it to complete.
-	job := parseJob{
-		Source: d,
-		DoneCh: make(chan bool),
+// readParseRequest returns the text to parse from the json or binary encoded body of the request.
+// If the body is invalid, an error is returned to the client and ok is false.
+func (s *Server) readParseRequest(w http.ResponseWriter, r *http.Request) (text string, ok bool) {
+	_, span := tracing.Start(r.Context(), "read_body")
+	defer span.End()
+	b, err := ioutil.ReadAll(r.Body)
+	span.SetAttribute("body.bytes", len(b))
+	if err != nil {
+		span.SetError(err)
+		if err == ErrQuotaExceeded {
+			quotaExceeded(w)
+			return "", false
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, APIErrorNew(CodeDocumentTooLarge, DocumentTooLarge))
+			return "", false
+		}
+		writeError(w, http.StatusBadRequest, APIErrorNew(CodeInvalidBody, InvalidBody))
+		return "", false
 	}
-	s.jobq <- &job
-	<-job.DoneCh
-	w.Write([]byte(fmt.Sprintf(`{"result":%s}`, job.Result)))
+	if r.Header.Get("Content-Type") == MediaTypeBinary {
+		t, err := parser.UnmarshalRequest(b)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, APIErrorNew(CodeInvalidBinary, InvalidBinaryBody))
+			return "", false
+		}
+		return string(t), true
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		writeError(w, http.StatusBadRequest, APIErrorNew(CodeInvalidJSON, InvalidJSONText))
+		return "", false
+	}
+	v, found := data["text"]
+	if !found {
+		writeError(w, http.StatusBadRequest,
+			APIErrorNew(CodeMissingField, InvalidJSONAttribute, withField("text")))
+		return "", false
+	}
+	if text, ok = v.(string); !ok {
+		writeError(w, http.StatusBadRequest,
+			APIErrorNew(CodeInvalidField, InvalidJSONType, withField("text")))
+		return "", false
+	}
+	return text, true
 }
 
 // statusHandler handles a client request for server information and statistics.
@@ -243,12 +557,11 @@ func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	st := s.stats.Read(time.Now())
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	if s.listener != nil {
-		s.stats.ConnNumAvail = s.listener.GetConnNumAvail() // Get latest live connection count.
-	}
-	mStats := &runtime.MemStats{}
+	s.refreshStats(st)
+	s.mu.Unlock()
+	mStats := &runtime.MemStats{} // Read without the server lock as it stops the world.
 	runtime.ReadMemStats(mStats)
 	b, _ := json.Marshal(
 		&struct {
@@ -259,86 +572,279 @@ func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
 		}{
 			Info:    s.info,
 			Options: s.opts,
-			Stats:   s.stats,
+			Stats:   st,
 			Memory:  mStats,
 		})
 	w.Write(b)
 }
 
-// incrementStats increments the statistics for the request being handled by the server.
+// parseContext returns the context for a parse job, limited to the maximum parse time when
+// one is configured.
+func (s *Server) parseContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if s.opts.MaxParseTime > 0 {
+		return context.WithTimeout(parent, s.opts.MaxParseTime)
+	}
+	return context.WithCancel(parent)
+}
+
+// runJob sends a parse job to the workers and waits for it to complete. If the job's context is
+// cancelled or times out first, the job is abandoned and counted, and the error is returned.
+func (s *Server) runJob(job *parseJob) error {
+	err := s.awaitJob(job)
+	switch err {
+	case nil:
+		s.metrics.observeParse(job.parseTime)
+		if job.route != "" {
+			s.stats.IncrQueueWaitStats(job.route, job.waited, time.Now())
+		}
+	case context.DeadlineExceeded:
+		s.stats.IncrTimeoutStats()
+	case context.Canceled:
+		s.stats.IncrCancelledStats()
+	}
+	return err
+}
+
+// awaitJob queues a parse job for the workers and waits for it to complete or be abandoned.
+// The pool grows by a worker each grow wait the job spends queued, and jobs that wait longer
+// than the queue timeout for a worker are given up on.
+func (s *Server) awaitJob(job *parseJob) error {
+	start := time.Now()
+	if err := s.jobq.enqueue(job, s.opts.QueueTimeout); err != nil {
+		return err
+	}
+	grow := time.NewTicker(s.pool.growWait)
+	defer grow.Stop()
+	var expired <-chan time.Time
+	if s.opts.QueueTimeout > 0 {
+		timer := time.NewTimer(s.opts.QueueTimeout - time.Since(start))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case <-job.DoneCh:
+			return job.Err
+		case <-job.Ctx.Done():
+			job.abandon()
+			return job.Ctx.Err()
+		case <-grow.C:
+			if waited, ok := s.jobq.waiting(job); ok {
+				s.pool.grow(waited)
+			} else {
+				grow.Stop() // A worker has the job.
+			}
+		case <-expired:
+			if s.jobq.expire(job) {
+				return ErrQueueTimeout
+			}
+			expired = nil // A worker has the job; wait for it to finish.
+		}
+	}
+}
+
+// priority returns true if a parse job of size bytes for the identity should jump the queue.
+func (s *Server) priority(size int, id *auth.Identity) bool {
+	return (s.opts.PriorityBytes > 0 && size <= s.opts.PriorityBytes) || (id != nil && id.Premium)
+}
+
+// retryAfter returns the seconds a client should wait before retrying a busy server.
+func (s *Server) retryAfter() int {
+	if sec := int(math.Ceil(s.opts.QueueTimeout.Seconds())); sec > 1 {
+		return sec
+	}
+	return 1
+}
+
+// parseError returns an error to the client for a parse job that did not complete.
+func (s *Server) parseError(w http.ResponseWriter, err error) {
+	status, e := jobError(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(s.retryAfter()))
+	}
+	writeError(w, status, e)
+}
+
+// jobError returns the status code and error of the API for a parse job that did not complete.
+func jobError(err error) (int, *APIError) {
+	switch err {
+	case ErrQueueFull, ErrQueueTimeout:
+		return http.StatusServiceUnavailable, APIErrorNew(CodeServerBusy, ServerBusy)
+	case context.DeadlineExceeded:
+		return http.StatusGatewayTimeout, APIErrorNew(CodeParseTimeout, ParseTimeout)
+	}
+	return StatusClientClosedRequest, APIErrorNew(CodeParseCancelled, ParseCancelled)
+}
+
+// incrementStats increments the statistics for the request being handled by the server. Paths
+// that are not routes of the server are all counted as "other".
 func (s *Server) incrementStats(r *http.Request) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.stats.IncrRequestStats(r.ContentLength)
-	s.stats.IncrRouteStats(r.URL.Path, r.ContentLength)
+	s.incrementRouteStats(metricsRoute(r.URL.Path), r.ContentLength)
+}
+
+// incrementRouteStats increments the statistics for a request of rb bytes on a route.
+func (s *Server) incrementRouteStats(path string, rb int64) {
+	s.stats.IncrRequestStats(rb)
+	s.stats.IncrRouteStats(path, rb)
+}
+
+// incrementResponseStats records the response to a request on a route: its status code, its
+// size wb in bytes and the time d taken to answer.
+func (s *Server) incrementResponseStats(path string, code int, wb int64, d time.Duration) {
+	s.stats.IncrResponseStats(path, code, wb, d, time.Now())
 }
 
 // initResponseHeader sets up the common http response headers for the return of all json calls.
-func (s *Server) initResponseHeader(w http.ResponseWriter) {
+// The request ID and trace context are those the middleware put in the request context.
+func (s *Server) initResponseHeader(w http.ResponseWriter, r *http.Request) {
 	h := w.Header()
 	h.Add("Content-Type", "application/json;charset=utf-8")
 	h.Add("Date", time.Now().UTC().Format(time.RFC1123Z))
 	if s.info.Name != "" {
 		h.Add("Server", s.info.Name)
 	}
-	h.Add("X-Request-ID", createV4UUID())
+	rc, ok := requestFromContext(r.Context())
+	if !ok {
+		h.Add("X-Request-ID", createV4UUID())
+		return
+	}
+	h.Add("X-Request-ID", rc.id)
+	h.Add("traceparent", rc.trace.traceparent())
+	if rc.trace.state != "" {
+		h.Add("tracestate", rc.trace.state)
+	}
 }
 
 // invalidHeader validates that the header information is acceptable for processing the
 // request from the client.
 func (s *Server) invalidHeader(w http.ResponseWriter, r *http.Request) bool {
-	if r.Header.Get("Content-Type") != "application/json" ||
-		r.Header.Get("Accept") != "application/json" {
-		http.Error(w, InvalidMediaType, http.StatusUnsupportedMediaType)
+	if r.URL.Path == httpRouteStreamV1 && websocket.IsUpgrade(r) {
+		return false // Websocket handshakes carry no media.
+	}
+	if r.URL.Path == httpRouteMetrics {
+		return false // Scrapers ask for their own media types.
+	}
+	if !acceptedContentType(r.URL.Path, r.Header.Get("Content-Type")) ||
+		!acceptedAccept(r.URL.Path, r.Header.Get("Accept")) {
+		writeError(w, http.StatusUnsupportedMediaType, APIErrorNew(CodeInvalidMediaType, InvalidMediaType))
 		return true
 	}
 	return false
 }
 
+// acceptedContentType returns true if the request media type can be used on the route. The
+// binary encoding is only available for parse requests.
+func acceptedContentType(path string, mt string) bool {
+	return mt == mediaTypeJSON ||
+		(mt == MediaTypeBinary && (path == httpRouteParseV1 || path == httpRouteEventsV1))
+}
+
+// acceptedAccept returns true if the response media type can be returned by the route. Parse
+// progress is only returned as an event stream.
+func acceptedAccept(path string, mt string) bool {
+	if path == httpRouteEventsV1 {
+		return mt == mediaTypeEventStream
+	}
+	return mt == mediaTypeJSON || (mt == MediaTypeBinary && path == httpRouteParseV1)
+}
+
 // invalidAuth validates that the Authorization token is valid for using the API
 func (s *Server) invalidAuth(w http.ResponseWriter, r *http.Request) bool {
-	if !s.auth.Valid(strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", -1)) {
-		http.Error(w, InvalidAuthorization, http.StatusUnauthorized)
+	if s.identity(r) == nil {
+		writeError(w, http.StatusUnauthorized, APIErrorNew(CodeUnauthorized, InvalidAuthorization))
 		return true
 	}
 	return false
 }
 
-// invalidMethod validates that the http method is acceptable for processing this route.
-func (s *Server) invalidMethod(w http.ResponseWriter, r *http.Request, method string) bool {
-	if r.Method != method {
-		http.Error(w, InvalidMethod, http.StatusMethodNotAllowed)
+// invalidScope validates that the Authorization token may be used for the scope of the route.
+// A missing scope is answered with a 403 and an error naming the scope.
+func (s *Server) invalidScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	if id := s.identity(r); scope == "" || (id != nil && id.HasScope(scope)) {
+		return false
+	}
+	writeError(w, http.StatusForbidden, APIErrorNew(CodeInsufficientScope, InvalidScope, func(e *APIError) {
+		e.Scope = scope
+	}))
+	return true
+}
+
+// routeScope returns the scope a token needs to use the route, or "" if any valid token may.
+func routeScope(path string) string {
+	switch {
+	case path == httpRouteParseV1 || path == httpRouteStreamV1 || path == httpRouteEventsV1:
+		return auth.ScopeParse
+	case path == httpRouteStatusV1 || path == httpRouteHistoryV1 || path == httpRouteMetrics:
+		return auth.ScopeStatus
+	case path == httpRouteResetV1:
+		return auth.ScopeAdmin
+	case path == httpRouteAdminTokensV1 || strings.HasPrefix(path, httpRouteAdminTokensV1+"/"):
+		return auth.ScopeAdmin
+	}
+	return ""
+}
+
+// withIdentity returns the request with the identity of its Authorization in the context, if it
+// is valid: a bearer token or, if enabled, a signed request. An error is returned if the body of
+// a signed request cannot be read.
+func (s *Server) withIdentity(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	var id *auth.Identity
+	if s.hmac != nil && auth.IsHMACAuthorization(r.Header.Get("Authorization")) {
+		if s.opts.HMACMaxBody > 0 { // Read before the request is authorized, so capped.
+			r.Body = http.MaxBytesReader(w, r.Body, s.opts.HMACMaxBody)
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return r, err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(body)) // Put the body back for the handlers.
+		if id, err = s.hmac.Verify(r, body, time.Now()); err != nil {
+			s.log.Debugf("%sSigned request refused: %s", logPrefix(r.Context()), err)
+			return r, nil
+		}
+	} else if id, _ = s.auth.Identify(bearerToken(r)); id == nil {
+		return r, nil
+	}
+	return r.WithContext(auth.NewContext(r.Context(), id)), nil // For the handlers, logs and limits.
+}
+
+// invalidBody returns an error to the client if the body of the request could not be read
+// before the request was handled, such as a signed request body over the maximum size.
+func (s *Server) invalidBody(w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return false
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, APIErrorNew(CodeBodyTooLarge, BodyTooLarge))
 		return true
 	}
-	return false
+	writeError(w, http.StatusBadRequest, APIErrorNew(CodeInvalidBody, InvalidBody))
+	return true
 }
 
-// LogRequest logs the http request information into the logger.
-func (s *Server) LogRequest(r *http.Request) {
-	var cl int64
-	if r.ContentLength > 0 {
-		cl = r.ContentLength
+// identity returns the identity of the Authorization token of a request, or nil if the token
+// is invalid. The middleware puts it in the request context.
+func (s *Server) identity(r *http.Request) *auth.Identity {
+	if id, ok := auth.FromContext(r.Context()); ok {
+		return id
 	}
+	id, _ := s.auth.Identify(bearerToken(r))
+	return id
+}
 
-	bd, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		bd = []byte("Could not parse body")
-	}
-	r.Body = ioutil.NopCloser(bytes.NewBuffer(bd)) // We need to set the body back after we read it.
-
-	b, _ := json.Marshal(&requestLogEntry{
-		Method:        r.Method,
-		URL:           r.URL,
-		Proto:         r.Proto,
-		Header:        r.Header,
-		Body:          string(bd),
-		ContentLength: cl,
-		Host:          r.Host,
-		RemoteAddr:    r.RemoteAddr,
-		RequestURI:    r.RequestURI,
-		Trailer:       r.Trailer,
-	})
-	s.log.Infof(`{"request":%s}`, string(b))
+// bearerToken returns the token from the Authorization header of a request.
+func bearerToken(r *http.Request) string {
+	return strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", -1)
+}
+
+// invalidMethod validates that the http method is acceptable for processing this route.
+func (s *Server) invalidMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, APIErrorNew(CodeMethodNotAllowed, InvalidMethod))
+		return true
+	}
+	return false
 }
 
 // isRunning returns a boolean representing whether the server is running or not.
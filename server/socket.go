@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/composer22/clidemo/auth"
+	"github.com/composer22/clidemo/tracing"
+	"github.com/composer22/clidemo/wire"
+)
+
+// StartSocket opens the raw socket protocol port and serves it in the background. Connections
+// share the connection throttle, auth tokens, workers and statistics with the HTTP API.
+// See package wire for the protocol. The listener handed over by a graceful restart is used if
+// there is one.
+func (s *Server) StartSocket() error {
+	ln, err := inheritedListener(envSockFD)
+	if err == nil && ln == nil {
+		ln, err = net.Listen("tcp", fmt.Sprintf("%s:%d", s.info.Hostname, s.info.SockPort))
+	}
+	if err != nil {
+		return err
+	}
+	s.log.Infof("Starting socket protocol on tcp port %d", s.info.SockPort)
+	s.sockListener = s.listener.Share(ln, func(t *ThrottledListener) {
+		t.Reject = rejectSocket
+	})
+	s.sockConns = make(map[net.Conn]bool)
+	s.sockWg.Add(1)
+	go func(ln *ThrottledListener) {
+		defer s.sockWg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if err != StoppedError {
+					s.log.Errorf("Socket protocol listener stopped: %s", err)
+				}
+				return
+			}
+			s.sockWg.Add(1)
+			go s.serveSocket(conn)
+		}
+	}(s.sockListener)
+	return nil
+}
+
+// stopSocket stops accepting socket connections and waits for open ones to finish their
+// current request.
+func (s *Server) stopSocket() {
+	if s.sockListener == nil {
+		return
+	}
+	s.sockListener.Stop()
+	s.sockMu.Lock()
+	s.sockStopping = true
+	for conn := range s.sockConns {
+		conn.SetReadDeadline(time.Now()) // Unblock connections waiting for a request.
+	}
+	s.sockMu.Unlock()
+	s.sockWg.Wait()
+	s.sockListener = nil
+	s.sockStopping = false
+}
+
+// serveSocket handles the requests of one socket protocol connection until the client closes it.
+// Parse jobs run in a context of the connection, cancelled when the client goes away.
+func (s *Server) serveSocket(conn net.Conn) {
+	defer s.sockWg.Done()
+	defer conn.Close()
+	connCtx, cancelConn := context.WithCancel(context.Background())
+	defer cancelConn()
+	br := bufio.NewReader(conn)
+	s.sockMu.Lock()
+	s.sockConns[conn] = true
+	s.sockMu.Unlock()
+	defer func() {
+		s.sockMu.Lock()
+		delete(s.sockConns, conn)
+		s.sockMu.Unlock()
+	}()
+
+	authorized, token := false, ""
+	for s.awaitSocketRequest(conn) {
+		f, err := wire.ReadFrame(br, wire.DefaultMaxFrameSize)
+		if err != nil {
+			if err == wire.ErrFrameTooLarge {
+				wire.WriteFrame(conn, wire.TypeError, []byte(InvalidFrameSize))
+			}
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(TCPWriteTimeout))
+
+		switch f.Type {
+		case wire.TypeAuth:
+			s.incrementRouteStats(sockRouteAuth, int64(len(f.Payload)))
+			token = string(f.Payload)
+			authorized = s.auth.Valid(token)
+			if !authorized {
+				wire.WriteFrame(conn, wire.TypeError, []byte(InvalidAuthorization))
+				return
+			}
+			wire.WriteFrame(conn, wire.TypeOK, nil)
+		case wire.TypeParse:
+			start := time.Now()
+			s.incrementRouteStats(sockRouteParse, int64(len(f.Payload)))
+			// reply answers the request, and records the response with the status code the same
+			// answer gets over http.
+			reply := func(code int, typ byte, payload string) {
+				wire.WriteFrame(conn, typ, []byte(payload))
+				s.incrementResponseStats(sockRouteParse, code, int64(len(payload)), time.Since(start))
+			}
+			if !authorized {
+				reply(http.StatusUnauthorized, wire.TypeError, InvalidAuthorization)
+				return
+			}
+			id, ok := s.auth.Identify(token) // Again, in case the token expired or was revoked.
+			if !ok {
+				reply(http.StatusUnauthorized, wire.TypeError, InvalidAuthorization)
+				return
+			}
+			if !id.HasScope(auth.ScopeParse) {
+				reply(http.StatusForbidden, wire.TypeError, InvalidScope)
+				continue
+			}
+			key := limitKey(id, token)
+			if _, err := s.limits.allow(key, id.Limits, true, int64(len(f.Payload)), time.Now()); err != nil {
+				code, e := limitError(err)
+				reply(code, wire.TypeError, e.Message)
+				continue
+			}
+			s.limits.addBytes(key, id.Limits, int64(len(f.Payload)), time.Now())
+			ctx, cancel := s.parseContext(s.withNewRequestID(connCtx, sockRouteParse))
+			job := parseJobNew(ctx, string(f.Payload), func(j *parseJob) {
+				j.Priority = s.priority(len(f.Payload), id)
+				j.route = sockRouteParse
+			})
+			stopWatch := watchSocket(conn, br, cancelConn)
+			err := s.runJob(job)
+			stopWatch()
+			cancel()
+			span := tracing.FromContext(ctx)
+			span.SetError(err)
+			span.End()
+			conn.SetWriteDeadline(time.Now().Add(TCPWriteTimeout))
+			if err != nil {
+				code, e := jobError(err)
+				reply(code, wire.TypeError, e.Message)
+				continue
+			}
+			reply(http.StatusOK, wire.TypeResult, job.Result)
+		default:
+			wire.WriteFrame(conn, wire.TypeError, []byte(InvalidFrameType))
+			return
+		}
+	}
+}
+
+// watchSocket cancels the context of a connection if the client goes away while a request
+// runs. Frames the client sends meanwhile stay buffered in br. The function returned stops
+// watching, after which br may be read again.
+func watchSocket(conn net.Conn, br *bufio.Reader, cancel context.CancelFunc) func() {
+	conn.SetReadDeadline(time.Time{})
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		if _, err := br.Peek(1); err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				cancel()
+			}
+		}
+	}()
+	return func() {
+		conn.SetReadDeadline(time.Now()) // Unblock the watch.
+		<-done
+	}
+}
+
+// rejectSocket answers a socket protocol connection that got no connection token and closes it.
+func rejectSocket(conn net.Conn) {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	wire.WriteFrame(conn, wire.TypeError, []byte(ServerBusy))
+	conn.Close()
+}
+
+// awaitSocketRequest sets the idle timeout for the next request on conn. It returns false if the
+// socket port is stopping and the connection should be closed instead.
+func (s *Server) awaitSocketRequest(conn net.Conn) bool {
+	s.sockMu.Lock()
+	defer s.sockMu.Unlock()
+	if s.sockStopping {
+		return false
+	}
+	conn.SetReadDeadline(time.Now().Add(TCPKeepAliveTimeout))
+	return true
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/composer22/clidemo/client"
+	"github.com/composer22/clidemo/wire"
+)
+
+const (
+	testSockAddr = "localhost:8091"
+)
+
+func TestSocketProtocol(t *testing.T) {
+	s := New(&Options{
+		Hostname:   "localhost",
+		Port:       8090,
+		SockPort:   8091,
+		MaxConn:    10,
+		MaxWorkers: 2,
+	})
+	go s.Start()
+	for i := 0; i < 100 && !s.isRunning(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer s.Shutdown()
+
+	if _, err := client.Dial(testSockAddr, "BADTOKEN"); err == nil || err.Error() != InvalidAuthorization {
+		t.Errorf("Invalid token should have been refused: %v", err)
+	}
+
+	c, err := client.Dial(testSockAddr, "3A3E6C4C51F12DF2415682CCF9D18")
+	if err != nil {
+		t.Fatalf("Could not connect to socket port: %s", err)
+	}
+	defer c.Close()
+	for i := 0; i < 2; i++ { // Connections serve more than one request.
+		result, err := c.Parse(workerParseTestText)
+		if err != nil {
+			t.Fatalf("Socket parse failed: %s", err)
+		}
+		if result != expectedWorkerJSONResult {
+			t.Errorf("Socket parse returned invalid results: %s", result)
+		}
+	}
+
+	// Parsing requires authorization first.
+	conn, err := net.Dial("tcp", testSockAddr)
+	if err != nil {
+		t.Fatalf("Could not connect to socket port: %s", err)
+	}
+	defer conn.Close()
+	wire.WriteFrame(conn, wire.TypeParse, []byte(workerParseTestText))
+	f, err := wire.ReadFrame(conn, wire.DefaultMaxFrameSize)
+	if err != nil || f.Type != wire.TypeError || string(f.Payload) != InvalidAuthorization {
+		t.Errorf("Unauthorized parse should have been refused.")
+	}
+
+	st := s.stats.Read(time.Now())
+	if rs := st.RouteStats[sockRouteParse]; rs["requestCount"] != 3 {
+		t.Errorf("Socket parse requests not counted: %d", rs["requestCount"])
+	}
+	if codes := st.Latency[sockRouteParse].StatusCodes; codes["200"] != 2 || codes["401"] != 1 {
+		t.Errorf("Socket parse responses, refused ones too, should be counted: %v", codes)
+	}
+}
+
+func TestWatchSocket(t *testing.T) {
+	t.Parallel()
+	client, conn := net.Pipe()
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Frames sent while a request runs are kept for the next read.
+	stop := watchSocket(conn, br, cancel)
+	wire.WriteFrame(client, wire.TypeParse, []byte("next"))
+	stop()
+	if f, err := wire.ReadFrame(br, wire.DefaultMaxFrameSize); err != nil || string(f.Payload) != "next" ||
+		ctx.Err() != nil {
+		t.Errorf("Frame sent while watching should be read next: %v %v", err, ctx.Err())
+	}
+
+	// A client that goes away cancels its requests.
+	stop = watchSocket(conn, br, cancel)
+	client.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Errorf("Closed connection should cancel its requests.")
+	}
+	stop()
+}
@@ -2,16 +2,86 @@ package server
 
 import (
 	"encoding/json"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Status contains runtime statistics.
+// Status contains runtime statistics. The counters are recorded with atomic operations, and the
+// response statistics of each route in shards, so that recording never waits on a reader; Read
+// returns a copy with the exported fields filled in. Reset and Restore swap in a new generation
+// of counters, so each increment is counted wholly before or after them.
 type Status struct {
-	Start        time.Time                   `json:"startTime"`    // The start time of the server.
-	RequestCount int64                       `json:"requestCount"` // How many requests came in to the server.
-	RequestBytes int64                       `json:"requestBytes"` // Size of the requests in bytes.
-	ConnNumAvail int                         `json:"connNumAvail"` // Number of live connections available.
-	RouteStats   map[string]map[string]int64 `json:"routeStats"`   // How many requests/bytes came into each route.
+	Start        time.Time                   `json:"startTime"`         // The start time of the server.
+	RequestCount int64                       `json:"requestCount"`      // How many requests came in to the server.
+	RequestBytes int64                       `json:"requestBytes"`      // Size of the requests in bytes.
+	Cancelled    int64                       `json:"cancelledCount"`    // Parse jobs abandoned by the client.
+	TimedOut     int64                       `json:"timeoutCount"`      // Parse jobs that took too long.
+	ConnNumAvail int                         `json:"connNumAvail"`      // Number of live connections available.
+	ConnRejected int64                       `json:"connRejected"`      // Connections answered busy at the limit.
+	ConnTimedOut int64                       `json:"connTimedOut"`      // Connections that waited too long at the limit.
+	ConnQueued   int64                       `json:"connQueued"`        // Connections that waited at the limit.
+	ConnWaiting  int64                       `json:"connWaiting"`       // Connections waiting at the limit now.
+	IPGuard      *IPGuardStats               `json:"ipGuard,omitempty"` // Connections refused per client address.
+	Tokens       map[string]TokenUsage       `json:"tokens,omitempty"`  // Usage of each auth token seen.
+	Queue        QueueStats                  `json:"queue"`             // Parse jobs waiting for a worker.
+	Workers      WorkerStats                 `json:"workers"`           // Workers parsing the jobs.
+	RouteStats   map[string]map[string]int64 `json:"routeStats"`        // How many requests/bytes came into each route.
+	Latency      map[string]RouteLatency     `json:"latency,omitempty"` // Response statistics of each route.
+
+	Since time.Time `json:"countersSince,omitzero"` // When the counters started, if reset or restored.
+
+	mu       sync.Mutex                    // For locking access to Start.
+	counters atomic.Pointer[statsCounters] // The current generation of counters.
+}
+
+// statsCounters are the counters of the statistics since a time.
+type statsCounters struct {
+	since        time.Time // Zero for the counters since the server started.
+	requestCount atomic.Int64
+	requestBytes atomic.Int64
+	cancelled    atomic.Int64
+	timedOut     atomic.Int64
+	routes       sync.Map // Statistics of each route by path, as *routeStats.
+}
+
+// latencyShards is the number of shards the response statistics of a route are recorded in, so
+// that concurrent responses rarely wait for each other.
+const latencyShards = 8
+
+// routeStats holds the statistics of a route as they are recorded.
+type routeStats struct {
+	requestCount atomic.Int64
+	requestBytes atomic.Int64
+	next         atomic.Uint32 // Shard of the next response.
+	shards       [latencyShards]struct {
+		mu sync.Mutex
+		rl routeLatency
+	}
+}
+
+// record calls f with the response statistics of the next shard, locked.
+func (rs *routeStats) record(f func(rl *routeLatency)) {
+	sh := &rs.shards[rs.next.Add(1)%latencyShards]
+	sh.mu.Lock()
+	f(&sh.rl)
+	sh.mu.Unlock()
+}
+
+// latency returns the response statistics of all the shards at time now. It returns false if no
+// responses were recorded.
+func (rs *routeStats) latency(now time.Time) (RouteLatency, bool) {
+	var rl routeLatency
+	for i := range rs.shards {
+		sh := &rs.shards[i]
+		sh.mu.Lock()
+		rl.merge(&sh.rl)
+		sh.mu.Unlock()
+	}
+	if rl.duration.total.count == 0 && rl.queueWait.total.count == 0 {
+		return RouteLatency{}, false
+	}
+	return rl.summary(now), true
 }
 
 // StatusNew is a factory function that returns a new instance of Status.
@@ -22,30 +92,165 @@ func StatusNew(options ...func(*Status)) *Status {
 		ConnNumAvail: -1, // defaults to infinite.
 		RouteStats:   make(map[string]map[string]int64),
 	}
+	st.counters.Store(&statsCounters{})
 	for _, f := range options {
 		f(st)
 	}
 	return st
 }
 
+// Started records the start time of the server.
+func (s *Status) Started(t time.Time) {
+	s.mu.Lock()
+	s.Start = t
+	s.mu.Unlock()
+}
+
 // IncrRequestStats increments the stats totals for the server.
 func (s *Status) IncrRequestStats(rb int64) {
-	s.RequestCount++
+	c := s.counters.Load()
+	c.requestCount.Add(1)
 	if rb > 0 {
-		s.RequestBytes += rb
+		c.requestBytes.Add(rb)
+	}
+}
+
+// IncrCancelledStats increments the count of parse jobs abandoned by the client.
+func (s *Status) IncrCancelledStats() {
+	s.counters.Load().cancelled.Add(1)
+}
+
+// IncrTimeoutStats increments the count of parse jobs that took too long.
+func (s *Status) IncrTimeoutStats() {
+	s.counters.Load().timedOut.Add(1)
+}
+
+// route returns the statistics of a route in the current counters, creating them if needed.
+func (s *Status) route(path string) *routeStats {
+	return s.counters.Load().route(path)
+}
+
+// route returns the statistics of a route, creating them if needed.
+func (c *statsCounters) route(path string) *routeStats {
+	if rs, ok := c.routes.Load(path); ok {
+		return rs.(*routeStats)
 	}
+	rs, _ := c.routes.LoadOrStore(path, &routeStats{})
+	return rs.(*routeStats)
 }
 
 // IncrRouteStats increments the stats totals for the route.
 func (s *Status) IncrRouteStats(path string, rb int64) {
-	if _, ok := s.RouteStats[path]; !ok {
-		s.RouteStats[path] = make(map[string]int64)
+	rs := s.route(path)
+	rs.requestCount.Add(1)
+	if rb > 0 {
+		rs.requestBytes.Add(rb)
 	}
+}
 
-	s.RouteStats[path]["requestCount"]++
-	if rb > 0 {
-		s.RouteStats[path]["requestBytes"] += rb
+// IncrResponseStats records a response on a route at time now: its status code, its size wb in
+// bytes and the time d taken to answer.
+func (s *Status) IncrResponseStats(path string, code int, wb int64, d time.Duration, now time.Time) {
+	s.route(path).record(func(rl *routeLatency) {
+		rl.observeResponse(code, wb, d, now)
+	})
+}
+
+// IncrQueueWaitStats records the time d a parse job on a route waited for a worker at time now.
+func (s *Status) IncrQueueWaitStats(path string, d time.Duration, now time.Time) {
+	s.route(path).record(func(rl *routeLatency) {
+		rl.queueWait.observe(durationMs(d), now)
+	})
+}
+
+// Read returns a copy of the statistics with the counters recorded so far, and the response
+// statistics of the routes at time now. The statistics kept by the listener, queue and workers
+// are left for the caller to fill in.
+func (s *Status) Read(now time.Time) *Status {
+	s.mu.Lock()
+	start := s.Start
+	s.mu.Unlock()
+	c := s.counters.Load()
+	st := &Status{
+		Start:        start,
+		Since:        c.since,
+		RequestCount: c.requestCount.Load(),
+		RequestBytes: c.requestBytes.Load(),
+		Cancelled:    c.cancelled.Load(),
+		TimedOut:     c.timedOut.Load(),
+		ConnNumAvail: s.ConnNumAvail,
+		RouteStats:   make(map[string]map[string]int64),
+	}
+	c.routes.Range(func(k, v interface{}) bool {
+		path, rs := k.(string), v.(*routeStats)
+		if n := rs.requestCount.Load(); n > 0 {
+			st.RouteStats[path] = map[string]int64{"requestCount": n}
+			if rb := rs.requestBytes.Load(); rb > 0 {
+				st.RouteStats[path]["requestBytes"] = rb
+			}
+		}
+		if rl, ok := rs.latency(now); ok {
+			if st.Latency == nil {
+				st.Latency = make(map[string]RouteLatency)
+			}
+			st.Latency[path] = rl
+		}
+		return true
+	})
+	return st
+}
+
+// Snapshot returns the counters of statistics returned by Read, taken at time now. Only the
+// counters of the routes of the server are kept.
+func (s *Status) Snapshot(now time.Time) StatsSnapshot {
+	rs := make(map[string]map[string]int64, len(s.RouteStats))
+	for path, st := range s.RouteStats {
+		if !statsRoute(path) {
+			continue
+		}
+		rs[path] = make(map[string]int64, len(st))
+		for k, v := range st {
+			rs[path][k] = v
+		}
+	}
+	return StatsSnapshot{
+		Time:         now,
+		RequestCount: s.RequestCount,
+		RequestBytes: s.RequestBytes,
+		Cancelled:    s.Cancelled,
+		TimedOut:     s.TimedOut,
+		ConnRejected: s.ConnRejected,
+		ConnTimedOut: s.ConnTimedOut,
+		ConnQueued:   s.ConnQueued,
+		Queue:        s.Queue,
+		Workers:      s.Workers,
+		RouteStats:   rs,
+	}
+}
+
+// Reset sets the counters of the statistics back to zero at time now. The connection, queue and
+// worker statistics are read from the listener, queue and workers, and are not reset.
+func (s *Status) Reset(now time.Time) {
+	s.counters.Store(&statsCounters{since: now})
+}
+
+// Restore sets the counters to those of a snapshot, counted since a time, such as when the
+// server restarts.
+func (s *Status) Restore(sn StatsSnapshot, since time.Time) {
+	c := &statsCounters{since: since}
+	c.requestCount.Store(sn.RequestCount)
+	c.requestBytes.Store(sn.RequestBytes)
+	c.cancelled.Store(sn.Cancelled)
+	c.timedOut.Store(sn.TimedOut)
+	for path, st := range sn.RouteStats {
+		if !statsRoute(path) {
+			continue
+		}
+		rs := c.route(path)
+		rs.requestCount.Store(st["requestCount"])
+		rs.requestBytes.Store(st["requestBytes"])
 	}
+	s.counters.Store(c)
 }
 
 // String is an implentation of the Stringer interface so the structure is returned as a
@@ -3,13 +3,19 @@ package server
 import (
 	"fmt"
 	"reflect"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 )
 
 const (
 	expectedStatsJSONResult = `{"startTime":"2006-01-02T13:24:56Z","requestCount":0,` +
-		`"requestBytes":0,"connNumAvail":1234,"routeStats":{"route1":{"requesBytes":202,` +
+		`"requestBytes":0,"cancelledCount":0,"timeoutCount":0,"connNumAvail":1234,` +
+		`"connRejected":0,"connTimedOut":0,"connQueued":0,"connWaiting":0,` +
+		`"queue":{"capacity":0,"depth":0,"highDepth":0,"enqueued":0,"rejected":0,"expired":0,` +
+		`"avgWaitMs":0,"maxWaitMs":0},"workers":{"min":0,"max":0,"active":0,"idle":0,"busy":0,` +
+		`"utilisation":0,"spawned":0,"retired":0},"routeStats":{"route1":{"requesBytes":202,` +
 		`"requestCounts":101},"route2":{"requesBytes":204,"requestCounts":103}}}`
 )
 
@@ -37,29 +43,46 @@ func TestStatusIncrRequestStats(t *testing.T) {
 	t.Parallel()
 	s := StatusNew()
 	s.IncrRequestStats(-1)
-	if s.RequestCount != 1 {
+	st := s.Read(time.Now())
+	if st.RequestCount != 1 {
 		t.Errorf("Status RequestCount not incremented correctly.")
 	}
-	if s.RequestBytes != 0 {
+	if st.RequestBytes != 0 {
 		t.Errorf("Status RequestBytes should not have been incremented or decremented.")
 	}
 
 	s.IncrRequestStats(101)
 	s.IncrRequestStats(99)
-	if s.RequestCount != 3 {
+	st = s.Read(time.Now())
+	if st.RequestCount != 3 {
 		t.Errorf("Status RequestCount not incremented correctly.")
 	}
-	if s.RequestBytes != 200 {
+	if st.RequestBytes != 200 {
 		t.Errorf("Status RequestBytes should have been incremented.")
 	}
 }
 
+func TestStatusIncrJobStats(t *testing.T) {
+	t.Parallel()
+	s := StatusNew()
+	s.IncrCancelledStats()
+	s.IncrTimeoutStats()
+	s.IncrTimeoutStats()
+	st := s.Read(time.Now())
+	if st.Cancelled != 1 {
+		t.Errorf("Status Cancelled not incremented correctly.")
+	}
+	if st.TimedOut != 2 {
+		t.Errorf("Status TimedOut not incremented correctly.")
+	}
+}
+
 func TestStatusIncrRouteStats(t *testing.T) {
 	t.Parallel()
 	s := StatusNew()
 	s.IncrRouteStats("Route1", -1)
 
-	rs, ok := s.RouteStats["Route1"]
+	rs, ok := s.Read(time.Now()).RouteStats["Route1"]
 	if !ok {
 		t.Errorf(`Status RouteStats["Route1"] entry not created correctly.`)
 	}
@@ -84,18 +107,52 @@ func TestStatusIncrRouteStats(t *testing.T) {
 	s.IncrRouteStats("Route2", -1)
 	s.IncrRouteStats("Route2", 201)
 	s.IncrRouteStats("Route2", 98)
-	if s.RouteStats["Route2"]["requestCount"] != 3 {
+	st := s.Read(time.Now())
+	if st.RouteStats["Route2"]["requestCount"] != 3 {
 		t.Errorf(`Status["Route2"]["requestCount"] not incremented correctly.`)
 	}
-	_, ok = s.RouteStats["Route2"]["requestBytes"]
+	_, ok = st.RouteStats["Route2"]["requestBytes"]
 	if !ok {
 		t.Errorf(`Status RouteStats["Route1"]["requestBytes"] entry should have been created.`)
 	}
-	if s.RouteStats["Route2"]["requestBytes"] != 299 {
+	if st.RouteStats["Route2"]["requestBytes"] != 299 {
 		t.Errorf(`Status["Route2"]["requestBytes"] not incremented correctly.`)
 	}
 }
 
+func TestStatusIncrResponseStats(t *testing.T) {
+	t.Parallel()
+	s := StatusNew()
+	now := time.Now()
+	if s.Read(now).Latency != nil {
+		t.Errorf("Status Latency should be empty before any response.")
+	}
+
+	s.IncrResponseStats("Route1", 200, 100, 10*time.Millisecond, now)
+	s.IncrResponseStats("Route1", 200, 300, 30*time.Millisecond, now)
+	s.IncrResponseStats("Route1", 429, 0, time.Millisecond, now)
+	s.IncrQueueWaitStats("Route1", 2*time.Millisecond, now)
+	rl, ok := s.Read(now).Latency["Route1"]
+	if !ok {
+		t.Fatalf(`Status Latency["Route1"] entry not created correctly.`)
+	}
+	if rl.StatusCodes["200"] != 2 || rl.StatusCodes["429"] != 1 {
+		t.Errorf(`Status Latency["Route1"] status codes not counted correctly: %v`, rl.StatusCodes)
+	}
+	if rl.ResponseBytes != 400 {
+		t.Errorf(`Status Latency["Route1"] response bytes not added correctly: %d`, rl.ResponseBytes)
+	}
+	if rl.DurationMs.Total.Count != 3 || rl.DurationMs.Total.Max != 30 || rl.DurationMs.Last1m.Count != 3 {
+		t.Errorf(`Status Latency["Route1"] durations not recorded correctly: %+v`, rl.DurationMs)
+	}
+	if rl.QueueWaitMs.Total.Count != 1 || rl.QueueWaitMs.Total.Max != 2 {
+		t.Errorf(`Status Latency["Route1"] queue wait not recorded correctly: %+v`, rl.QueueWaitMs)
+	}
+	if rl.ResponseSize.Total.Max != 300 {
+		t.Errorf(`Status Latency["Route1"] response size not recorded correctly: %+v`, rl.ResponseSize)
+	}
+}
+
 func TestStatusString(t *testing.T) {
 	t.Parallel()
 	mockTime, _ := time.Parse(time.RFC1123Z, "Mon, 02 Jan 2006 13:24:56 -0000")
@@ -113,3 +170,122 @@ func TestStatusString(t *testing.T) {
 			expectedStatsJSONResult, actual)
 	}
 }
+
+func TestStatusResetConcurrent(t *testing.T) {
+	t.Parallel()
+	st := StatusNew()
+	var wg sync.WaitGroup
+	stop := make(chan bool)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				st.IncrRequestStats(1)
+				st.IncrRouteStats(httpRouteParseV1, 1)
+				st.IncrResponseStats(httpRouteParseV1, 200, 1, time.Millisecond, time.Now())
+				st.IncrCancelledStats()
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		st.Reset(time.Now())
+		st.Restore(StatsSnapshot{RequestCount: 5}, time.Now())
+		st.Started(time.Now())
+		st.Read(time.Now())
+	}
+	close(stop)
+	wg.Wait()
+
+	// Counts after a reset are exact once it returns.
+	now := time.Now()
+	st.Reset(now)
+	for i := 0; i < 10; i++ {
+		st.IncrRequestStats(2)
+		st.IncrRouteStats(httpRouteParseV1, 2)
+	}
+	r := st.Read(now)
+	if r.RequestCount != 10 || r.RequestBytes != 20 || r.Cancelled != 0 || !r.Since.Equal(now) ||
+		r.RouteStats[httpRouteParseV1]["requestCount"] != 10 || len(r.Latency) != 0 {
+		t.Errorf("Counters after a reset should be exact: %+v", r)
+	}
+}
+
+// benchmarkStats measures recording the statistics of requests from many goroutines while
+// another goroutine reads them, as the status route does.
+func benchmarkStats(b *testing.B, record func(), read func()) {
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				read()
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			record()
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
+
+func BenchmarkStatsAtomic(b *testing.B) {
+	s := StatusNew()
+	benchmarkStats(b, func() {
+		s.IncrRequestStats(100)
+		s.IncrRouteStats(httpRouteParseV1, 100)
+		s.IncrResponseStats(httpRouteParseV1, 200, 500, time.Millisecond, time.Now())
+	}, func() {
+		s.Read(time.Now())
+		runtime.ReadMemStats(&runtime.MemStats{})
+	})
+}
+
+// BenchmarkStatsMutex is the baseline of counters behind one lock, held by the reader while it
+// reads the memory statistics, as the statistics were recorded before.
+func BenchmarkStatsMutex(b *testing.B) {
+	var mu sync.Mutex
+	st := StatusNew()
+	routes := make(map[string]*routeLatency)
+	benchmarkStats(b, func() {
+		mu.Lock()
+		st.RequestCount++
+		st.RequestBytes += 100
+		if _, ok := st.RouteStats[httpRouteParseV1]; !ok {
+			st.RouteStats[httpRouteParseV1] = make(map[string]int64)
+		}
+		st.RouteStats[httpRouteParseV1]["requestCount"]++
+		st.RouteStats[httpRouteParseV1]["requestBytes"] += 100
+		rl, ok := routes[httpRouteParseV1]
+		if !ok {
+			rl = &routeLatency{}
+			routes[httpRouteParseV1] = rl
+		}
+		rl.observeResponse(200, 500, time.Millisecond, time.Now())
+		mu.Unlock()
+	}, func() {
+		mu.Lock()
+		rl := routes[httpRouteParseV1]
+		if rl != nil {
+			rl.summary(time.Now())
+		}
+		runtime.ReadMemStats(&runtime.MemStats{})
+		mu.Unlock()
+	})
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/composer22/clidemo/parser"
+	"github.com/composer22/clidemo/websocket"
+)
+
+// streamHandler handles an interactive parse over a websocket. Each text or binary message from
+// the client continues the text and is answered with the words changed by it as a JSON delta.
+// An empty message marks the end of the text so any trailing word is counted. The text is
+// charged to the quota of the token as it arrives, and the stream is closed once the text
+// passes the document size of the token or the stream maximum.
+func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidMethod(w, r, httpGet) {
+		return
+	}
+	if !websocket.IsUpgrade(r) {
+		w.Header().Set("Upgrade", "websocket")
+		writeError(w, http.StatusUpgradeRequired, APIErrorNew(CodeUpgradeRequired, InvalidUpgrade))
+		return
+	}
+	ws, err := websocket.Upgrade(w, r)
+	switch err {
+	case nil:
+	case websocket.ErrBadHandshake:
+		writeError(w, http.StatusBadRequest, APIErrorNew(CodeInvalidHandshake, InvalidHandshake))
+		return
+	case websocket.ErrNotHijacker:
+		writeError(w, http.StatusInternalServerError, APIErrorNew(CodeStreamingUnsupported, InvalidStreaming))
+		return
+	default: // The connection was taken over and closed.
+		return
+	}
+	if !s.addStream(ws) {
+		ws.CloseWithCode(websocket.CloseGoingAway, "")
+		return
+	}
+	defer s.removeStream(ws)
+
+	key, l := s.tokenLimits(r)
+	max := s.opts.StreamMaxBytes
+	if l.MaxDocBytes > 0 && (max <= 0 || l.MaxDocBytes < max) {
+		max = l.MaxDocBytes
+	}
+	var total int64 // Text received.
+	p := parser.New()
+	for {
+		ws.SetReadDeadline(time.Now().Add(TCPKeepAliveTimeout))
+		_, msg, err := ws.ReadMessage()
+		switch err {
+		case nil:
+		case io.EOF: // Client closed.
+			return
+		case websocket.ErrMessageTooLarge:
+			ws.CloseWithCode(websocket.CloseTooLarge, err.Error())
+			return
+		case websocket.ErrInvalidUTF8:
+			ws.CloseWithCode(websocket.CloseInvalidData, err.Error())
+			return
+		case websocket.ErrProtocol:
+			ws.CloseWithCode(websocket.CloseProtocolError, err.Error())
+			return
+		default: // Idle timeout or a broken connection.
+			ws.CloseWithCode(websocket.CloseGoingAway, "")
+			return
+		}
+
+		s.incrementRouteStats(wsRouteChunk, int64(len(msg)))
+		if total += int64(len(msg)); max > 0 && total > max {
+			ws.CloseWithCode(websocket.CloseTooLarge, DocumentTooLarge)
+			return
+		}
+		if err := s.limits.charge(key, l, int64(len(msg)), time.Now()); err != nil {
+			ws.CloseWithCode(websocket.ClosePolicy, QuotaExceeded)
+			return
+		}
+		if len(msg) == 0 {
+			p.Flush()
+		} else {
+			p.Write(msg)
+		}
+		b, _ := json.Marshal(p.Delta())
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"delta":%s}`, b))); err != nil {
+			ws.CloseWithCode(websocket.CloseGoingAway, "")
+			return
+		}
+	}
+}
+
+// addStream records an open websocket so it can be closed on shutdown. It returns false if the
+// server is stopping.
+func (s *Server) addStream(ws *websocket.Conn) bool {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	if s.streams == nil {
+		return false
+	}
+	s.streams[ws] = true
+	return true
+}
+
+// removeStream forgets a closed websocket.
+func (s *Server) removeStream(ws *websocket.Conn) {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	delete(s.streams, ws)
+}
+
+// closeStreams closes all open websockets. Websockets are taken over from the HTTP server so
+// they are not closed by its shutdown.
+func (s *Server) closeStreams() {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	for ws := range s.streams {
+		ws.CloseWithCode(websocket.CloseGoingAway, "")
+	}
+	s.streams = nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/composer22/clidemo/auth"
+	"github.com/composer22/clidemo/logger"
+	"github.com/composer22/clidemo/websocket"
+)
+
+func TestStreamLimits(t *testing.T) {
+	t.Parallel()
+	s := &Server{
+		info:    &Info{},
+		opts:    &Options{StreamMaxBytes: 14},
+		auth:    auth.New(),
+		limits:  tokenLimiterNew(),
+		log:     logger.New(logger.UseDefault, false),
+		stats:   StatusNew(),
+		streams: make(map[*websocket.Conn]bool),
+	}
+	s.log.SetLogLevel(logger.Emergency)
+	s.auth.Limits = map[string]auth.Limits{
+		testQuotaToken:                  {DailyBytes: 8},
+		"3A3E6C4C51F12DF2415682CCF9D18": {MaxDocBytes: 100},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(s.streamHandler))
+	defer srv.Close()
+	u := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	// send writes chunks to a new stream, and returns the number answered before it was closed.
+	send := func(token string, chunks ...string) int {
+		ws, _, err := websocket.Dial(u, http.Header{"Authorization": {"Bearer " + token}})
+		if err != nil {
+			t.Fatalf("Websocket handshake failed: %s", err)
+		}
+		defer ws.Close()
+		for i, chunk := range chunks {
+			ws.WriteMessage(websocket.TextMessage, []byte(chunk))
+			if _, _, err := ws.ReadMessage(); err == io.EOF {
+				return i
+			} else if err != nil {
+				t.Fatalf("Websocket delta not received: %s", err)
+			}
+		}
+		return len(chunks)
+	}
+
+	// Chunks are charged to the quota of the token as they arrive.
+	if n := send(testQuotaToken, "Now is ", "the"); n != 1 {
+		t.Errorf("Stream should be closed over the quota: %d chunks answered", n)
+	}
+	if u := s.limits.Usage()["QUOTA6****"]; u.BytesToday != 7 || u.Limited != 1 {
+		t.Errorf("Stream text not charged to the quota: %+v", u)
+	}
+
+	// The text of a stream is capped at the stream maximum.
+	if n := send("3A3E6C4C51F12DF2415682CCF9D18", "Now is ", "the ", "time"); n != 2 {
+		t.Errorf("Stream should be closed over the maximum: %d chunks answered", n)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const (
+	// systemd socket activation protocol: see sd_listen_fds(3).
+	sdListenFDsStart = 3 // The first file descriptor passed in.
+	sdEnvListenPID   = "LISTEN_PID"
+	sdEnvListenFDs   = "LISTEN_FDS"
+	sdEnvListenNames = "LISTEN_FDNAMES"
+)
+
+// SystemdListeners returns the listening sockets passed to this process by systemd socket
+// activation. An empty list is returned if the process was not socket activated. The protocol
+// environment variables are cleared so they are not inherited by any child processes.
+func SystemdListeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv(sdEnvListenPID)
+		os.Unsetenv(sdEnvListenFDs)
+		os.Unsetenv(sdEnvListenNames)
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv(sdEnvListenPID))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv(sdEnvListenFDs))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	return listenersFromFDs(sdListenFDsStart, n)
+}
+
+// listenersFromFDs converts count inherited file descriptors beginning at start into listeners.
+func listenersFromFDs(start int, count int) ([]net.Listener, error) {
+	lns := make([]net.Listener, 0, count)
+	for fd := start; fd < start+count; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("listener-fd-%d", fd))
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener holds its own copy of the descriptor.
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, fmt.Errorf("Inherited file descriptor %d is not a listener: %s", fd, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
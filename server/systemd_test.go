@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestSystemdListenersNotActivated(t *testing.T) {
+	os.Setenv(sdEnvListenPID, strconv.Itoa(os.Getpid()+1))
+	os.Setenv(sdEnvListenFDs, "1")
+	lns, err := SystemdListeners()
+	if err != nil || len(lns) != 0 {
+		t.Errorf("Sockets for another process should be ignored.")
+	}
+	if os.Getenv(sdEnvListenPID) != "" || os.Getenv(sdEnvListenFDs) != "" {
+		t.Errorf("Systemd environment should have been cleared.")
+	}
+}
+
+func TestSystemdListenersFromFDs(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("Could not get listener file: %s", err)
+	}
+
+	defer f.Close()
+	fd, _ := syscall.Dup(int(f.Fd())) // listenersFromFDs takes ownership of the descriptor.
+
+	lns, err := listenersFromFDs(fd, 1)
+	if err != nil {
+		t.Fatalf("Could not convert descriptor: %s", err)
+	}
+	if len(lns) != 1 || lns[0].Addr().String() != ln.Addr().String() {
+		t.Errorf("Inherited listener address mismatch.")
+	}
+	lns[0].Close()
+
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	defer w.Close()
+	fd, _ = syscall.Dup(int(r.Fd()))
+	if _, err = listenersFromFDs(fd, 1); err == nil {
+		t.Errorf("A pipe should not convert to a listener.")
+	}
+}
@@ -2,19 +2,51 @@ package server
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	StoppedError = errors.New("Server stop requested.")
+
+	ErrOverflowMode = errors.New("Invalid connection overflow mode.")
+)
+
+// OverflowMode is what a ThrottledListener does with new connections once the limit is hit.
+type OverflowMode int
+
+const (
+	OverflowBlock  OverflowMode = iota // Stop accepting; connections wait in the kernel backlog.
+	OverflowWait                       // Accept and wait up to WaitTimeout for a free token.
+	OverflowReject                     // Accept and answer that the server is busy.
 )
 
+var overflowModes = map[string]OverflowMode{
+	"block":  OverflowBlock,
+	"wait":   OverflowWait,
+	"reject": OverflowReject,
+}
+
+// ParseOverflowMode returns the overflow mode for "block", "wait" or "reject".
+func ParseOverflowMode(s string) (OverflowMode, error) {
+	if s == "" {
+		return OverflowBlock, nil
+	}
+	m, ok := overflowModes[s]
+	if !ok {
+		return OverflowBlock, ErrOverflowMode
+	}
+	return m, nil
+}
+
 // ThrottledConn is a wrapper over net.conn that allows us to throttle connections via the listener.
 type ThrottledConn struct {
-	*net.TCPConn
+	net.Conn
 	acceptCh  chan bool
+	release   func() // Releases the connection from its client address limit.
 	closeOnce sync.Once
 }
 
@@ -24,7 +56,10 @@ func (c *ThrottledConn) Close() error {
 	var err error
 	c.closeOnce.Do(func() {
 		c.Done()
-		err = c.TCPConn.Close()
+		if c.release != nil {
+			c.release()
+		}
+		err = c.Conn.Close()
 	})
 	return err
 }
@@ -36,21 +71,51 @@ func (c *ThrottledConn) Done() {
 	}
 }
 
+// deadliner is implemented by listeners that can time out an accept (TCP and Unix sockets).
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// connCounters are the overflow statistics of listeners sharing connection tokens.
+type connCounters struct {
+	rejected int64 // Connections answered busy because the limit was hit.
+	timedOut int64 // Connections that waited too long for a token.
+	queued   int64 // Connections that waited for a token.
+	waiting  int64 // Connections waiting for a token now.
+}
+
 // ThrottledListener is a wrapper on a listener that limits connections.
 type ThrottledListener struct {
-	*net.TCPListener
+	net.Listener
 	acceptCh chan bool // Queue for service tokens.
 	stopCh   chan bool // Shutdown server requested.
 	maxConns int
+
+	Mode        OverflowMode   // What to do with connections once the limit is hit.
+	WaitTimeout time.Duration  // How long a connection waits for a token in OverflowWait mode.
+	Reject      func(net.Conn) // Answers a connection that gets no token; default is a http 503.
+	Guard       *IPGuard       // Optional limits per client address.
+
+	counters  *connCounters
+	startOnce sync.Once
+	readyCh   chan net.Conn // Connections holding a token, from the accept loop.
+	errCh     chan error    // Error that stopped the accept loop.
 }
 
-// ThrottledListenerNew is a factory function that returns an instatiated ThrottledListener.
+// ThrottledListenerNew is a factory function that returns an instatiated ThrottledListener
+// on a TCP address.
 func ThrottledListenerNew(addr string, mxConn int) (*ThrottledListener, error) {
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
+	return ThrottledListenerWrap(ln, mxConn), nil
+}
 
+// ThrottledListenerWrap is a factory function that returns a ThrottledListener over an already
+// opened listener, such as a Unix domain socket or a socket passed in by systemd.
+// options is an optional list of functions that initialize the structure
+func ThrottledListenerWrap(ln net.Listener, mxConn int, options ...func(*ThrottledListener)) *ThrottledListener {
 	// Initialize accept tokens.
 	var acceptCh chan bool
 	if mxConn > 0 {
@@ -59,36 +124,69 @@ func ThrottledListenerNew(addr string, mxConn int) (*ThrottledListener, error) {
 			acceptCh <- true
 		}
 	}
-	return &ThrottledListener{
-		TCPListener: ln.(*net.TCPListener),
-		acceptCh:    acceptCh,
+	t := &ThrottledListener{
+		Listener: ln,
+		acceptCh: acceptCh,
+		stopCh:   make(chan bool),
+		maxConns: mxConn,
+		Reject:   rejectHTTP,
+		counters: &connCounters{},
+	}
+	for _, f := range options {
+		f(t)
+	}
+	return t
+}
+
+// Share is a factory function that returns a ThrottledListener over ln drawing on the same
+// connection tokens and overflow settings as t, so that several listeners are limited by one
+// connection count. options is an optional list of functions that initialize the structure
+func (t *ThrottledListener) Share(ln net.Listener, options ...func(*ThrottledListener)) *ThrottledListener {
+	sh := &ThrottledListener{
+		Listener:    ln,
+		acceptCh:    t.acceptCh,
 		stopCh:      make(chan bool),
-		maxConns:    mxConn,
-	}, nil
+		maxConns:    t.maxConns,
+		Mode:        t.Mode,
+		WaitTimeout: t.WaitTimeout,
+		Reject:      t.Reject,
+		Guard:       t.Guard,
+		counters:    t.counters,
+	}
+	for _, f := range options {
+		f(sh)
+	}
+	return sh
 }
 
 // Accept overrides the accept function of the listener so that waits can occur on
 // tokens in the queue.
 func (t *ThrottledListener) Accept() (net.Conn, error) {
+	if t.acceptCh != nil && t.Mode != OverflowBlock {
+		return t.acceptOverflow()
+	}
 	for {
 		// Wait to grab a token if we are in restricted mode.
 		if t.acceptCh != nil {
 			<-t.acceptCh
 		}
 
-		// Look for a request for one second.
-		t.SetDeadline(time.Now().Add(time.Second))
-		conn, err := t.AcceptTCP()
-
-		// Check for shutdown signal
-		select {
-		case <-t.stopCh:
-			t.Close()
+		// Check for shutdown signal before accepting, so that a connection accepted as the
+		// listener stops is served rather than dropped. During a graceful restart the new
+		// process accepts the connections still queued on the socket.
+		if t.stopped() {
 			return nil, StoppedError
-		default: // continue
 		}
 
+		// Look for a request for one second.
+		if d, ok := t.Listener.(deadliner); ok {
+			d.SetDeadline(time.Now().Add(time.Second))
+		}
+		conn, err := t.Listener.Accept()
 		if err != nil {
+			if t.stopped() {
+				return nil, StoppedError
+			}
 			// Return token if we are in restricted mode.
 			if t.acceptCh != nil {
 				t.acceptCh <- true
@@ -99,21 +197,198 @@ func (t *ThrottledListener) Accept() (net.Conn, error) {
 			return nil, err
 		}
 
-		// Set connection to stay alive n-time and return it.
-		conn.SetKeepAlive(true)
-		conn.SetKeepAlivePeriod(TCPKeepAliveTimeout)
-		return &ThrottledConn{
-			TCPConn:  conn,
-			acceptCh: t.acceptCh,
-		}, nil
+		release, ok := t.admit(conn)
+		if !ok {
+			if t.acceptCh != nil {
+				t.acceptCh <- true
+			}
+			continue
+		}
+		return t.throttledConn(conn, release), nil
 	}
 }
 
+// stopped returns true, closing the listener and giving back the token of Accept, if the
+// listener has been stopped.
+func (t *ThrottledListener) stopped() bool {
+	select {
+	case <-t.stopCh:
+	default:
+		return false
+	}
+	if t.acceptCh != nil {
+		t.acceptCh <- true
+	}
+	t.Close()
+	return true
+}
+
+// admit checks a new connection against the client address limits, closing it if refused.
+func (t *ThrottledListener) admit(conn net.Conn) (release func(), ok bool) {
+	if t.Guard == nil {
+		return nil, true
+	}
+	release, ok = t.Guard.Admit(conn.RemoteAddr())
+	if !ok {
+		conn.Close()
+	}
+	return release, ok
+}
+
+// throttledConn returns a connection holding a token. TCP connections are set to stay alive.
+func (t *ThrottledListener) throttledConn(conn net.Conn, release func()) *ThrottledConn {
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(TCPKeepAliveTimeout)
+	}
+	return &ThrottledConn{
+		Conn:     conn,
+		acceptCh: t.acceptCh,
+		release:  release,
+	}
+}
+
+// acceptOverflow returns the next connection holding a token from the accept loop, which keeps
+// accepting connections once the limit is hit so they can wait or be rejected.
+func (t *ThrottledListener) acceptOverflow() (net.Conn, error) {
+	t.startOnce.Do(func() {
+		t.readyCh = make(chan net.Conn)
+		t.errCh = make(chan error, 1)
+		go t.acceptLoop()
+	})
+	select {
+	case conn := <-t.readyCh:
+		return conn, nil
+	case err := <-t.errCh:
+		return nil, err
+	case <-t.stopCh:
+		select {
+		case conn := <-t.readyCh: // Handed off as the listener stopped, so served.
+			return conn, nil
+		default:
+		}
+		t.Close()
+		return nil, StoppedError
+	}
+}
+
+// acceptLoop accepts connections and hands them a token, or waits for one or rejects them
+// depending on the overflow mode.
+func (t *ThrottledListener) acceptLoop() {
+	for {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			select {
+			case <-t.stopCh:
+				return
+			default:
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			t.errCh <- err
+			return
+		}
+		release, ok := t.admit(conn)
+		if !ok {
+			continue
+		}
+
+		select {
+		case <-t.acceptCh:
+			t.handOff(conn, release)
+			continue
+		default:
+		}
+		if t.Mode == OverflowReject {
+			atomic.AddInt64(&t.counters.rejected, 1)
+			go t.reject(conn, release)
+			continue
+		}
+		go t.awaitToken(conn, release)
+	}
+}
+
+// reject answers a connection that got no token and releases it from its client address limit.
+func (t *ThrottledListener) reject(conn net.Conn, release func()) {
+	t.Reject(conn)
+	if release != nil {
+		release()
+	}
+}
+
+// awaitToken waits up to WaitTimeout for a token for conn, rejecting it if none comes free.
+func (t *ThrottledListener) awaitToken(conn net.Conn, release func()) {
+	atomic.AddInt64(&t.counters.queued, 1)
+	atomic.AddInt64(&t.counters.waiting, 1)
+	defer atomic.AddInt64(&t.counters.waiting, -1)
+
+	var expired <-chan time.Time
+	if t.WaitTimeout > 0 {
+		timer := time.NewTimer(t.WaitTimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-t.acceptCh:
+		t.handOff(conn, release)
+	case <-expired:
+		atomic.AddInt64(&t.counters.timedOut, 1)
+		t.reject(conn, release)
+	case <-t.stopCh:
+		conn.Close()
+		if release != nil {
+			release()
+		}
+	}
+}
+
+// handOff passes a connection holding a token to Accept.
+func (t *ThrottledListener) handOff(conn net.Conn, release func()) {
+	tc := t.throttledConn(conn, release)
+	select {
+	case t.readyCh <- tc:
+	case <-t.stopCh:
+		tc.Close()
+	}
+}
+
+// rejectHTTP answers a connection that got no token with a http 503 and closes it. The body is
+// the server_busy error of the API, as written by writeError.
+func rejectHTTP(conn net.Conn) {
+	body := APIErrorNew(CodeServerBusy, ServerBusy).body()
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	fmt.Fprintf(conn, "HTTP/1.1 503 Service Unavailable\r\nRetry-After: 1\r\n"+
+		"Content-Type: application/json;charset=utf-8\r\nX-Content-Type-Options: nosniff\r\n"+
+		"Content-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+	conn.Close()
+}
+
 // Stops the listener
 func (t *ThrottledListener) Stop() {
 	close(t.stopCh)
 }
 
+// GetConnRejected returns the number of connections answered busy because the limit was hit.
+func (t *ThrottledListener) GetConnRejected() int64 {
+	return atomic.LoadInt64(&t.counters.rejected)
+}
+
+// GetConnTimedOut returns the number of connections that waited too long for a token.
+func (t *ThrottledListener) GetConnTimedOut() int64 {
+	return atomic.LoadInt64(&t.counters.timedOut)
+}
+
+// GetConnQueued returns the number of connections that waited for a token.
+func (t *ThrottledListener) GetConnQueued() int64 {
+	return atomic.LoadInt64(&t.counters.queued)
+}
+
+// GetConnWaiting returns the number of connections waiting for a token now.
+func (t *ThrottledListener) GetConnWaiting() int64 {
+	return atomic.LoadInt64(&t.counters.waiting)
+}
+
 // GetConnNumAvail returns the total number of connections available.
 func (t *ThrottledListener) GetConnNumAvail() int {
 	if t.acceptCh != nil {
@@ -1,6 +1,13 @@
 package server
 
-import "testing"
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
 
 func TestThrottleConnClose(t *testing.T) {
 	t.Parallel()
@@ -27,3 +34,147 @@ func TestThrottleListenerGetConnectedCount(t *testing.T) {
 	t.Parallel()
 	t.Skip("Covered by server test.")
 }
+
+func TestParseOverflowMode(t *testing.T) {
+	t.Parallel()
+	for s, expected := range map[string]OverflowMode{
+		"":       OverflowBlock,
+		"block":  OverflowBlock,
+		"wait":   OverflowWait,
+		"reject": OverflowReject,
+	} {
+		if m, err := ParseOverflowMode(s); err != nil || m != expected {
+			t.Errorf("Overflow mode %q parsed as %d: %v", s, m, err)
+		}
+	}
+	if _, err := ParseOverflowMode("drop"); err != ErrOverflowMode {
+		t.Errorf("Invalid overflow mode should not parse.")
+	}
+}
+
+func TestThrottleListenerOverflowReject(t *testing.T) {
+	t.Parallel()
+	tl, err := ThrottledListenerNew("127.0.0.1:0", 1)
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	tl.Mode = OverflowReject
+	defer tl.Stop()
+
+	first, _ := net.Dial("tcp", tl.Addr().String())
+	defer first.Close()
+	conn, err := tl.Accept()
+	if err != nil {
+		t.Fatalf("Could not accept first connection: %s", err)
+	}
+	defer conn.Close()
+
+	second, _ := net.Dial("tcp", tl.Addr().String())
+	defer second.Close()
+	resp, err := http.ReadResponse(bufio.NewReader(second), nil)
+	if err != nil {
+		t.Fatalf("Rejected connection not answered: %s", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Rejected connection should get a 503 with Retry-After: %s", resp.Status)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	if e := decodeError(b); e.Code != CodeServerBusy || e.Message != ServerBusy ||
+		resp.Header.Get("Content-Type") != "application/json;charset=utf-8" {
+		t.Errorf("Rejected connection should get a server_busy error: %s %s", resp.Header.Get("Content-Type"), b)
+	}
+	if tl.GetConnRejected() != 1 {
+		t.Errorf("Rejected connection not counted: %d", tl.GetConnRejected())
+	}
+}
+
+func TestThrottleListenerOverflowWait(t *testing.T) {
+	t.Parallel()
+	tl, err := ThrottledListenerNew("127.0.0.1:0", 1)
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	tl.Mode = OverflowWait
+	tl.WaitTimeout = 50 * time.Millisecond
+	defer tl.Stop()
+
+	first, _ := net.Dial("tcp", tl.Addr().String())
+	defer first.Close()
+	conn, err := tl.Accept()
+	if err != nil {
+		t.Fatalf("Could not accept first connection: %s", err)
+	}
+
+	// Waits too long while the first connection is open.
+	second, _ := net.Dial("tcp", tl.Addr().String())
+	defer second.Close()
+	resp, err := http.ReadResponse(bufio.NewReader(second), nil)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Timed out connection should get a 503: %v", err)
+	}
+	if tl.GetConnTimedOut() != 1 || tl.GetConnQueued() != 1 {
+		t.Errorf("Timed out connection not counted: %d %d", tl.GetConnTimedOut(), tl.GetConnQueued())
+	}
+
+	// Gets the token when the first connection closes in time.
+	third, _ := net.Dial("tcp", tl.Addr().String())
+	defer third.Close()
+	for deadline := time.Now().Add(time.Second); tl.GetConnQueued() < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	conn.Close()
+	conn, err = tl.Accept()
+	if err != nil {
+		t.Fatalf("Waiting connection not accepted: %s", err)
+	}
+	conn.Close()
+	if tl.GetConnQueued() != 2 || tl.GetConnTimedOut() != 1 {
+		t.Errorf("Waiting connection not counted: %d", tl.GetConnQueued())
+	}
+}
+
+// acceptGate is a listener whose Accept waits for the test to hand it a connection.
+type acceptGate struct {
+	net.Listener
+	entered chan bool
+	connCh  chan net.Conn
+}
+
+func (l *acceptGate) Accept() (net.Conn, error) {
+	l.entered <- true
+	return <-l.connCh, nil
+}
+
+func TestThrottleListenerStop(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	gate := &acceptGate{Listener: ln, entered: make(chan bool, 1), connCh: make(chan net.Conn)}
+	tl := ThrottledListenerWrap(gate, 2)
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := tl.Accept()
+		accepted <- conn
+	}()
+
+	// A connection accepted as the listener stops is served, not dropped.
+	<-gate.entered
+	tl.Stop()
+	client, server := net.Pipe()
+	defer client.Close()
+	gate.connCh <- server
+	conn := <-accepted
+	if conn == nil {
+		t.Fatalf("Connection accepted as the listener stopped should be served.")
+	}
+	defer conn.Close()
+	go client.Write([]byte("x"))
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		t.Errorf("Connection should be open: %s", err)
+	}
+	if _, err := tl.Accept(); err != StoppedError {
+		t.Errorf("Stopped listener should not accept: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/composer22/clidemo/tracing"
+)
+
+// traceExporter returns the exporter of the spans of the server: a file if one is configured,
+// otherwise the OTLP/HTTP collector.
+func traceExporter(opts *Options) (tracing.Exporter, error) {
+	if opts.TraceFile != "" {
+		return tracing.NewFileExporter(opts.TraceFile)
+	}
+	return tracing.NewOTLPExporter(opts.TraceEndpoint), nil
+}
+
+// startRequestSpan starts the span of a request to the server, with the IDs of the server span
+// of its trace context. remote is true if the trace was started by the caller, whose sampling
+// decision is followed. The sampled flag of the trace context is set to the decision.
+func (s *Server) startRequestSpan(ctx context.Context, name string, tc *traceContext,
+	remote bool) (context.Context, *tracing.Span) {
+	if s.tracer == nil {
+		return ctx, nil
+	}
+	options := []func(*tracing.Span){func(sp *tracing.Span) {
+		sp.Kind = tracing.KindServer
+		sp.TraceID = tc.traceID
+		sp.SpanID = tc.spanID
+	}}
+	if remote {
+		options = append(options, tracing.Remote(tc.traceID, tc.parentID, tc.flags&traceSampled != 0))
+	}
+	ctx, span := s.tracer.Start(ctx, name, options...)
+	tc.flags &^= traceSampled
+	if span.Sampled {
+		tc.flags |= traceSampled
+	}
+	return ctx, span
+}
+
+// endRequestSpan ends the span of an http request answered with the status code. Server errors
+// mark the span as failed.
+func endRequestSpan(r *http.Request, code int) {
+	span := tracing.FromContext(r.Context())
+	span.SetAttribute("http.response.status_code", code)
+	if code >= http.StatusInternalServerError {
+		span.SetError(fmt.Errorf("%d %s", code, http.StatusText(code)))
+	}
+	span.End()
+}
+
+// exportTraces exports the spans of the server every interval until doneCh is closed.
+func (s *Server) exportTraces(interval time.Duration, doneCh chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.tracer.Flush(); err != nil {
+				s.log.Warningf("Cannot export spans: %s", err)
+			}
+		case <-doneCh:
+			return
+		}
+	}
+}
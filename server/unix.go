@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// UnixListenerNew is a factory function that returns a listener on a Unix domain socket at path.
+// A stale socket file left behind by a previous run is removed first. mode and owner
+// ("user[:group]", names or numeric ids) are applied to the socket file when set.
+func UnixListenerNew(path string, mode os.FileMode, owner string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("Cannot listen on %s: file exists and is not a socket.", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	if owner != "" {
+		uid, gid, err := lookupOwner(owner)
+		if err == nil {
+			err = os.Chown(path, uid, gid)
+		}
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// parseFileMode converts an octal string such as "0660" into a file mode. An empty string
+// returns 0, which means leave the mode unchanged.
+func parseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("Invalid file mode %q: must be octal permissions e.g. 0660.", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// lookupOwner resolves a "user[:group]" string into numeric ids. Either part may be a name or
+// a number. A missing group is returned as -1 so that it is left unchanged by os.Chown.
+func lookupOwner(owner string) (uid int, gid int, err error) {
+	uid, gid = -1, -1
+	parts := strings.SplitN(owner, ":", 2)
+	if parts[0] != "" {
+		if uid, err = strconv.Atoi(parts[0]); err != nil {
+			u, lerr := user.Lookup(parts[0])
+			if lerr != nil {
+				return -1, -1, lerr
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return -1, -1, err
+			}
+		}
+	}
+	if len(parts) == 2 && parts[1] != "" {
+		if gid, err = strconv.Atoi(parts[1]); err != nil {
+			g, lerr := user.LookupGroup(parts[1])
+			if lerr != nil {
+				return -1, -1, lerr
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return -1, -1, err
+			}
+		}
+	}
+	if uid == -1 && gid == -1 {
+		return -1, -1, errors.New("Invalid socket owner: expected user[:group].")
+	}
+	return uid, gid, nil
+}
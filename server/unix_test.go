@@ -0,0 +1,109 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixListenerNew(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "clidemo")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.sock")
+
+	// A regular file in the way must not be removed.
+	ioutil.WriteFile(path, []byte("data"), 0600)
+	if _, err := UnixListenerNew(path, 0, ""); err == nil {
+		t.Errorf("Listener should not replace a regular file.")
+	}
+	os.Remove(path)
+
+	ln, err := UnixListenerNew(path, 0600, "")
+	if err != nil {
+		t.Fatalf("Could not create unix listener: %s", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Socket file not created: %s", err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		t.Errorf("Socket file is not a socket.")
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("Socket file mode not set: %s", fi.Mode().Perm())
+	}
+
+	// Stale sockets are replaced on restart.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = UnixListenerNew(path, 0, "")
+	if err != nil {
+		t.Fatalf("Stale socket file was not replaced: %s", err)
+	}
+
+	// Throttling applies to any listener.
+	tl := ThrottledListenerWrap(ln, 2)
+	defer tl.Stop()
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Could not dial unix socket: %s", err)
+	}
+	defer client.Close()
+	conn, err := tl.Accept()
+	if err != nil {
+		t.Fatalf("Could not accept unix connection: %s", err)
+	}
+	if _, ok := conn.(*ThrottledConn); !ok {
+		t.Errorf("Accepted connection is not throttled.")
+	}
+	if tl.GetConnNumAvail() != 1 {
+		t.Errorf("Connection token not taken: %d", tl.GetConnNumAvail())
+	}
+	conn.Close()
+	conn.Close() // Token should only be returned once.
+	time.Sleep(10 * time.Millisecond)
+	if tl.GetConnNumAvail() != 2 {
+		t.Errorf("Connection token not returned: %d", tl.GetConnNumAvail())
+	}
+}
+
+func TestUnixParseFileMode(t *testing.T) {
+	t.Parallel()
+	if m, err := parseFileMode(""); err != nil || m != 0 {
+		t.Errorf("Empty mode should be no change.")
+	}
+	if m, err := parseFileMode("0660"); err != nil || m != 0660 {
+		t.Errorf("Octal mode not parsed: %s %v", m, err)
+	}
+	if _, err := parseFileMode("0999"); err == nil {
+		t.Errorf("Non octal mode should be invalid.")
+	}
+	if _, err := parseFileMode("07777"); err == nil {
+		t.Errorf("Mode outside permission bits should be invalid.")
+	}
+}
+
+func TestUnixLookupOwner(t *testing.T) {
+	t.Parallel()
+	uid, gid, err := lookupOwner("1234:5678")
+	if err != nil || uid != 1234 || gid != 5678 {
+		t.Errorf("Numeric owner not parsed: %d:%d %v", uid, gid, err)
+	}
+	uid, gid, err = lookupOwner("root")
+	if err != nil || uid != 0 || gid != -1 {
+		t.Errorf("Named owner not resolved: %d:%d %v", uid, gid, err)
+	}
+	if _, _, err = lookupOwner(":"); err == nil {
+		t.Errorf("Empty owner should be invalid.")
+	}
+	if _, _, err = lookupOwner("no-such-user-clidemo"); err == nil {
+		t.Errorf("Unknown owner should be invalid.")
+	}
+}
@@ -11,15 +11,68 @@ Description: Parse text counting words and sentence locations, this command
   process.
 
 Usage: clidemo [options...] [input_filename]
+       clidemo [--token_store PATH] token create|list|revoke [options...]
 
 Server options:
     -N, --name NAME                  NAME of the server (default: empty field).
     -H, --hostname HOSTNAME          HOSTNAME of the server (default: localhost).
     -p, --port PORT                  PORT to listen on (default: 49152).
 	-L, --profiler_port PORT         *PORT the profiler is listening on (default: off).
+    -s, --socket_port PORT           *PORT the socket protocol is listening on (default: off).
     -n, --connections MAX            *MAX server connections allowed (default: unlimited).
+        --connection_overflow MODE   MODE for connections over the maximum: block, wait or
+                                     reject with a 503 (default: block).
+        --connection_wait DURATION   DURATION a connection over the maximum waits in wait
+                                     mode before it is rejected (default: 5s).
+        --max_conn_per_ip MAX        *MAX concurrent connections per client address.
+        --conn_rate_per_ip RATE      *RATE of connections per second per client address.
+        --conn_burst_per_ip BURST    BURST of connections per client address over its rate (default: 10).
+        --allow CIDRS                Comma separated client addresses or CIDRS that may connect.
+        --deny CIDRS                 Comma separated client addresses or CIDRS that may not connect.
+        --ban_after COUNT            *Ban a client address after COUNT limit violations.
+        --ban_time DURATION          DURATION of a client address ban (default: 5m).
+        --token_store PATH           PATH of the token store for the admin API (default: off).
+        --jwt_keys PATH              PATH of a PEM or JWKS file of JWT issuer keys (RS256, ES256).
+        --jwt_secret_file PATH       PATH of a file with the JWT HS256 shared secret.
+        --jwt_issuer ISSUER          ISSUER required in the iss claim of JWTs (default: any).
+        --jwt_audience AUDIENCE      AUDIENCE required in the aud claim of JWTs (default: any).
+        --hmac_keys PATH             PATH of a json file of keys for signed requests (default: off).
+        --hmac_skew DURATION         DURATION a signed request time may differ from the server (default: 5m).
+        --hmac_max_body SIZE         *SIZE in bytes of the largest signed request body (default: 10485760).
+        --stats_interval DURATION    DURATION between snapshots of the statistics (default: 1m, <= 0 is off).
+        --stats_history DURATION     DURATION the snapshots are kept (default: 24h).
+        --stats_file PATH            PATH of a file that keeps the statistics across restarts (default: off).
+        --trace_endpoint URL         URL of an OTLP/HTTP collector the tracing spans are sent to
+                                     e.g. http://localhost:4318 (default: off).
+        --trace_file PATH            PATH of a file the tracing spans are written to instead (default: off).
+        --trace_sample RATIO         RATIO of the traces started by the server that are sampled (default: 1).
+        --access_log PATH            PATH of the access log file (default: the server log).
+        --access_log_format FORMAT   FORMAT of the access log: common, combined, json or off (default: json).
+        --access_log_headers NAMES   Comma separated header NAMES logged in the json format, * for all or
+                                     - for none (default: Accept,Content-Type,User-Agent).
+        --access_log_redact NAMES    Comma separated header NAMES logged as [REDACTED], besides
+                                     Authorization, Cookie, Proxy-Authorization, Set-Cookie and X-Api-Key.
+        --access_log_body BYTES      BYTES of request bodies logged in the json format (default: 0, off).
+        --access_log_sample RATIO    RATIO of the successful requests logged; errors are always logged
+                                     (default: 1).
+    -w, --min_workers MIN            MIN workers kept running when idle (default: 10).
     -W, --workers MAX                MAX running workers allowed (default: 1000).
+        --worker_idle_timeout DURATION
+                                     DURATION a spare worker waits for a job before retiring
+                                     (default: 30s, <= 0 is never).
+        --worker_grow_wait DURATION  DURATION a job waits for a worker before another is spawned
+                                     (default: 10ms).
     -X, --procs MAX                  *MAX processor cores to use from the machine.
+    -T, --parse_timeout DURATION     *DURATION a parse request may take e.g. 30s (default: unlimited).
+    -Q, --queue_depth MAX            MAX parse jobs waiting for a worker (default: workers).
+        --queue_timeout DURATION     *DURATION a job may wait for a worker before a 503 (default: 10s).
+        --priority_bytes SIZE        Documents up to SIZE bytes jump the queue (default: 1024).
+        --stream_max_bytes SIZE      *SIZE in bytes of the text sent over a websocket stream
+                                     (default: 10485760).
+    -u, --unix_socket PATH           PATH of a Unix domain socket to listen on instead of TCP.
+        --unix_socket_mode MODE      Octal file MODE of the Unix domain socket (default: 0660).
+        --unix_socket_owner OWNER    OWNER user[:group] of the Unix domain socket.
+        --systemd                    Listen on the socket passed in by systemd (LISTEN_FDS).
 
     -d, --debug                      Enable debugging output (default: false)
 
@@ -27,6 +80,12 @@ Server options:
 
 File input options:
     -f, --file FILE                  Process input FILE
+    -F, --format FORMAT              Output FORMAT json or binary (default: json).
+                                     Binary encoded input is decoded automatically.
+
+Client options:
+    -c, --connect HOST:PORT          Parse file or piped input on the server socket port at HOST:PORT.
+    -t, --token TOKEN                Bearer TOKEN for authorizing with the server.
 
 Common options:
     -h, --help                       Show this message
@@ -38,6 +97,9 @@ Examples:
 	# 10 conns; 30 workers; 2 processors
     clidemo -N "San Francisco" -p 8080 -n 10 -W 30 -X 2
 
+	# Server mode on a Unix domain socket readable by the www-data group
+	clidemo -u /run/clidemo.sock --unix_socket_owner clidemo:www-data
+
 	# File input using -f flag with debug option
 	clidemo -f /tmp/inputfiles/foo/bar.txt -d > out.txt
 
@@ -46,6 +108,13 @@ Examples:
 
 	# Piping input
 	cat /tmp/inputfiles/foo/bar.txt | clidemo > out.txt
+
+	# Binary encoded output, then converted back to json
+	clidemo -F binary /tmp/inputfiles/foo/bar.txt > out.bin
+	clidemo out.bin > out.txt
+
+	# Parse a file on a server running with the socket protocol on port 8081
+	clidemo -c localhost:8081 -t 3A3E6C4C51F12DF2415682CCF9D18 /tmp/inputfiles/foo/bar.txt
 `
 
 // end help text
@@ -2,31 +2,109 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/composer22/clidemo/parser"
+	"github.com/composer22/clidemo/tracing"
 )
 
 // parseJob is a transport packet that represents text that needs parsing by a worker.
 type parseJob struct {
-	Source string    `json:"source"` // Source text to be parsed.
-	DoneCh chan bool `json:"-"`      // Channel to notify when done parsing.
-	Result string    `json:"result"` // Result of parse results
+	Ctx    context.Context `json:"-"`      // Cancels the job, such as when the client goes away.
+	Source string          `json:"source"` // Source text to be parsed.
+	Binary bool            `json:"binary"` // Return the result in the binary encoding.
+	DoneCh chan bool       `json:"-"`      // Channel to notify when done parsing.
+	Result string          `json:"result"` // Result of parse results
+	Err    error           `json:"-"`      // Why the job was not completed.
+
+	Priority  bool          `json:"priority"` // Queue ahead of normal jobs.
+	queued    time.Time     // When the job entered the queue.
+	state     int32         // Queue state of the job; see jobQueued.
+	parseTime time.Duration // Time the worker spent parsing.
+	waited    time.Duration // Time the job waited for a worker.
+	route     string        // Route the job came in on, for the statistics.
+	requestID string        // The request the job is for, for the logs.
+
+	// Optional channel to report parse progress on. Reports are dropped rather than
+	// holding up the worker if the channel is full.
+	ProgressCh chan parser.Progress `json:"-"`
+}
+
+// parseJobNew is a factory function that returns a new parse job for source text.
+// options is an optional list of functions that initialize the structure
+func parseJobNew(ctx context.Context, source string, options ...func(*parseJob)) *parseJob {
+	job := &parseJob{
+		Ctx:    ctx,
+		Source: source,
+		DoneCh: make(chan bool, 1), // Buffered so an abandoned job never blocks a worker.
+
+		requestID: RequestIDFromContext(ctx),
+	}
+	for _, f := range options {
+		f(job)
+	}
+	return job
+}
+
+// abandon marks a queued job so that workers skip it. It returns false if a worker has already
+// taken the job.
+func (j *parseJob) abandon() bool {
+	return atomic.CompareAndSwapInt32(&j.state, jobQueued, jobAbandoned)
 }
 
 // parseWorker is used as a go routine wrapper to handle parsing jobs for the server.
-func parseWorker(jobq chan *parseJob, wg *sync.WaitGroup) {
-	defer wg.Done()
+func parseWorker(wp *workerPool) {
+	defer wp.wg.Done()
 	p := parser.New()
 	for {
-		job, ok := <-jobq
+		job, ok := wp.next()
 		if !ok {
 			break
 		}
-		p.Execute(bytes.NewBufferString(job.Source))
-		job.Result = fmt.Sprint(p)
+		if err := job.Ctx.Err(); err != nil { // Abandoned while waiting for a worker.
+			job.Err = err
+			job.DoneCh <- true
+			wp.done()
+			continue
+		}
+		if job.ProgressCh != nil {
+			progressCh := job.ProgressCh
+			p.SetProgress(func(pr parser.Progress) {
+				select {
+				case progressCh <- pr:
+				default:
+				}
+			}, ProgressInterval)
+		}
+		_, span := tracing.Start(job.Ctx, "queue_wait", func(sp *tracing.Span) { sp.StartTime = job.queued })
+		span.SetAttribute("priority", job.Priority)
+		span.EndAt(job.queued.Add(job.waited))
+
+		_, span = tracing.Start(job.Ctx, "parse")
+		span.SetAttribute("parse.bytes", len(job.Source))
+		start := time.Now()
+		job.Err = p.ExecuteContext(job.Ctx, bytes.NewBufferString(job.Source))
+		job.parseTime = time.Since(start)
+		span.SetError(job.Err)
+		span.End()
+		p.SetProgress(nil, 0)
+
+		_, span = tracing.Start(job.Ctx, "encode")
+		switch {
+		case job.Err != nil:
+		case job.Binary:
+			b, _ := p.MarshalBinary()
+			job.Result = string(b)
+		default:
+			job.Result = fmt.Sprint(p)
+		}
+		span.SetAttribute("result.bytes", len(job.Result))
+		span.End()
 		job.DoneCh <- true
 		p.Reset()
+		wp.done()
 	}
 }
@@ -1,8 +1,11 @@
 package server
 
 import (
-	"sync"
+	"context"
+	"fmt"
 	"testing"
+
+	"github.com/composer22/clidemo/parser"
 )
 
 const (
@@ -14,19 +17,35 @@ const (
 
 func TestParseWorker(t *testing.T) {
 	t.Parallel()
-	var wg sync.WaitGroup
-	jobq := make(chan *parseJob)
-	wg.Add(1)
-	go parseWorker(jobq, &wg)
-	job := parseJob{
-		Source: workerParseTestText,
-		DoneCh: make(chan bool),
-	}
-	jobq <- &job
+	jobq := jobQueueNew(1)
+	wp := workerPoolNew(jobq, 1, 1, 0, 0)
+	wp.Start()
+	job := parseJobNew(context.Background(), workerParseTestText)
+	jobq.enqueue(job, 0)
 	<-job.DoneCh
 	if job.Result != expectedWorkerJSONResult {
 		t.Errorf("Worker expected parse doesn't match result.")
 	}
-	close(jobq)
-	wg.Wait()
+
+	job = parseJobNew(context.Background(), workerParseTestText, func(j *parseJob) {
+		j.Binary = true
+	})
+	jobq.enqueue(job, 0)
+	<-job.DoneCh
+	p := parser.New()
+	if err := p.UnmarshalBinary([]byte(job.Result)); err != nil || fmt.Sprint(p) != expectedWorkerJSONResult {
+		t.Errorf("Worker expected binary parse doesn't match result.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	job = parseJobNew(ctx, workerParseTestText)
+	<-jobq.slots // enqueue would refuse the cancelled job.
+	jobq.low <- job
+	<-job.DoneCh
+	if job.Err != context.Canceled || job.Result != "" {
+		t.Errorf("Worker expected cancelled job to be abandoned. Err: %v", job.Err)
+	}
+	jobq.close()
+	wp.Wait()
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/composer22/clidemo/auth"
+)
+
+const tokenUsageStr = `
+Usage: clidemo [--token_store PATH] token COMMAND [options...]
+
+Manage the tokens in the store of a server, offline or while it is running.
+
+Commands:
+    create [--label LABEL] [--scopes SCOPES] [--ttl DURATION] [--premium]
+                                     Create a token. It is printed once and cannot be shown again.
+    list                             List the tokens.
+    revoke ID                        Revoke the token with ID.
+
+Options:
+        --store PATH                 PATH of the token store (default: --token_store).
+        --label LABEL                LABEL saying what the token is for.
+        --scopes SCOPES              Comma separated SCOPES the token may be used for
+                                     e.g. parse,status,admin.
+        --ttl DURATION               DURATION until the token expires e.g. 720h (default: never).
+        --premium                    Give the requests of the token priority.
+`
+
+// tokenCommand runs the token subcommand with its arguments on the store at path.
+func tokenCommand(args []string, path string) {
+	if len(args) == 0 {
+		tokenUsageAndExit()
+	}
+	cmd := args[0]
+
+	var label string
+	var scopes []string
+	var ttl time.Duration
+	var premium bool
+	fs := flag.NewFlagSet("token "+cmd, flag.ExitOnError)
+	fs.Usage = tokenUsageAndExit
+	fs.StringVar(&path, "store", path, "Path of the token store")
+	fs.StringVar(&label, "label", "", "What the token is for")
+	fs.Var(listFlag{&scopes}, "scopes", "Comma separated scopes the token may be used for")
+	fs.DurationVar(&ttl, "ttl", 0, "Time until the token expires (default: never)")
+	fs.BoolVar(&premium, "premium", false, "Give the requests of the token priority")
+	fs.Parse(args[1:])
+	if path == "" {
+		log.Emergencyf("No token store: use --store or --token_store.")
+	}
+	st, err := auth.OpenStore(path)
+	if err != nil {
+		log.Emergencyf("Cannot open token store %s: %s", path, err)
+	}
+
+	switch cmd {
+	case "create":
+		tk, r, err := st.Create(label, scopes, ttl, func(r *auth.Record) { r.Premium = premium })
+		if err != nil {
+			log.Emergencyf("Cannot create token: %s", err)
+		}
+		fmt.Printf("id:    %s\ntoken: %s\n", r.ID, tk)
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tLABEL\tSCOPES\tCREATED\tEXPIRES\tSTATUS")
+		now := time.Now()
+		for _, r := range st.List() {
+			expires, status := "never", "active"
+			if r.Expires != nil {
+				expires = r.Expires.Format(time.RFC3339)
+			}
+			switch {
+			case r.Revoked != nil:
+				status = "revoked"
+			case !r.Active(now):
+				status = "expired"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Label, strings.Join(r.Scopes, ","),
+				r.Created.Format(time.RFC3339), expires, status)
+		}
+		w.Flush()
+	case "revoke":
+		if fs.NArg() != 1 {
+			tokenUsageAndExit()
+		}
+		if _, err := st.Revoke(fs.Arg(0)); err != nil {
+			log.Emergencyf("Cannot revoke token %s: %s", fs.Arg(0), err)
+		}
+		fmt.Printf("revoked: %s\n", fs.Arg(0))
+	default:
+		tokenUsageAndExit()
+	}
+}
+
+// tokenUsageAndExit prints the usage of the token subcommand then exits.
+func tokenUsageAndExit() {
+	fmt.Printf("%s\n", tokenUsageStr)
+	os.Exit(0)
+}
@@ -0,0 +1,222 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OTLP/HTTP defaults.
+const (
+	TracesPath    = "/v1/traces" // Path of the traces endpoint of a collector.
+	ExportTimeout = 10 * time.Second
+
+	statusError = 2 // STATUS_CODE_ERROR.
+)
+
+// ExportRequest is a batch of spans in the OTLP JSON encoding of an ExportTraceServiceRequest.
+type ExportRequest struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+// ResourceSpans are the spans of a service.
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+// Resource describes the service that recorded the spans.
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// ScopeSpans are the spans recorded by an instrumentation scope.
+type ScopeSpans struct {
+	Scope Scope      `json:"scope"`
+	Spans []SpanData `json:"spans"`
+}
+
+// Scope is the instrumentation that recorded the spans.
+type Scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// SpanData is an ended span. IDs are hex and times are unix nanoseconds as strings, as OTLP JSON
+// requires.
+type SpanData struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []KeyValue `json:"attributes,omitempty"`
+	Status            SpanStatus `json:"status"`
+}
+
+// SpanStatus is whether the work of a span failed.
+type SpanStatus struct {
+	Code    int    `json:"code,omitempty"` // 0 unset, 2 error.
+	Message string `json:"message,omitempty"`
+}
+
+// KeyValue is an attribute.
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue is the value of an attribute; only one field is set.
+type AnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 as a string.
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// Exporter sends batches of spans to where they are kept.
+type Exporter interface {
+	Export(req *ExportRequest) error
+}
+
+// exportRequest returns the export request of spans of the tracer.
+func (t *Tracer) exportRequest(spans []*Span) *ExportRequest {
+	data := make([]SpanData, 0, len(spans))
+	for _, sp := range spans {
+		sd := SpanData{
+			TraceID:           hex.EncodeToString(sp.TraceID[:]),
+			SpanID:            hex.EncodeToString(sp.SpanID[:]),
+			Name:              sp.Name,
+			Kind:              sp.Kind,
+			StartTimeUnixNano: strconv.FormatInt(sp.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(sp.EndTime.UnixNano(), 10),
+		}
+		if sp.ParentID != [8]byte{} {
+			sd.ParentSpanID = hex.EncodeToString(sp.ParentID[:])
+		}
+		for _, a := range sp.Attributes() {
+			sd.Attributes = append(sd.Attributes, keyValue(a.Key, a.Value))
+		}
+		if err := sp.Err(); err != "" {
+			sd.Status = SpanStatus{Code: statusError, Message: err}
+		}
+		data = append(data, sd)
+	}
+	res := Resource{Attributes: []KeyValue{keyValue("service.name", t.Service)}}
+	if t.Version != "" {
+		res.Attributes = append(res.Attributes, keyValue("service.version", t.Version))
+	}
+	return &ExportRequest{ResourceSpans: []ResourceSpans{{
+		Resource:   res,
+		ScopeSpans: []ScopeSpans{{Scope: Scope{Name: t.Service, Version: t.Version}, Spans: data}},
+	}}}
+}
+
+// keyValue returns an attribute in the OTLP encoding. Values of other types are sent as strings.
+func keyValue(key string, value interface{}) KeyValue {
+	kv := KeyValue{Key: key}
+	switch v := value.(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case int:
+		s := strconv.Itoa(v)
+		kv.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case float64:
+		kv.Value.DoubleValue = &v
+	case bool:
+		kv.Value.BoolValue = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
+
+// OTLPExporter sends spans to a collector with OTLP/HTTP in the JSON encoding.
+type OTLPExporter struct {
+	URL    string       // Traces endpoint of the collector.
+	Client *http.Client // Client used to send the spans.
+}
+
+// NewOTLPExporter is a factory function that returns an exporter to the collector at endpoint,
+// e.g. http://localhost:4318. The traces path is added if endpoint has no path.
+// options is an optional list of functions that initialize the structure.
+func NewOTLPExporter(endpoint string, options ...func(*OTLPExporter)) *OTLPExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if i := strings.Index(url, "://"); i < 0 || !strings.Contains(url[i+3:], "/") {
+		url += TracesPath
+	}
+	e := &OTLPExporter{URL: url, Client: &http.Client{Timeout: ExportTimeout}}
+	for _, f := range options {
+		f(e)
+	}
+	return e
+}
+
+// Export is an implementation of the Exporter interface.
+func (e *OTLPExporter) Export(req *ExportRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := e.Client.Post(e.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) // So the connection may be reused.
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Collector returned %s.", resp.Status)
+	}
+	return nil
+}
+
+// FileExporter appends each batch of spans to a file as a line of OTLP JSON, as the file
+// exporter of the OpenTelemetry collector does.
+type FileExporter struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileExporter is a factory function that returns an exporter to the file at path, which is
+// created if needed.
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{f: f}, nil
+}
+
+// Export is an implementation of the Exporter interface.
+func (e *FileExporter) Export(req *ExportRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.f.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.f.Close()
+}
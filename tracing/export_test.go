@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	t.Parallel()
+	received := make(chan ExportRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != TracesPath || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var req ExportRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		received <- req
+	}))
+	defer collector.Close()
+
+	tr := NewTracer(NewOTLPExporter(collector.URL), func(t *Tracer) {
+		t.Service = "clidemo"
+		t.Version = "1.0"
+	})
+	_, sp := tr.Start(context.Background(), "request", func(sp *Span) {
+		sp.TraceID = [16]byte{0x4b, 0xf9}
+		sp.ParentID = [8]byte{0x01}
+	})
+	sp.SetAttribute("http.route", "/v1.0/parse")
+	sp.End()
+	if err := tr.Flush(); err != nil {
+		t.Fatalf("Cannot export: %s", err)
+	}
+	req := <-received
+	rs := req.ResourceSpans[0]
+	if *rs.Resource.Attributes[0].Value.StringValue != "clidemo" || *rs.Resource.Attributes[1].Value.StringValue != "1.0" {
+		t.Errorf("Resource not exported: %+v", rs.Resource)
+	}
+	sd := rs.ScopeSpans[0].Spans[0]
+	if sd.TraceID != "4bf90000000000000000000000000000" || sd.ParentSpanID != "0100000000000000" ||
+		len(sd.SpanID) != 16 || sd.Name != "request" || sd.StartTimeUnixNano == "" ||
+		*sd.Attributes[0].Value.StringValue != "/v1.0/parse" {
+		t.Errorf("Span not exported: %+v", sd)
+	}
+
+	e := NewOTLPExporter(collector.URL + "/elsewhere")
+	if e.URL != collector.URL+"/elsewhere" {
+		t.Errorf("Endpoint with a path should be used as is: %s", e.URL)
+	}
+	if err := e.Export(&ExportRequest{}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Collector errors should be returned: %v", err)
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "clidemo-tracing")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traces.json")
+	e, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatalf("Cannot open file: %s", err)
+	}
+	tr := NewTracer(e)
+	for i := 0; i < 2; i++ {
+		_, sp := tr.Start(context.Background(), "request")
+		sp.SetAttribute("ok", true)
+		sp.End()
+		tr.Flush()
+	}
+	e.Close()
+
+	b, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Each batch should be a line: %s", b)
+	}
+	var req ExportRequest
+	if err := json.Unmarshal([]byte(lines[1]), &req); err != nil || !*req.ResourceSpans[0].ScopeSpans[0].Spans[0].Attributes[0].Value.BoolValue {
+		t.Errorf("Batch not written as OTLP JSON: %s", lines[1])
+	}
+}
@@ -0,0 +1,252 @@
+// Package tracing records spans of the work done for a request and exports them in the
+// OpenTelemetry protocol (OTLP) JSON encoding, to a collector over http or to a file.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of span, as in OTLP.
+const (
+	KindInternal = 1 // Work inside the server.
+	KindServer   = 2 // A request handled by the server.
+)
+
+// Default limits of a tracer.
+const (
+	DefaultBatchSize  = 512  // Spans ended before an export is started.
+	DefaultMaxPending = 4096 // Spans kept waiting for export before new ones are dropped.
+)
+
+// Attribute is a key and value describing a span. Values are strings, integers, floats or bools.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is a timed stage of the work done for a request. Spans of a request share a trace ID,
+// and each but the first has the span of the work it is part of as its parent. The methods of
+// a nil span do nothing, so code may be traced whether or not tracing is enabled.
+type Span struct {
+	TraceID   [16]byte
+	SpanID    [8]byte
+	ParentID  [8]byte // Zero for the first span of a trace.
+	Name      string
+	Kind      int
+	StartTime time.Time
+	EndTime   time.Time
+	Sampled   bool // The span is exported.
+
+	mu         sync.Mutex
+	attributes []Attribute
+	err        string // Why the work failed.
+	ended      bool
+	decided    bool // Sampled was decided by the parent.
+	tracer     *Tracer
+}
+
+// Remote returns an option of Start that makes the span the child of a span of another process,
+// such as the caller named in a traceparent header. sampled is the decision of the caller.
+func Remote(traceID [16]byte, parentID [8]byte, sampled bool) func(*Span) {
+	return func(sp *Span) {
+		sp.TraceID, sp.ParentID, sp.Sampled, sp.decided = traceID, parentID, sampled, true
+	}
+}
+
+// SetAttribute adds an attribute to the span.
+func (sp *Span) SetAttribute(key string, value interface{}) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if !sp.ended {
+		sp.attributes = append(sp.attributes, Attribute{key, value})
+	}
+}
+
+// SetError marks the work of the span as failed.
+func (sp *Span) SetError(err error) {
+	if sp == nil || err == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if !sp.ended {
+		sp.err = err.Error()
+	}
+}
+
+// Attributes returns the attributes of the span.
+func (sp *Span) Attributes() []Attribute {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return append([]Attribute(nil), sp.attributes...)
+}
+
+// Err returns why the work of the span failed, or "".
+func (sp *Span) Err() string {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.err
+}
+
+// End ends the span now. A span may only be ended once.
+func (sp *Span) End() {
+	sp.EndAt(time.Now())
+}
+
+// EndAt ends the span at time t, for work timed by other means.
+func (sp *Span) EndAt(t time.Time) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	if sp.ended {
+		sp.mu.Unlock()
+		return
+	}
+	sp.ended, sp.EndTime = true, t
+	sp.mu.Unlock()
+	if sp.Sampled {
+		sp.tracer.add(sp)
+	}
+}
+
+// Tracer starts spans and exports those that are sampled in batches. It is safe for concurrent
+// use.
+type Tracer struct {
+	Service     string  // service.name of the exported spans.
+	Version     string  // service.version of the exported spans.
+	SampleRatio float64 // Ratio of the traces started here that are sampled, from 0 to 1.
+	BatchSize   int     // Spans ended before an export is started.
+	MaxPending  int     // Spans kept waiting for export before new ones are dropped.
+
+	exporter Exporter
+	mu       sync.Mutex
+	pending  []*Span
+	exportMu sync.Mutex // Exports one batch at a time.
+	dropped  atomic.Int64
+}
+
+// NewTracer is a factory function that returns a tracer that exports spans to exporter.
+// options is an optional list of functions that initialize the structure.
+func NewTracer(exporter Exporter, options ...func(*Tracer)) *Tracer {
+	t := &Tracer{
+		SampleRatio: 1,
+		BatchSize:   DefaultBatchSize,
+		MaxPending:  DefaultMaxPending,
+		exporter:    exporter,
+	}
+	for _, f := range options {
+		f(t)
+	}
+	return t
+}
+
+// Start starts a span, as a child of the span in ctx if there is one, and returns a copy of ctx
+// carrying it. A span without a parent starts a trace, sampled by the ratio of the tracer.
+// options is an optional list of functions that initialize the span, e.g. Remote. If the tracer
+// is nil, ctx and a nil span are returned.
+func (t *Tracer) Start(ctx context.Context, name string, options ...func(*Span)) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	sp := &Span{Name: name, Kind: KindInternal, StartTime: time.Now(), tracer: t}
+	if parent := FromContext(ctx); parent != nil {
+		sp.TraceID, sp.ParentID, sp.Sampled, sp.decided = parent.TraceID, parent.SpanID, parent.Sampled, true
+	}
+	for _, f := range options {
+		f(sp)
+	}
+	if sp.TraceID == [16]byte{} {
+		rand.Read(sp.TraceID[:])
+	}
+	if sp.SpanID == [8]byte{} {
+		rand.Read(sp.SpanID[:])
+	}
+	if !sp.decided {
+		sp.Sampled = t.sample(sp.TraceID)
+	}
+	return NewContext(ctx, sp), sp
+}
+
+// Start starts a span as a child of the span in ctx. If ctx carries no span, ctx and a nil span
+// are returned, so that work is only traced as part of a traced request.
+func Start(ctx context.Context, name string, options ...func(*Span)) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, options...)
+}
+
+// sample returns true if a trace started here is sampled. The decision is made from the trace
+// ID so that it is the same wherever it is made.
+func (t *Tracer) sample(traceID [16]byte) bool {
+	switch {
+	case t.SampleRatio >= 1:
+		return true
+	case t.SampleRatio <= 0:
+		return false
+	}
+	return binary.BigEndian.Uint64(traceID[8:]) < uint64(t.SampleRatio*math.MaxUint64)
+}
+
+// add queues an ended span for export, and starts an export when a batch is ready.
+func (t *Tracer) add(sp *Span) {
+	t.mu.Lock()
+	if len(t.pending) >= t.MaxPending {
+		t.mu.Unlock()
+		t.dropped.Add(1)
+		return
+	}
+	t.pending = append(t.pending, sp)
+	full := len(t.pending) >= t.BatchSize
+	t.mu.Unlock()
+	if full {
+		go t.Flush()
+	}
+}
+
+// Flush exports the spans waiting for export.
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+	t.exportMu.Lock()
+	defer t.exportMu.Unlock()
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	return t.exporter.Export(t.exportRequest(spans))
+}
+
+// Dropped returns the number of spans dropped because too many were waiting for export.
+func (t *Tracer) Dropped() int64 {
+	return t.dropped.Load()
+}
+
+// spanContextKey is the key of the current span in a context.Context.
+type spanContextKey struct{}
+
+// NewContext returns a copy of ctx carrying a span.
+func NewContext(ctx context.Context, sp *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sp)
+}
+
+// FromContext returns the span carried by ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	sp, _ := ctx.Value(spanContextKey{}).(*Span)
+	return sp
+}
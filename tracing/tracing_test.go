@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryExporter keeps the exported spans.
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *memoryExporter) Export(req *ExportRequest) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			e.spans = append(e.spans, ss.Spans...)
+		}
+	}
+	return nil
+}
+
+func TestTracerStart(t *testing.T) {
+	t.Parallel()
+	exp := &memoryExporter{}
+	tr := NewTracer(exp, func(t *Tracer) { t.Service = "test" })
+	ctx, root := tr.Start(context.Background(), "request", func(sp *Span) { sp.Kind = KindServer })
+	if root.TraceID == [16]byte{} || root.SpanID == [8]byte{} || root.ParentID != [8]byte{} || !root.Sampled {
+		t.Fatalf("Root span not started: %+v", root)
+	}
+	if FromContext(ctx) != root {
+		t.Errorf("Context should carry the span.")
+	}
+	_, child := Start(ctx, "parse")
+	if child.TraceID != root.TraceID || child.ParentID != root.SpanID || child.SpanID == root.SpanID {
+		t.Errorf("Child span should continue the trace: %+v", child)
+	}
+	child.SetAttribute("bytes", 42)
+	child.SetError(errors.New("failed"))
+	child.End()
+	child.End() // Only the first end counts.
+	root.End()
+	if err := tr.Flush(); err != nil {
+		t.Fatalf("Cannot flush: %s", err)
+	}
+	if len(exp.spans) != 2 || exp.spans[0].Name != "parse" || exp.spans[0].Status.Code != statusError ||
+		*exp.spans[0].Attributes[0].Value.IntValue != "42" || exp.spans[1].Kind != KindServer {
+		t.Errorf("Spans not exported: %+v", exp.spans)
+	}
+
+	if _, sp := Start(context.Background(), "orphan"); sp != nil {
+		t.Errorf("Start without a span in the context should not trace.")
+	}
+	var nilTracer *Tracer
+	if _, sp := nilTracer.Start(context.Background(), "off"); sp != nil {
+		t.Errorf("A nil tracer should not trace.")
+	}
+	var sp *Span
+	sp.SetAttribute("a", 1) // Must not panic.
+	sp.End()
+}
+
+func TestTracerSampling(t *testing.T) {
+	t.Parallel()
+	exp := &memoryExporter{}
+	tr := NewTracer(exp, func(t *Tracer) { t.SampleRatio = 0 })
+	ctx, root := tr.Start(context.Background(), "request")
+	_, child := Start(ctx, "parse")
+	if root.Sampled || child.Sampled {
+		t.Errorf("Traces should not be sampled at a ratio of 0.")
+	}
+	child.End()
+	root.End()
+	tr.Flush()
+	if len(exp.spans) != 0 {
+		t.Errorf("Unsampled spans should not be exported: %d", len(exp.spans))
+	}
+
+	// The caller decides for the traces it started.
+	traceID, parentID := [16]byte{1}, [8]byte{2}
+	_, sp := tr.Start(context.Background(), "request", Remote(traceID, parentID, true))
+	if !sp.Sampled || sp.TraceID != traceID || sp.ParentID != parentID {
+		t.Errorf("Remote parent should be followed: %+v", sp)
+	}
+
+	tr.SampleRatio = 0.5
+	n := 0
+	for i := 0; i < 1000; i++ {
+		if _, sp := tr.Start(context.Background(), "request"); sp.Sampled {
+			n++
+		}
+	}
+	if n < 400 || n > 600 {
+		t.Errorf("About half the traces should be sampled: %d", n)
+	}
+}
+
+func TestTracerBatches(t *testing.T) {
+	t.Parallel()
+	exp := &memoryExporter{}
+	tr := NewTracer(exp, func(t *Tracer) {
+		t.BatchSize = 2
+		t.MaxPending = 3
+	})
+	tr.exportMu.Lock() // Hold up exports so spans pile up.
+	for i := 0; i < 5; i++ {
+		_, sp := tr.Start(context.Background(), "request")
+		sp.End()
+	}
+	if tr.Dropped() != 2 {
+		t.Errorf("Spans over the maximum pending should be dropped: %d", tr.Dropped())
+	}
+	tr.exportMu.Unlock()
+	for i := 0; i < 100; i++ {
+		exp.mu.Lock()
+		n := len(exp.spans)
+		exp.mu.Unlock()
+		if n == 3 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("A full batch should be exported without a flush.")
+}
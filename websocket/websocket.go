@@ -0,0 +1,379 @@
+// Package websocket implements the subset of the WebSocket protocol (RFC 6455) used by the
+// clidemo streaming API: the opening handshake, text and binary messages, fragmentation, and
+// the ping, pong and close control messages. Extensions and subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types.
+const (
+	continuationFrame = 0
+	TextMessage       = 1
+	BinaryMessage     = 2
+	CloseMessage      = 8
+	PingMessage       = 9
+	PongMessage       = 10
+)
+
+// Close status codes.
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseInvalidData   = 1007
+	ClosePolicy        = 1008
+	CloseTooLarge      = 1009
+)
+
+const (
+	DefaultMaxMessageSize = 1 << 20 // Largest message accepted unless configured otherwise.
+
+	acceptGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11" // See RFC 6455 section 1.3.
+	maxControlPayload = 125
+	closeTimeout      = time.Second // Time allowed to send a close message.
+)
+
+var (
+	ErrBadHandshake    = errors.New("Invalid websocket handshake.")
+	ErrNotHijacker     = errors.New("Websocket connection cannot be taken over.")
+	ErrMessageTooLarge = errors.New("Websocket message exceeds the maximum size.")
+	ErrProtocol        = errors.New("Websocket protocol error.")
+	ErrInvalidUTF8     = errors.New("Websocket text message is not valid UTF-8.")
+)
+
+// Conn is a websocket connection.
+type Conn struct {
+	MaxMessageSize int // Largest message accepted by ReadMessage.
+
+	conn      net.Conn
+	br        *bufio.Reader
+	client    bool       // Client connections mask the frames they send.
+	wmu       sync.Mutex // For serializing writes.
+	closeSent bool
+}
+
+// IsUpgrade returns true if the request asks to switch to the websocket protocol.
+func IsUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		headerHasToken(r.Header, "Connection", "upgrade")
+}
+
+// Upgrade performs the server side of the opening handshake and takes over the connection of
+// the request. Headers already set on w are sent with the handshake response. ErrBadHandshake
+// and ErrNotHijacker are returned before anything is written, so the caller can send the error
+// response; other errors mean the connection was taken over and has been closed.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); r.Method != "GET" || !IsUpgrade(r) ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" || err != nil || len(k) != 16 {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, ErrBadHandshake
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, ErrNotHijacker
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{}) // Clear any HTTP server timeouts.
+
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	fmt.Fprintf(&b, "Sec-WebSocket-Accept: %s\r\n", acceptKey(key))
+	for k, vs := range w.Header() {
+		if k == "Content-Type" || k == "Content-Length" {
+			continue
+		}
+		for _, v := range vs {
+			fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+		}
+	}
+	b.WriteString("\r\n")
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newConn(conn, rw.Reader, false), nil
+}
+
+// Dial performs the client side of the opening handshake with the ws:// URL. Header holds any
+// additional request headers, such as Authorization. The handshake response is returned when
+// one was received, including on a failed handshake.
+func Dial(rawurl string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, nil, fmt.Errorf("Unsupported websocket URL scheme %q.", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	k := make([]byte, 16)
+	rand.Read(k)
+	key := base64.StdEncoding.EncodeToString(k)
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Scheme: "http", Host: u.Host, Path: u.Path, RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: make(http.Header),
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, resp, ErrBadHandshake
+	}
+	return newConn(conn, br, true), resp, nil
+}
+
+// newConn is a factory function that returns a websocket over an established connection.
+func newConn(conn net.Conn, br *bufio.Reader, client bool) *Conn {
+	return &Conn{
+		MaxMessageSize: DefaultMaxMessageSize,
+		conn:           conn,
+		br:             br,
+		client:         client,
+	}
+}
+
+// ReadMessage returns the next text or binary message. Control messages are answered as they
+// arrive. io.EOF is returned once the peer has closed the websocket.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	msgType := 0
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case PingMessage:
+			if err := c.WriteMessage(PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.CloseWithCode(code, "")
+			return 0, nil, io.EOF
+		case TextMessage, BinaryMessage:
+			if msgType != 0 {
+				return 0, nil, ErrProtocol
+			}
+			msgType = op
+			msg = payload
+		case continuationFrame:
+			if msgType == 0 {
+				return 0, nil, ErrProtocol
+			}
+			if len(msg)+len(payload) > c.MaxMessageSize {
+				return 0, nil, ErrMessageTooLarge
+			}
+			msg = append(msg, payload...)
+		default:
+			return 0, nil, ErrProtocol
+		}
+		if fin {
+			if msgType == TextMessage && !utf8.Valid(msg) {
+				return 0, nil, ErrInvalidUTF8
+			}
+			return msgType, msg, nil
+		}
+	}
+}
+
+// WriteMessage sends a message of type op. It is safe to call from several go routines.
+func (c *Conn) WriteMessage(op int, data []byte) error {
+	if (op == CloseMessage || op == PingMessage || op == PongMessage) && len(data) > maxControlPayload {
+		return ErrMessageTooLarge
+	}
+	hdr := make([]byte, 2, 14)
+	hdr[0] = 0x80 | byte(op) // Always a single final frame.
+	switch n := len(data); {
+	case n <= maxControlPayload:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = append(hdr, 0, 0)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = append(hdr, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+	frame := data
+	if c.client {
+		hdr[1] |= 0x80
+		mask := make([]byte, 4)
+		rand.Read(mask)
+		hdr = append(hdr, mask...)
+		frame = make([]byte, len(data))
+		copy(frame, data)
+		maskBytes(mask, frame)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	if op == CloseMessage {
+		c.closeSent = true
+	}
+	if _, err := c.conn.Write(append(hdr, frame...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CloseWithCode sends a close message with a status code and reason, then closes the connection.
+func (c *Conn) CloseWithCode(code int, reason string) error {
+	b := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(b, uint16(code))
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	c.WriteMessage(CloseMessage, append(b, reason...))
+	return c.conn.Close()
+}
+
+// Close sends a normal close message and closes the connection.
+func (c *Conn) Close() error {
+	return c.CloseWithCode(CloseNormal, "")
+}
+
+// SetReadDeadline sets the time by which the next message must be read.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// readFrame reads a single frame, unmasking its payload.
+func (c *Conn) readFrame() (fin bool, op int, payload []byte, err error) {
+	hdr := make([]byte, 2)
+	if _, err = io.ReadFull(c.br, hdr); err != nil {
+		return
+	}
+	fin = hdr[0]&0x80 != 0
+	op = int(hdr[0] & 0x0F)
+	masked := hdr[1]&0x80 != 0
+	if hdr[0]&0x70 != 0 || masked == c.client {
+		return false, 0, nil, ErrProtocol // No extensions; only clients mask frames.
+	}
+
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		b := make([]byte, 2)
+		if _, err = io.ReadFull(c.br, b); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b))
+	case 127:
+		b := make([]byte, 8)
+		if _, err = io.ReadFull(c.br, b); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b)
+	}
+	if op >= CloseMessage && (n > maxControlPayload || !fin) {
+		return false, 0, nil, ErrProtocol
+	}
+	if n > uint64(c.MaxMessageSize) {
+		return false, 0, nil, ErrMessageTooLarge
+	}
+
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err = io.ReadFull(c.br, mask); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		maskBytes(mask, payload)
+	}
+	return fin, op, payload, nil
+}
+
+// maskBytes applies the masking key to b in place.
+func maskBytes(mask []byte, b []byte) {
+	for i := range b {
+		b[i] ^= mask[i%4]
+	}
+}
+
+// acceptKey returns the Sec-WebSocket-Accept value for a Sec-WebSocket-Key.
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerHasToken returns true if the comma separated header contains token.
+func headerHasToken(h http.Header, name string, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echoServer returns a test server echoing back websocket messages.
+func echoServer(t *testing.T) (*httptest.Server, string) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "handshake")
+		c, err := Upgrade(w, r)
+		if err == ErrBadHandshake {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			return
+		}
+		c.MaxMessageSize = 1024
+		for {
+			op, msg, err := c.ReadMessage()
+			switch err {
+			case nil:
+				c.WriteMessage(op, msg)
+				continue
+			case ErrMessageTooLarge:
+				c.CloseWithCode(CloseTooLarge, err.Error())
+			default:
+				c.CloseWithCode(CloseProtocolError, "")
+			}
+			return
+		}
+	}))
+	return srv, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestWebsocketHandshake(t *testing.T) {
+	t.Parallel()
+	srv, u := echoServer(t)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("Plain HTTP request should be refused: %d", resp.StatusCode)
+	}
+
+	c, resp, err := Dial(u, http.Header{"Authorization": {"Bearer X"}})
+	if err != nil {
+		t.Fatalf("Handshake failed: %s", err)
+	}
+	defer c.Close()
+	if resp.Header.Get("X-Test") != "handshake" {
+		t.Errorf("Headers set before the upgrade should be sent with the handshake.")
+	}
+	if _, _, err := Dial("http"+strings.TrimPrefix(u, "ws"), nil); err == nil {
+		t.Errorf("Only ws:// URLs should be dialed.")
+	}
+}
+
+func TestWebsocketMessages(t *testing.T) {
+	t.Parallel()
+	srv, u := echoServer(t)
+	defer srv.Close()
+	c, _, err := Dial(u, nil)
+	if err != nil {
+		t.Fatalf("Handshake failed: %s", err)
+	}
+	defer c.Close()
+
+	for _, m := range []string{"", "short", strings.Repeat("x", 200)} {
+		c.WriteMessage(TextMessage, []byte(m))
+		op, msg, err := c.ReadMessage()
+		if err != nil || op != TextMessage || string(msg) != m {
+			t.Errorf("Text message of %d bytes not echoed: %v", len(m), err)
+		}
+	}
+	c.WriteMessage(BinaryMessage, []byte{0xFF, 0x00})
+	if op, msg, _ := c.ReadMessage(); op != BinaryMessage || !bytes.Equal(msg, []byte{0xFF, 0x00}) {
+		t.Errorf("Binary message not echoed.")
+	}
+
+	// Fragmented messages with an interleaved ping.
+	c.wmu.Lock()
+	c.conn.Write(clientFrame(false, TextMessage, []byte("frag")))
+	c.conn.Write(clientFrame(true, PingMessage, []byte("ping")))
+	c.conn.Write(clientFrame(true, continuationFrame, []byte("mented")))
+	c.wmu.Unlock()
+	fin, op, msg, err := c.readFrame()
+	if err != nil || !fin || op != PongMessage || string(msg) != "ping" {
+		t.Errorf("Ping not answered with a pong.")
+	}
+	if op, msg, _ := c.ReadMessage(); op != TextMessage || string(msg) != "fragmented" {
+		t.Errorf("Fragmented message not reassembled: %q", msg)
+	}
+}
+
+func TestWebsocketErrors(t *testing.T) {
+	t.Parallel()
+	srv, u := echoServer(t)
+	defer srv.Close()
+
+	c, _, _ := Dial(u, nil)
+	c.WriteMessage(TextMessage, make([]byte, 2048))
+	if _, _, err := c.ReadMessage(); err != io.EOF {
+		t.Errorf("Large message should close the websocket: %v", err)
+	}
+
+	c, _, _ = Dial(u, nil)
+	c.WriteMessage(TextMessage, []byte{0xFF, 0xFE})
+	fin, op, msg, _ := c.readFrame()
+	if !fin || op != CloseMessage || binary.BigEndian.Uint16(msg) != CloseProtocolError {
+		t.Errorf("Invalid UTF-8 text should close the websocket.")
+	}
+	c.conn.Close()
+
+	c, _, _ = Dial(u, nil)
+	c.conn.Write(clientFrame(true, continuationFrame, []byte("orphan")))
+	if _, _, err := c.ReadMessage(); err != io.EOF {
+		t.Errorf("Orphan continuation frame should close the websocket: %v", err)
+	}
+	if err := c.WriteMessage(PingMessage, make([]byte, 200)); err != ErrMessageTooLarge {
+		t.Errorf("Large control message should not be sent.")
+	}
+}
+
+// clientFrame returns a masked frame as sent by a client.
+func clientFrame(fin bool, op int, payload []byte) []byte {
+	b := []byte{byte(op), 0x80 | byte(len(payload)), 1, 2, 3, 4}
+	if fin {
+		b[0] |= 0x80
+	}
+	p := append([]byte{}, payload...)
+	maskBytes(b[2:6], p)
+	return append(b, p...)
+}
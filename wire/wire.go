@@ -0,0 +1,71 @@
+// Package wire implements the length-prefixed framing of the clidemo socket protocol.
+//
+// Each frame is a 4 byte big-endian payload length, a 1 byte frame type, then the payload.
+// A client first sends an Auth frame holding its bearer token and the server answers OK or
+// Error. Each Parse frame holding text is then answered with a Result frame holding the JSON
+// result, or an Error frame holding a message.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Frame types.
+const (
+	TypeAuth   byte = 0x01 // Client: bearer token.
+	TypeParse  byte = 0x02 // Client: text to parse.
+	TypeOK     byte = 0x80 // Server: request accepted, no payload.
+	TypeResult byte = 0x81 // Server: parse result.
+	TypeError  byte = 0xFF // Server: error message.
+)
+
+const (
+	HeaderSize          = 5        // Length plus type.
+	DefaultMaxFrameSize = 16 << 20 // Largest payload accepted unless configured otherwise.
+)
+
+var (
+	ErrFrameTooLarge = errors.New("Frame exceeds the maximum size.")
+)
+
+// Frame is a single message of the socket protocol.
+type Frame struct {
+	Type    byte   // One of the Type constants.
+	Payload []byte // Frame content.
+}
+
+// WriteFrame writes a frame of typ holding payload to w.
+func WriteFrame(w io.Writer, typ byte, payload []byte) error {
+	b := make([]byte, HeaderSize+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(len(payload)))
+	b[4] = typ
+	copy(b[HeaderSize:], payload)
+	_, err := w.Write(b)
+	return err
+}
+
+// ReadFrame reads the next frame from r. Frames with a payload larger than max bytes are
+// rejected with ErrFrameTooLarge without reading the payload.
+func ReadFrame(r io.Reader, max int) (*Frame, error) {
+	hdr := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr)
+	if int64(n) > int64(max) {
+		return nil, ErrFrameTooLarge
+	}
+	f := &Frame{
+		Type:    hdr[4],
+		Payload: make([]byte, n),
+	}
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,48 @@
+package wire
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestWriteReadFrame(t *testing.T) {
+	t.Parallel()
+	var b bytes.Buffer
+	WriteFrame(&b, TypeParse, []byte("Now is the winter."))
+	WriteFrame(&b, TypeOK, nil)
+	if b.Len() != 2*HeaderSize+18 {
+		t.Errorf("Frames written with invalid size %d.", b.Len())
+	}
+
+	f, err := ReadFrame(&b, DefaultMaxFrameSize)
+	if err != nil {
+		t.Fatalf("Frame not read: %s", err)
+	}
+	if f.Type != TypeParse || string(f.Payload) != "Now is the winter." {
+		t.Errorf("Frame read incorrectly: %x %q", f.Type, f.Payload)
+	}
+	f, err = ReadFrame(&b, DefaultMaxFrameSize)
+	if err != nil || f.Type != TypeOK || len(f.Payload) != 0 {
+		t.Errorf("Empty frame read incorrectly.")
+	}
+	if _, err = ReadFrame(&b, DefaultMaxFrameSize); err != io.EOF {
+		t.Errorf("End of stream should return io.EOF: %v", err)
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	t.Parallel()
+	var b bytes.Buffer
+	WriteFrame(&b, TypeParse, []byte("0123456789"))
+	if _, err := ReadFrame(&b, 9); err != ErrFrameTooLarge {
+		t.Errorf("Large frame should be rejected: %v", err)
+	}
+
+	b.Reset()
+	WriteFrame(&b, TypeParse, []byte("0123456789"))
+	b.Truncate(HeaderSize + 5)
+	if _, err := ReadFrame(&b, DefaultMaxFrameSize); err != io.ErrUnexpectedEOF {
+		t.Errorf("Truncated frame should return io.ErrUnexpectedEOF: %v", err)
+	}
+}